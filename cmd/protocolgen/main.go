@@ -0,0 +1,91 @@
+// Command protocolgen generates the protocol JSON Schema, TypeScript
+// definitions and reference doc from the message types in server/protocol.go.
+//
+//	go run ./cmd/protocolgen          # rewrite the generated files
+//	go run ./cmd/protocolgen -check   # fail if they are out of date
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Generated files, relative to the repository root
+const (
+	SchemaFile     = "docs/protocol.schema.json"
+	TypeScriptFile = "static/protocol.d.ts"
+	ReferenceFile  = "docs/PROTOCOL.md"
+)
+
+func main() {
+	root := flag.String("root", ".", "Repository root")
+	check := flag.Bool("check", false, "Report out-of-date files instead of writing them")
+	flag.Parse()
+
+	files, err := generate(*root)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *check {
+		stale := staleFiles(*root, files)
+		for _, name := range stale {
+			fmt.Fprintf(os.Stderr, "%s is out of date; run go run ./cmd/protocolgen\n", name)
+		}
+		if len(stale) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	for name, data := range files {
+		path := filepath.Join(*root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			log.Fatal(err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("Wrote %s", name)
+	}
+}
+
+// generate renders every generated file from the protocol types
+func generate(root string) (map[string][]byte, error) {
+	docs, err := parseDocs(root)
+	if err != nil {
+		return nil, err
+	}
+	m, err := buildModel(docs)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := renderSchema(m)
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]byte{
+		SchemaFile:     schema,
+		TypeScriptFile: renderTypeScript(m),
+		ReferenceFile:  renderReference(m),
+	}, nil
+}
+
+// staleFiles returns the generated files whose committed copy differs
+func staleFiles(root string, files map[string][]byte) []string {
+	var stale []string
+	for name, want := range files {
+		have, err := os.ReadFile(filepath.Join(root, name))
+		if err != nil || !bytes.Equal(have, want) {
+			stale = append(stale, name)
+		}
+	}
+	sort.Strings(stale)
+	return stale
+}
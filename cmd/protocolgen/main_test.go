@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"setback/server"
+)
+
+const repoRoot = "../.."
+
+func TestGeneratedFilesUpToDate(t *testing.T) {
+	files, err := generate(repoRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range staleFiles(repoRoot, files) {
+		t.Errorf("%s is out of date; run go generate ./server (or go run ./cmd/protocolgen)", name)
+	}
+}
+
+func TestEveryMessageTypeIsListed(t *testing.T) {
+	docs, err := parseDocs(repoRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listed := make(map[server.MessageType]int)
+	for _, mt := range server.ClientMessageTypes {
+		listed[mt]++
+	}
+	for _, mt := range server.ServerMessageTypes {
+		listed[mt]++
+	}
+
+	declared := docs.consts["server.MessageType"]
+	if len(declared) == 0 {
+		t.Fatal("No MessageType constants found")
+	}
+	for _, c := range declared {
+		mt := server.MessageType(c.Value.(string))
+		if listed[mt] != 1 {
+			t.Errorf("%s must be in exactly one of ClientMessageTypes and ServerMessageTypes", mt)
+		}
+	}
+	if len(listed) != len(declared) {
+		t.Errorf("%d message types listed, %d declared", len(listed), len(declared))
+	}
+}
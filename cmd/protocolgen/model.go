@@ -0,0 +1,373 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"setback/game"
+	"setback/server"
+)
+
+// modulePath is stripped from package paths to find their source directory
+const modulePath = "setback/"
+
+// sourceDocs holds doc comments from the Go source, which reflection can't see
+type sourceDocs struct {
+	types  map[string]string      // "server.ClientMessage" -> doc
+	fields map[string]string      // "server.ClientMessage.Type" -> doc
+	consts map[string][]enumValue // "game.Phase" -> string constants in declaration order
+}
+
+// parseDocs reads the doc comments of the packages the protocol uses
+func parseDocs(root string) (*sourceDocs, error) {
+	docs := &sourceDocs{
+		types:  make(map[string]string),
+		fields: make(map[string]string),
+		consts: make(map[string][]enumValue),
+	}
+	for _, pkg := range []string{"server", "game"} {
+		dir := filepath.Join(root, pkg)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		fset := token.NewFileSet()
+		for _, e := range entries {
+			name := e.Name()
+			if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+				continue
+			}
+			f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, parser.ParseComments)
+			if err != nil {
+				return nil, err
+			}
+			docs.addFile(pkg, f)
+		}
+	}
+	return docs, nil
+}
+
+func (d *sourceDocs) addFile(pkg string, f *ast.File) {
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			switch spec := spec.(type) {
+			case *ast.TypeSpec:
+				doc := commentText(spec.Doc)
+				if doc == "" && len(gen.Specs) == 1 {
+					doc = commentText(gen.Doc)
+				}
+				key := pkg + "." + spec.Name.Name
+				d.types[key] = doc
+				if st, ok := spec.Type.(*ast.StructType); ok {
+					for _, field := range st.Fields.List {
+						text := commentText(field.Doc)
+						if text == "" {
+							text = commentText(field.Comment)
+						}
+						for _, name := range field.Names {
+							d.fields[key+"."+name.Name] = text
+						}
+					}
+				}
+			case *ast.ValueSpec:
+				ident, ok := spec.Type.(*ast.Ident)
+				if gen.Tok != token.CONST || !ok || len(spec.Values) != len(spec.Names) {
+					continue
+				}
+				doc := commentText(spec.Comment)
+				if doc == "" {
+					doc = commentText(spec.Doc)
+				}
+				for _, v := range spec.Values {
+					lit, ok := v.(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					value, err := strconv.Unquote(lit.Value)
+					if err != nil {
+						continue
+					}
+					key := pkg + "." + ident.Name
+					d.consts[key] = append(d.consts[key], enumValue{Value: value, Doc: doc})
+				}
+			}
+		}
+	}
+}
+
+// commentText flattens a comment group to a single line
+func commentText(cg *ast.CommentGroup) string {
+	if cg == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(cg.Text()), " ")
+}
+
+// typeDef is a named protocol type: an object with fields, or an enum
+type typeDef struct {
+	Name   string
+	Doc    string
+	Fields []fieldDef  // Objects
+	Base   string      // Enums: "string" or "integer"
+	Enum   []enumValue // Enums
+}
+
+type enumValue struct {
+	Value any
+	Doc   string
+}
+
+type fieldDef struct {
+	Name     string // JSON name
+	Doc      string
+	Type     *typeRef
+	Optional bool // omitempty: may be absent
+}
+
+// typeRef describes a value's type. Kind is one of "string", "integer",
+// "number", "boolean", "time", "any", "ref", "array" and "map".
+type typeRef struct {
+	Kind     string
+	Ref      *typeDef // ref
+	Elem     *typeRef // array, map
+	Len      int      // Fixed-length arrays; 0 if any length
+	Unsigned bool     // integer
+	Nullable bool     // May be JSON null
+}
+
+// model is every type reachable from ClientMessage and ServerMessage,
+// in the order they were first reached
+type model struct {
+	Types          []*typeDef
+	ClientMessages *typeDef
+	ServerMessages *typeDef
+	docs           *sourceDocs
+	byType         map[reflect.Type]*typeDef
+}
+
+// intEnums supplies values for integer enums, which use iota and so
+// can't be read from the source
+var intEnums = map[reflect.Type][]enumValue{
+	reflect.TypeOf(game.Suit(0)): suitValues(),
+	reflect.TypeOf(game.Rank(0)): rankValues(),
+}
+
+func suitValues() []enumValue {
+	var values []enumValue
+	for _, s := range game.AllSuits() {
+		values = append(values, enumValue{Value: int(s), Doc: s.String()})
+	}
+	return values
+}
+
+func rankValues() []enumValue {
+	var values []enumValue
+	for _, r := range game.AllRanks() {
+		values = append(values, enumValue{Value: int(r), Doc: r.String()})
+	}
+	return values
+}
+
+var (
+	timeType    = reflect.TypeOf(time.Time{})
+	messageType = reflect.TypeOf(server.MessageType(""))
+)
+
+// buildModel walks the protocol types from the two message roots
+func buildModel(docs *sourceDocs) (*model, error) {
+	m := &model{docs: docs, byType: make(map[reflect.Type]*typeDef)}
+
+	m.ClientMessages = messageTypeEnum("ClientMessageType", "Messages a client may send", server.ClientMessageTypes, docs)
+	m.ServerMessages = messageTypeEnum("ServerMessageType", "Messages the server may send", server.ServerMessageTypes, docs)
+	m.Types = append(m.Types, m.ClientMessages, m.ServerMessages)
+
+	for _, root := range []any{server.ClientMessage{}, server.ServerMessage{}} {
+		if _, err := m.ref(reflect.TypeOf(root)); err != nil {
+			return nil, err
+		}
+	}
+
+	// The message "type" field is narrowed to the messages that direction may carry
+	for _, def := range m.Types {
+		for i, f := range def.Fields {
+			if f.Name != "type" {
+				continue
+			}
+			switch def.Name {
+			case "ClientMessage":
+				def.Fields[i].Type = &typeRef{Kind: "ref", Ref: m.ClientMessages}
+			case "ServerMessage":
+				def.Fields[i].Type = &typeRef{Kind: "ref", Ref: m.ServerMessages}
+			}
+		}
+	}
+	return m, nil
+}
+
+// messageTypeEnum builds an enum of message types, documented from their constants
+func messageTypeEnum(name, doc string, types []server.MessageType, docs *sourceDocs) *typeDef {
+	constDocs := make(map[any]string)
+	for _, v := range docs.consts["server.MessageType"] {
+		constDocs[v.Value] = v.Doc
+	}
+	def := &typeDef{Name: name, Doc: doc, Base: "string"}
+	for _, t := range types {
+		def.Enum = append(def.Enum, enumValue{Value: string(t), Doc: constDocs[string(t)]})
+	}
+	return def
+}
+
+// docKey is the sourceDocs key for a named Go type
+func docKey(t reflect.Type) string {
+	return strings.TrimPrefix(t.PkgPath(), modulePath) + "." + t.Name()
+}
+
+// ref returns the typeRef for a Go type, defining named types on first use
+func (m *model) ref(t reflect.Type) (*typeRef, error) {
+	if t == timeType {
+		return &typeRef{Kind: "time"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		elem, err := m.ref(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		r := *elem
+		r.Nullable = true
+		return &r, nil
+	case reflect.Slice:
+		elem, err := m.ref(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &typeRef{Kind: "array", Elem: elem, Nullable: true}, nil
+	case reflect.Array:
+		elem, err := m.ref(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &typeRef{Kind: "array", Elem: elem, Len: t.Len()}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map key %s is not a string", t.Key())
+		}
+		elem, err := m.ref(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &typeRef{Kind: "map", Elem: elem, Nullable: true}, nil
+	case reflect.Interface:
+		return &typeRef{Kind: "any"}, nil
+	case reflect.Struct:
+		def, err := m.defineStruct(t)
+		if err != nil {
+			return nil, err
+		}
+		return &typeRef{Kind: "ref", Ref: def}, nil
+	}
+
+	base := ""
+	r := &typeRef{}
+	switch t.Kind() {
+	case reflect.String:
+		base = "string"
+	case reflect.Bool:
+		base = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		base = "integer"
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		base = "integer"
+		r.Unsigned = true
+	case reflect.Float32, reflect.Float64:
+		base = "number"
+	default:
+		return nil, fmt.Errorf("unsupported type %s", t)
+	}
+	r.Kind = base
+
+	// Named types with known values become enums. MessageType is split
+	// into the client and server enums instead.
+	if t == messageType {
+		return r, nil
+	}
+	if def, ok := m.byType[t]; ok {
+		return &typeRef{Kind: "ref", Ref: def}, nil
+	}
+	values := intEnums[t]
+	if t.PkgPath() != "" && values == nil {
+		values = m.docs.consts[docKey(t)]
+	}
+	if len(values) == 0 {
+		return r, nil
+	}
+	def := &typeDef{Name: t.Name(), Doc: m.docs.types[docKey(t)], Base: base, Enum: values}
+	m.byType[t] = def
+	m.Types = append(m.Types, def)
+	return &typeRef{Kind: "ref", Ref: def}, nil
+}
+
+// defineStruct defines an object type from a struct's exported, JSON-visible fields
+func (m *model) defineStruct(t reflect.Type) (*typeDef, error) {
+	if def, ok := m.byType[t]; ok {
+		return def, nil
+	}
+	def := &typeDef{Name: t.Name(), Doc: m.docs.types[docKey(t)]}
+	for _, other := range m.Types {
+		if other.Name == def.Name {
+			return nil, fmt.Errorf("two protocol types are named %s", def.Name)
+		}
+	}
+	m.byType[t] = def
+	m.Types = append(m.Types, def)
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		if sf.Anonymous {
+			return nil, fmt.Errorf("%s: embedded fields are not supported", t)
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = sf.Name
+		}
+
+		ref, err := m.ref(sf.Type)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), sf.Name, err)
+		}
+		optional := strings.Contains(","+opts+",", ",omitempty,")
+		if optional {
+			// omitempty drops nil pointers, slices and maps rather than sending null
+			r := *ref
+			r.Nullable = false
+			ref = &r
+		}
+		def.Fields = append(def.Fields, fieldDef{
+			Name:     name,
+			Doc:      m.docs.fields[docKey(t)+"."+sf.Name],
+			Type:     ref,
+			Optional: optional,
+		})
+	}
+	return def, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"setback/game"
+	"setback/server"
+)
+
+// renderReference renders the model as a Markdown protocol reference
+func renderReference(m *model) []byte {
+	var b strings.Builder
+	b.WriteString("<!-- Code generated by cmd/protocolgen from server/protocol.go. DO NOT EDIT. -->\n\n")
+	fmt.Fprintf(&b, "# Setback WebSocket Protocol v%d\n\n", server.ProtocolVersion)
+	versions := fmt.Sprint(server.ProtocolVersion)
+	if server.MinProtocolVersion != server.ProtocolVersion {
+		versions = fmt.Sprintf("%d-%d", server.MinProtocolVersion, server.ProtocolVersion)
+	}
+	fmt.Fprintf(&b, "Clients connect to `/ws` and exchange JSON text messages: `ClientMessage` up, `ServerMessage` down. "+
+		"The first message must be `hello`; the server accepts protocol version %s.\n\n", versions)
+	fmt.Fprintf(&b, "- Optional features: %s\n", codeList(server.ServerFeatures))
+	fmt.Fprintf(&b, "- Rule variants: %s\n\n", codeList(server.RuleVariants))
+	b.WriteString("Machine-readable versions: [`protocol.schema.json`](protocol.schema.json) (JSON Schema) and " +
+		"[`static/protocol.d.ts`](../static/protocol.d.ts) (TypeScript).\n\n")
+
+	b.WriteString("## Client → Server Messages\n\n")
+	writeEnumTable(&b, m.ClientMessages, "Type")
+	b.WriteString("## Server → Client Messages\n\n")
+	writeEnumTable(&b, m.ServerMessages, "Type")
+
+	b.WriteString("## Types\n\n")
+	for _, def := range m.Types {
+		if def == m.ClientMessages || def == m.ServerMessages {
+			continue
+		}
+		fmt.Fprintf(&b, "### %s\n\n", def.Name)
+		if def.Doc != "" {
+			b.WriteString(def.Doc + "\n\n")
+		}
+		if def.Enum != nil {
+			writeEnumTable(&b, def, "Value")
+			continue
+		}
+		b.WriteString("| Field | Type | Required | Description |\n|---|---|---|---|\n")
+		for _, f := range def.Fields {
+			required := "yes"
+			if f.Optional {
+				required = "no"
+			}
+			fmt.Fprintf(&b, "| `%s` | %s | %s | %s |\n", f.Name, mdType(f.Type), required, mdEscape(f.Doc))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("## Error Codes\n\n")
+	b.WriteString("Every `error` message has a stable `code`; `message` is English text that may change. " +
+		"Some codes carry `params` for building a localized message.\n\n")
+	codeDocs := make(map[any]string)
+	for _, v := range m.docs.consts["game.ErrorCode"] {
+		codeDocs[v.Value] = v.Doc
+	}
+	b.WriteString("| Code | Message | Params |\n|---|---|---|\n")
+	for _, e := range append(append([]*game.Error{}, game.Errors...), server.Errors...) {
+		params := strings.TrimPrefix(codeDocs[string(e.Code)], "Params: ")
+		if params == "" && len(e.Params) > 0 {
+			keys := make([]string, 0, len(e.Params))
+			for k := range e.Params {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			params = strings.Join(keys, ", ")
+		}
+		fmt.Fprintf(&b, "| `%s` | %s | %s |\n", e.Code, mdEscape(e.Message), params)
+	}
+	return []byte(b.String())
+}
+
+func writeEnumTable(b *strings.Builder, def *typeDef, heading string) {
+	fmt.Fprintf(b, "| %s | Description |\n|---|---|\n", heading)
+	for _, v := range def.Enum {
+		fmt.Fprintf(b, "| `%v` | %s |\n", v.Value, mdEscape(v.Doc))
+	}
+	b.WriteString("\n")
+}
+
+func mdType(r *typeRef) string {
+	var t string
+	switch r.Kind {
+	case "ref":
+		t = fmt.Sprintf("[%s](#%s)", r.Ref.Name, strings.ToLower(r.Ref.Name))
+	case "time":
+		t = "string (date-time)"
+	case "array":
+		if r.Len > 0 {
+			t = fmt.Sprintf("%s[%d]", mdType(r.Elem), r.Len)
+		} else {
+			t = mdType(r.Elem) + "[]"
+		}
+	case "map":
+		t = "map of " + mdType(r.Elem)
+	default:
+		t = r.Kind
+	}
+	if r.Nullable {
+		t += " or null"
+	}
+	return t
+}
+
+func codeList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, s := range items {
+		quoted[i] = "`" + s + "`"
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func mdEscape(s string) string {
+	return strings.ReplaceAll(s, "|", `\|`)
+}
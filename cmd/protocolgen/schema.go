@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"setback/server"
+)
+
+// renderSchema renders the model as a JSON Schema (draft 2020-12) document.
+// Map keys are sorted by encoding/json, so the output is stable.
+func renderSchema(m *model) ([]byte, error) {
+	defs := make(map[string]any)
+	for _, def := range m.Types {
+		defs[def.Name] = defSchema(def)
+	}
+
+	root := map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       fmt.Sprintf("Setback WebSocket protocol v%d", server.ProtocolVersion),
+		"description": "Generated by cmd/protocolgen from the types in server/protocol.go. Do not edit.",
+		"anyOf": []any{
+			map[string]any{"$ref": "#/$defs/ClientMessage"},
+			map[string]any{"$ref": "#/$defs/ServerMessage"},
+		},
+		"$defs": defs,
+	}
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+func defSchema(def *typeDef) map[string]any {
+	s := map[string]any{}
+	if def.Doc != "" {
+		s["description"] = def.Doc
+	}
+
+	if def.Enum != nil {
+		s["type"] = def.Base
+		values := make([]any, 0, len(def.Enum))
+		for _, v := range def.Enum {
+			values = append(values, v.Value)
+		}
+		s["enum"] = values
+		return s
+	}
+
+	s["type"] = "object"
+	props := make(map[string]any)
+	required := []string{}
+	for _, f := range def.Fields {
+		prop := refSchema(f.Type)
+		if f.Doc != "" {
+			prop["description"] = f.Doc
+		}
+		props[f.Name] = prop
+		if !f.Optional {
+			required = append(required, f.Name)
+		}
+	}
+	s["properties"] = props
+	s["required"] = required
+	return s
+}
+
+func refSchema(r *typeRef) map[string]any {
+	var s map[string]any
+	switch r.Kind {
+	case "ref":
+		s = map[string]any{"$ref": "#/$defs/" + r.Ref.Name}
+	case "time":
+		s = map[string]any{"type": "string", "format": "date-time"}
+	case "any":
+		s = map[string]any{}
+	case "array":
+		s = map[string]any{"type": "array", "items": refSchema(r.Elem)}
+		if r.Len > 0 {
+			s["minItems"] = r.Len
+			s["maxItems"] = r.Len
+		}
+	case "map":
+		s = map[string]any{"type": "object", "additionalProperties": refSchema(r.Elem)}
+	default:
+		s = map[string]any{"type": r.Kind}
+		if r.Unsigned {
+			s["minimum"] = 0
+		}
+	}
+
+	if r.Nullable {
+		return map[string]any{"anyOf": []any{s, map[string]any{"type": "null"}}}
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"setback/server"
+)
+
+// renderTypeScript renders the model as TypeScript declarations
+func renderTypeScript(m *model) []byte {
+	var b strings.Builder
+	b.WriteString("// Code generated by cmd/protocolgen from server/protocol.go. DO NOT EDIT.\n")
+	fmt.Fprintf(&b, "// Setback WebSocket protocol v%d\n", server.ProtocolVersion)
+
+	for _, def := range m.Types {
+		b.WriteString("\n")
+		writeTSDoc(&b, "", def.Doc)
+		if def.Enum != nil {
+			values := make([]string, 0, len(def.Enum))
+			for _, v := range def.Enum {
+				values = append(values, tsLiteral(v.Value))
+			}
+			fmt.Fprintf(&b, "export type %s = %s;\n", def.Name, strings.Join(values, " | "))
+			continue
+		}
+
+		fmt.Fprintf(&b, "export interface %s {\n", def.Name)
+		for _, f := range def.Fields {
+			writeTSDoc(&b, "    ", f.Doc)
+			optional := ""
+			if f.Optional {
+				optional = "?"
+			}
+			fmt.Fprintf(&b, "    %s%s: %s;\n", f.Name, optional, tsType(f.Type))
+		}
+		b.WriteString("}\n")
+	}
+	return []byte(b.String())
+}
+
+func writeTSDoc(b *strings.Builder, indent, doc string) {
+	if doc != "" {
+		fmt.Fprintf(b, "%s/** %s */\n", indent, strings.ReplaceAll(doc, "*/", "* /"))
+	}
+}
+
+func tsLiteral(v any) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+func tsType(r *typeRef) string {
+	var t string
+	switch r.Kind {
+	case "ref":
+		t = r.Ref.Name
+	case "string", "time":
+		t = "string"
+	case "integer", "number":
+		t = "number"
+	case "boolean":
+		t = "boolean"
+	case "any":
+		t = "unknown"
+	case "array":
+		elem := tsType(r.Elem)
+		if strings.Contains(elem, " ") {
+			elem = "(" + elem + ")"
+		}
+		if r.Len > 0 {
+			parts := make([]string, r.Len)
+			for i := range parts {
+				parts[i] = tsType(r.Elem)
+			}
+			t = "[" + strings.Join(parts, ", ") + "]"
+		} else {
+			t = elem + "[]"
+		}
+	case "map":
+		t = "Record<string, " + tsType(r.Elem) + ">"
+	}
+
+	if r.Nullable {
+		t += " | null"
+	}
+	return t
+}
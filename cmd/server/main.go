@@ -1,66 +1,270 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"setback/server"
+	"setback/static"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for development
-	},
-}
-
 func main() {
-	port := flag.String("port", "8080", "Server port")
-	targetScore := flag.Int("target", 52, "Target score to win")
+	configPath := flag.String("config", os.Getenv("SETBACK_CONFIG"), "JSON config file (default $SETBACK_CONFIG); see README")
+	defaults := server.DefaultConfig()
+	port := flag.String("port", "8080", "Server port (shorthand for a -listen of \":PORT\")")
+	listen := flag.String("listen", defaults.Listen, "Address to listen on")
+	tlsCert := flag.String("tls-cert", "", "TLS certificate file (PEM); serve HTTPS with -tls-key")
+	tlsKey := flag.String("tls-key", "", "TLS private key file (PEM)")
+	tlsRedirect := flag.String("tls-redirect", "", "Plain-HTTP address that redirects to HTTPS, e.g. \":80\" (off when empty)")
+	staticDir := flag.String("static", defaults.StaticDir, "Serve the web client from this directory instead of the embedded copy (for frontend development)")
+	targetScore := flag.Int("target", defaults.Rules.TargetScore, "Target score to win")
+	dataDir := flag.String("data", defaults.DataDir, "Directory for accounts and other saved data")
+	pingPeriod := flag.Duration("ping-period", defaults.Timers.PingPeriod.Duration, "How often to ping WebSocket clients")
+	pongWait := flag.Duration("pong-wait", defaults.Timers.PongWait.Duration, "Drop clients that don't answer a ping within this time")
+	writeWait := flag.Duration("write-wait", defaults.Timers.WriteWait.Duration, "Time allowed to write a message to a client")
+	maxLag := flag.Duration("max-lag", defaults.Timers.MaxLag.Duration, "Disconnect clients that can't keep up for this long (0 = never)")
+	maxMessageSize := flag.Int64("max-message-size", defaults.Limits.MaxMessageSize, "Largest message accepted from a client, in bytes")
+	maxConnsPerIP := flag.Int("max-conns-per-ip", defaults.Limits.MaxConnsPerIP, "Maximum simultaneous WebSocket connections from one IP (0 = unlimited)")
+	allowedOrigins := flag.String("allowed-origins", "", "Comma-separated origins allowed to open WebSockets, besides this server's own")
+	devMode := flag.Bool("dev", false, "Development mode: allow WebSockets from any origin")
+	adminToken := flag.String("admin-token", "", "Bearer token for the admin API (default $SETBACK_ADMIN_TOKEN; empty disables it)")
+	logLevel := flag.String("log-level", defaults.Log.Level, "Log level: debug, info, warn or error")
+	logFormat := flag.String("log-format", defaults.Log.Format, "Log format: text or json")
+	shutdownTimeout := flag.Duration("shutdown-timeout", defaults.Timers.ShutdownTimeout.Duration, "How long to wait for connections to drain on shutdown")
 	flag.Parse()
 
+	// Defaults, then the config file, then SETBACK_* variables, then flags
+	cfg := defaults
+	if *configPath != "" {
+		var err error
+		if cfg, err = server.LoadConfig(*configPath); err != nil {
+			configError(err)
+		}
+	}
+	if err := cfg.ApplyEnv(os.LookupEnv); err != nil {
+		configError(err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Listen = ":" + *port
+		case "listen":
+			cfg.Listen = *listen
+		case "tls-cert":
+			cfg.TLS.Cert = *tlsCert
+		case "tls-key":
+			cfg.TLS.Key = *tlsKey
+		case "tls-redirect":
+			cfg.TLS.Redirect = *tlsRedirect
+		case "static":
+			cfg.StaticDir = *staticDir
+		case "target":
+			cfg.Rules.TargetScore = *targetScore
+		case "data":
+			cfg.DataDir = *dataDir
+		case "ping-period":
+			cfg.Timers.PingPeriod.Duration = *pingPeriod
+		case "pong-wait":
+			cfg.Timers.PongWait.Duration = *pongWait
+		case "write-wait":
+			cfg.Timers.WriteWait.Duration = *writeWait
+		case "max-lag":
+			cfg.Timers.MaxLag.Duration = *maxLag
+		case "max-message-size":
+			cfg.Limits.MaxMessageSize = *maxMessageSize
+		case "max-conns-per-ip":
+			cfg.Limits.MaxConnsPerIP = *maxConnsPerIP
+		case "allowed-origins":
+			cfg.AllowedOrigins = strings.FieldsFunc(*allowedOrigins, func(r rune) bool { return r == ',' || r == ' ' })
+		case "dev":
+			cfg.Dev = *devMode
+		case "admin-token":
+			cfg.AdminToken = *adminToken
+		case "log-level":
+			cfg.Log.Level = *logLevel
+		case "log-format":
+			cfg.Log.Format = *logFormat
+		case "shutdown-timeout":
+			cfg.Timers.ShutdownTimeout.Duration = *shutdownTimeout
+		}
+	})
+	if err := cfg.Validate(); err != nil {
+		configError(err)
+	}
+
+	logHandler, err := server.NewLogHandler(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		configError(err)
+	}
+	slog.SetDefault(slog.New(logHandler))
+
+	originPolicy := server.NewOriginPolicy(cfg.AllowedOrigins, cfg.Dev)
+	if cfg.Dev {
+		slog.Warn("Dev mode: accepting WebSockets from any origin")
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: originPolicy.Check,
+	}
+
+	// Registered accounts (guests can still play without one)
+	accounts, err := server.NewAccountStore(cfg.DataDir)
+	if err != nil {
+		fatal("Loading accounts failed", "err", err)
+	}
+	accounts.RegisterRoutes(http.DefaultServeMux)
+
 	// Create hub and game server
 	hub := server.NewHub()
-	gameServer := server.NewGameServer(hub, *targetScore)
+	hub.ConnConfig = cfg.ConnConfig()
+	hub.MaxConnsPerIP = cfg.Limits.MaxConnsPerIP
+	gameServer := server.NewGameServer(hub, cfg.Rules.TargetScore)
+
+	// Keep the seat token key and the table across restarts, so players
+	// reconnecting after a redeploy rejoin the game they were in
+	seatKey, err := server.LoadOrCreateKey(filepath.Join(cfg.DataDir, "seat.key"))
+	if err != nil {
+		fatal("Loading seat token key failed", "err", err)
+	}
+	gameServer.Tokens = server.NewTokenIssuer(seatKey)
+	tablesDir := filepath.Join(cfg.DataDir, "tables")
+	if _, err := gameServer.LoadSnapshot(tablesDir); err != nil {
+		fatal("Restoring table failed", "err", err)
+	}
+
+	// Liveness and readiness probes
+	health := &server.Health{}
+	health.RegisterRoutes(http.DefaultServeMux)
 
-	// Start hub and game server in background
-	go hub.Run()
+	// Read-only JSON API for scoreboards and bots
+	server.NewAPI(gameServer).RegisterRoutes(http.DefaultServeMux)
+
+	// Prometheus scrape endpoint
+	http.Handle("GET /metrics", server.MetricsHandler(gameServer))
+
+	// Operator API, used by setbackctl
+	if cfg.AdminToken != "" {
+		server.NewAdmin(cfg.AdminToken, gameServer).RegisterRoutes(http.DefaultServeMux)
+		slog.Info("Admin API enabled")
+	}
+
+	// Start the table: one goroutine owns its connections, seats and game
 	go gameServer.Run()
 
 	// WebSocket endpoint
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+	http.Handle("/ws", server.WebSocketHandler(gameServer, upgrader, health, accounts))
+
+	// Serve the web client: embedded, or from disk while working on it
+	if cfg.StaticDir != "" {
+		http.Handle("/", server.NewDevStaticHandler(cfg.StaticDir))
+		slog.Info("Serving web client from disk", "dir", cfg.StaticDir)
+	} else {
+		files, err := server.NewStaticHandler(static.Files)
 		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
-			return
+			fatal("Loading embedded web client failed", "err", err)
 		}
+		http.Handle("/", files)
+	}
 
-		client := &server.Client{
-			Hub:       hub,
-			Conn:      conn,
-			Send:      make(chan []byte, 256),
-			SeatIndex: -1,
+	srv := &http.Server{Addr: cfg.Listen}
+	var redirect *http.Server
+	scheme := "http"
+	if cfg.TLS.Enabled() {
+		scheme = "https"
+		certs, err := server.NewCertReloader(cfg.TLS.Cert, cfg.TLS.Key)
+		if err != nil {
+			fatal("Loading TLS certificate failed", "err", err)
 		}
+		srv.TLSConfig = certs.TLSConfig()
 
-		hub.Register <- client
+		// Pick up renewed certificates on SIGHUP without a restart
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if err := certs.Reload(); err != nil {
+					slog.Error("Reloading TLS certificate failed; keeping the old one", "err", err)
+					continue
+				}
+				slog.Info("TLS certificate reloaded", "cert", cfg.TLS.Cert)
+			}
+		}()
 
-		go client.WritePump()
-		go client.ReadPump()
+		if cfg.TLS.Redirect != "" {
+			redirect = &http.Server{
+				Addr:              cfg.TLS.Redirect,
+				Handler:           server.RedirectHandler(cfg.Listen),
+				ReadHeaderTimeout: 10 * time.Second,
+			}
+			go func() {
+				slog.Info("Redirecting HTTP to HTTPS", "addr", cfg.TLS.Redirect)
+				if err := redirect.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					fatal("HTTP redirect listener failed", "err", err)
+				}
+			}()
+		}
+	}
+	slog.Info("Starting Setback server", "addr", cfg.Listen, "scheme", scheme, "targetScore", cfg.Rules.TargetScore)
 
-		// Send initial state
-		hub.SendToClient(client, server.NewStateUpdateMessage(gameServer.State, -1))
-	})
+	go func() {
+		var err error
+		if cfg.TLS.Enabled() {
+			err = srv.ListenAndServeTLS("", "") // certificate comes from srv.TLSConfig
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("ListenAndServe failed", "err", err)
+		}
+	}()
+	health.SetReady(true)
 
-	// Serve static files
-	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/", fs)
+	// Shut down gracefully on SIGTERM (or Ctrl-C): refuse new connections,
+	// tell everyone, wait for their connections to close, then save the table
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	<-ctx.Done()
+	stop()
+	slog.Info("Shutting down", "timeout", cfg.Timers.ShutdownTimeout)
+	health.SetReady(false)
 
-	addr := ":" + *port
-	log.Printf("Starting Setback server on http://localhost%s", addr)
-	log.Printf("Target score: %d", *targetScore)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.Timers.ShutdownTimeout.Duration)
+	defer cancel()
+	if err := gameServer.Drain(drainCtx, "The server is restarting. You'll be reconnected to your game shortly."); err != nil {
+		slog.Warn("Clients did not disconnect in time; their connections were closed", "err", err)
+	}
+	if err := gameServer.SaveSnapshot(tablesDir); err != nil {
+		slog.Error("Saving table failed", "err", err)
+	}
+	if err := srv.Shutdown(drainCtx); err != nil {
+		slog.Warn("HTTP shutdown incomplete", "err", err)
+	}
+	if redirect != nil {
+		redirect.Shutdown(drainCtx)
+	}
+	slog.Info("Server stopped")
+}
 
-	if err := http.ListenAndServe(addr, nil); err != nil {
-		log.Fatal("ListenAndServe:", err)
+// configError reports a bad configuration and exits before the server starts
+func configError(err error) {
+	fmt.Fprintln(os.Stderr, "invalid configuration:")
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintln(os.Stderr, "  "+line)
 	}
+	os.Exit(2)
+}
+
+// fatal logs an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
@@ -0,0 +1,217 @@
+// Command setbackctl manages a running Setback server through its admin API
+// (enabled with the server's -admin-token flag).
+//
+//	setbackctl clients                # who's connected
+//	setbackctl kick 12                # disconnect client 12
+//	setbackctl target 31              # play the main table to 31
+//	setbackctl notice Restarting in 5 minutes
+//
+// Run setbackctl -h for all commands.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"setback/server"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func main() {
+	serverURL := flag.String("server", "http://localhost:8080", "Setback server URL")
+	token := flag.String("token", os.Getenv("SETBACK_ADMIN_TOKEN"), "Admin token (default $SETBACK_ADMIN_TOKEN)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if *token == "" {
+		fatal(errors.New("no admin token: use -token or set SETBACK_ADMIN_TOKEN"))
+	}
+
+	c := &client{base: strings.TrimRight(*serverURL, "/"), token: *token, http: &http.Client{Timeout: 10 * time.Second}}
+	if err := run(c, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: setbackctl [flags] <command> [args]
+
+Commands:
+  clients                  List connected clients
+  kick <client-id>         Disconnect a client
+  ban <client-id>          Ban a client's IP and disconnect it
+  bans                     List banned IPs
+  unban <ip>               Lift a ban
+  reset [table]            Reset a table to the lobby, keeping its players
+  close [table]            Disconnect everyone and clear a table
+  target <score> [table]   Change a table's target score
+  notice <text...>         Show a notice to every connected client
+
+Flags:
+`)
+	flag.PrintDefaults()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "setbackctl:", err)
+	os.Exit(1)
+}
+
+// run executes one command
+func run(c *client, cmd string, args []string) error {
+	switch cmd {
+	case "clients":
+		var clients []server.ClientInfo
+		if err := c.do(http.MethodGet, "/api/admin/clients", nil, &clients); err != nil {
+			return err
+		}
+		printClients(os.Stdout, clients)
+		return nil
+
+	case "kick", "ban":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: setbackctl %s <client-id>", cmd)
+		}
+		if _, err := strconv.ParseUint(args[0], 10, 64); err != nil {
+			return fmt.Errorf("invalid client ID %q", args[0])
+		}
+		return c.do(http.MethodPost, "/api/admin/clients/"+args[0]+"/"+cmd, nil, nil)
+
+	case "bans":
+		var bans []string
+		if err := c.do(http.MethodGet, "/api/admin/bans", nil, &bans); err != nil {
+			return err
+		}
+		for _, ip := range bans {
+			fmt.Println(ip)
+		}
+		return nil
+
+	case "unban":
+		if len(args) != 1 {
+			return errors.New("usage: setbackctl unban <ip>")
+		}
+		return c.do(http.MethodDelete, "/api/admin/bans/"+url.PathEscape(args[0]), nil, nil)
+
+	case "reset", "close":
+		if len(args) > 1 {
+			return fmt.Errorf("usage: setbackctl %s [table]", cmd)
+		}
+		var summary server.TableSummary
+		if err := c.do(http.MethodPost, tablePath(args, 0)+"/"+cmd, nil, &summary); err != nil {
+			return err
+		}
+		fmt.Printf("Table %s is now %s\n", summary.ID, summary.Phase)
+		return nil
+
+	case "target":
+		if len(args) < 1 || len(args) > 2 {
+			return errors.New("usage: setbackctl target <score> [table]")
+		}
+		score, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid score %q", args[0])
+		}
+		var summary server.TableSummary
+		body := map[string]int{"targetScore": score}
+		if err := c.do(http.MethodPut, tablePath(args, 1)+"/target", body, &summary); err != nil {
+			return err
+		}
+		fmt.Printf("Table %s now plays to %d\n", summary.ID, summary.TargetScore)
+		return nil
+
+	case "notice":
+		if len(args) == 0 {
+			return errors.New("usage: setbackctl notice <text...>")
+		}
+		return c.do(http.MethodPost, "/api/admin/notice", map[string]string{"text": strings.Join(args, " ")}, nil)
+
+	default:
+		return fmt.Errorf("unknown command %q (run setbackctl -h for help)", cmd)
+	}
+}
+
+// tablePath is the admin path for the table named by args[i], or the default table
+func tablePath(args []string, i int) string {
+	table := server.DefaultTableID
+	if len(args) > i {
+		table = args[i]
+	}
+	return "/api/admin/tables/" + url.PathEscape(table)
+}
+
+func printClients(out io.Writer, clients []server.ClientInfo) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTABLE\tIP\tSEAT\tNAME\tACCOUNT\tCONNECTED")
+	for _, c := range clients {
+		seat := "-"
+		if c.Seat >= 0 {
+			seat = strconv.Itoa(c.Seat)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", c.ID, c.Table, c.IP, seat, c.Name, c.Account,
+			time.Since(c.ConnectedAt).Round(time.Second))
+	}
+	w.Flush()
+}
+
+// client calls the admin API
+type client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out (if not nil). API errors are returned with their message.
+func (c *client) do(method, path string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.base+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s (%s)", apiErr.Error, resp.Status)
+		}
+		return errors.New(resp.Status)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
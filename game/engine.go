@@ -1,10 +1,5 @@
 package game
 
-import (
-	"errors"
-	"fmt"
-)
-
 // Action types
 type ActionType string
 
@@ -14,6 +9,8 @@ const (
 	ActionChangeName  ActionType = "changeName"
 	ActionKickPlayer    ActionType = "kickPlayer"    // House only: kick a player from their seat
 	ActionTransferHouse ActionType = "transferHouse" // House only: transfer house to another player
+	ActionMutePlayer    ActionType = "mutePlayer"    // House only: mute or unmute a player's chat
+	ActionSetEmotes     ActionType = "setEmotes"     // House only: turn table emotes on or off
 	ActionStartGame   ActionType = "startGame"
 	ActionPlaceBid    ActionType = "placeBid"
 	ActionSelectTrump ActionType = "selectTrump"
@@ -34,23 +31,10 @@ type Action struct {
 	TrumpSuit   string   // For SelectTrump action
 	CardIDs     []string // For TakeKitty/Discard actions (multiple cards)
 	TargetSeat  int      // For KickPlayer action
+	Muted       bool     // For MutePlayer action
+	Enabled     bool     // For SetEmotes action
 }
 
-// Common errors
-var (
-	ErrNotYourTurn      = errors.New("not your turn")
-	ErrInvalidAction    = errors.New("invalid action for current phase")
-	ErrSeatTaken        = errors.New("seat already taken")
-	ErrSeatEmpty        = errors.New("seat is empty")
-	ErrNotEnoughPlayers = errors.New("need 4 players to start")
-	ErrInvalidBid       = errors.New("invalid bid amount")
-	ErrCardNotInHand    = errors.New("card not in hand")
-	ErrCardNotInKitty   = errors.New("card not in kitty")
-	ErrMustFollowSuit   = errors.New("must follow suit if able")
-	ErrInvalidTrump     = errors.New("invalid trump suit")
-	ErrMustDiscardToSix = errors.New("must discard down to 6 cards")
-)
-
 // ApplyAction applies an action to the game state and returns the new state
 func ApplyAction(state *GameState, action Action) (*GameState, error) {
 	switch action.Type {
@@ -64,6 +48,10 @@ func ApplyAction(state *GameState, action Action) (*GameState, error) {
 		return applyKickPlayer(state, action)
 	case ActionTransferHouse:
 		return applyTransferHouse(state, action)
+	case ActionMutePlayer:
+		return applyMutePlayer(state, action)
+	case ActionSetEmotes:
+		return applySetEmotes(state, action)
 	case ActionStartGame:
 		return applyStartGame(state, action)
 	case ActionPlaceBid:
@@ -72,10 +60,9 @@ func ApplyAction(state *GameState, action Action) (*GameState, error) {
 		return applySelectTrump(state, action)
 	case ActionTakeKitty:
 		return applyTakeKitty(state, action)
-	case ActionDiscard:
-		return applyDiscard(state, action)
-	case ActionDiscardDraw:
-		return applyDiscardDraw(state, action)
+	case ActionDiscard, ActionDiscardDraw:
+		state, _, err := ApplyDiscard(state, action)
+		return state, err
 	case ActionPlayCard:
 		return applyPlayCard(state, action)
 	case ActionResetGame:
@@ -85,12 +72,32 @@ func ApplyAction(state *GameState, action Action) (*GameState, error) {
 	}
 }
 
+// DroppedDiscard is a discard chosen ahead of the player's turn that
+// couldn't be made when the turn came. The player has to choose again.
+type DroppedDiscard struct {
+	Seat int
+	Err  error
+}
+
+// ApplyDiscard applies an ActionDiscard or ActionDiscardDraw like
+// ApplyAction, also returning the pending discard it had to drop, if any
+func ApplyDiscard(state *GameState, action Action) (*GameState, *DroppedDiscard, error) {
+	switch action.Type {
+	case ActionDiscard:
+		return applyDiscard(state, action)
+	case ActionDiscardDraw:
+		return applyDiscardDraw(state, action)
+	default:
+		return nil, nil, ErrInvalidAction
+	}
+}
+
 func applyJoinSeat(state *GameState, action Action) (*GameState, error) {
 	if state.Phase != PhaseLobby {
 		return nil, ErrInvalidAction
 	}
 	if action.PlayerIndex < 0 || action.PlayerIndex > 3 {
-		return nil, errors.New("invalid seat index")
+		return nil, ErrInvalidSeat
 	}
 	if state.Players[action.PlayerIndex] != nil {
 		return nil, ErrSeatTaken
@@ -117,7 +124,7 @@ func applyJoinSeat(state *GameState, action Action) (*GameState, error) {
 
 func applyLeaveSeat(state *GameState, action Action) (*GameState, error) {
 	if action.PlayerIndex < 0 || action.PlayerIndex > 3 {
-		return nil, errors.New("invalid seat index")
+		return nil, ErrInvalidSeat
 	}
 	if state.Players[action.PlayerIndex] == nil {
 		return nil, ErrSeatEmpty
@@ -140,7 +147,7 @@ func applyStartGame(state *GameState, action Action) (*GameState, error) {
 	}
 	// Only the house can start the game
 	if action.PlayerIndex != state.House {
-		return nil, errors.New("only the house can start the game")
+		return nil, errHouseOnly(action.Type, "start the game")
 	}
 	if !state.AllPlayersSeated() {
 		return nil, ErrNotEnoughPlayers
@@ -193,11 +200,11 @@ func applyPlaceBid(state *GameState, action Action) (*GameState, error) {
 	}
 
 	if action.BidAmount != 0 {
-		if action.BidAmount < 2 || action.BidAmount > 6 {
+		if action.BidAmount < MinBid || action.BidAmount > MaxBid {
 			return nil, ErrInvalidBid
 		}
 		if action.BidAmount <= highBid {
-			return nil, fmt.Errorf("must bid higher than %d", highBid)
+			return nil, errBidTooLow(highBid)
 		}
 	}
 
@@ -277,25 +284,11 @@ func applyTakeKitty(state *GameState, action Action) (*GameState, error) {
 	player := state.Players[action.PlayerIndex]
 
 	// Find and move the specified cards from kitty to player's hand
-	for _, cardID := range action.CardIDs {
-		cardIdx := -1
-		var card Card
-		for i, c := range state.Kitty {
-			if c.ID == cardID {
-				cardIdx = i
-				card = c
-				break
-			}
-		}
-		if cardIdx == -1 {
-			return nil, ErrCardNotInKitty
-		}
-
-		// Remove from kitty
-		state.Kitty = append(state.Kitty[:cardIdx], state.Kitty[cardIdx+1:]...)
-		// Add to hand
-		player.Hand = append(player.Hand, card)
+	taken, _, ok := removeCards(state.Kitty, action.CardIDs)
+	if !ok {
+		return nil, ErrCardNotInKitty
 	}
+	player.Hand = append(player.Hand, taken...)
 
 	// Clear remaining kitty (they chose not to take these)
 	state.Kitty = []Card{}
@@ -303,47 +296,37 @@ func applyTakeKitty(state *GameState, action Action) (*GameState, error) {
 	return state, nil
 }
 
-func applyDiscard(state *GameState, action Action) (*GameState, error) {
+func applyDiscard(state *GameState, action Action) (*GameState, *DroppedDiscard, error) {
 	if state.Phase != PhaseKitty {
-		return nil, ErrInvalidAction
+		return nil, nil, ErrInvalidAction
 	}
 	if action.PlayerIndex != state.BidWinner {
-		return nil, ErrNotYourTurn
+		return nil, nil, ErrNotYourTurn
 	}
 
 	// Trump must be selected first
 	if state.Trump == nil {
-		return nil, errors.New("must select trump before discarding")
+		return nil, nil, ErrTrumpNotSelected
 	}
 
 	// Kitty must be empty (all cards have been taken or passed on)
 	if len(state.Kitty) > 0 {
-		return nil, errors.New("must take cards from kitty first")
+		return nil, nil, ErrKittyNotTaken
 	}
 
 	player := state.Players[action.PlayerIndex]
 
 	// Discard the specified cards
-	for _, cardID := range action.CardIDs {
-		cardIdx := -1
-		for i, c := range player.Hand {
-			if c.ID == cardID {
-				cardIdx = i
-				break
-			}
-		}
-		if cardIdx == -1 {
-			return nil, ErrCardNotInHand
-		}
-
-		// Remove from hand
-		player.Hand = append(player.Hand[:cardIdx], player.Hand[cardIdx+1:]...)
+	_, kept, ok := removeCards(player.Hand, action.CardIDs)
+	if !ok {
+		return nil, nil, ErrCardNotInHand
 	}
 
 	// Check if player has too many cards
-	if len(player.Hand) > 6 {
-		return nil, ErrMustDiscardToSix
+	if len(kept) > 6 {
+		return nil, nil, errMustDiscardToSix(len(kept))
 	}
+	player.Hand = kept
 
 	// Deal cards to bid winner if they have fewer than 6
 	if len(player.Hand) < 6 && state.Deck != nil {
@@ -364,41 +347,43 @@ func applyDiscard(state *GameState, action Action) (*GameState, error) {
 	state.DiscardComplete[state.BidWinner] = true // Bid winner already done
 
 	// Skip bid winner if they're first in order
+	var dropped *DroppedDiscard
 	if state.CurrentPlayer == state.BidWinner {
-		processPendingDiscards(state)
+		dropped = processPendingDiscards(state)
 	}
 
-	return state, nil
+	return state, dropped, nil
 }
 
 // applyDiscardDraw handles a player discarding cards and drawing replacements
 // Players can submit their discards at any time during the discard phase,
 // but cards are drawn in order (starting after dealer, dealer goes last)
-func applyDiscardDraw(state *GameState, action Action) (*GameState, error) {
+func applyDiscardDraw(state *GameState, action Action) (*GameState, *DroppedDiscard, error) {
 	if state.Phase != PhaseDiscard {
-		return nil, ErrInvalidAction
+		return nil, nil, ErrInvalidAction
+	}
+	if action.PlayerIndex < 0 || action.PlayerIndex > 3 {
+		return nil, nil, ErrInvalidSeat
 	}
 	if state.DiscardComplete[action.PlayerIndex] {
-		return nil, errors.New("already completed discard")
+		return nil, nil, ErrDiscardComplete
 	}
 
 	// If it's not this player's turn, store as pending discard
 	if action.PlayerIndex != state.CurrentPlayer {
 		// Store the pending discard selection
 		state.PendingDiscards[action.PlayerIndex] = action.CardIDs
-		return state, nil
+		return state, nil, nil
 	}
 
 	// It's this player's turn - process the discard
 	err := processDiscard(state, action.PlayerIndex, action.CardIDs)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Process any pending discards for subsequent players
-	processPendingDiscards(state)
-
-	return state, nil
+	return state, processPendingDiscards(state), nil
 }
 
 // processDiscard handles the actual discard and draw for a player
@@ -407,21 +392,15 @@ func processDiscard(state *GameState, playerIndex int, cardIDs []string) error {
 
 	// Discard the specified cards
 	discardCount := len(cardIDs)
-	for _, cardID := range cardIDs {
-		cardIdx := -1
-		for i, c := range player.Hand {
-			if c.ID == cardID {
-				cardIdx = i
-				break
-			}
-		}
-		if cardIdx == -1 {
-			return ErrCardNotInHand
-		}
-
-		// Remove from hand
-		player.Hand = append(player.Hand[:cardIdx], player.Hand[cardIdx+1:]...)
+	_, kept, ok := removeCards(player.Hand, cardIDs)
+	if !ok {
+		return ErrCardNotInHand
+	}
+	// Everyone must end up with 6 cards, so the deck has to cover the draw
+	if state.Deck != nil && discardCount > state.Deck.Remaining() {
+		return errNotEnoughCards(state.Deck.Remaining())
 	}
+	player.Hand = kept
 
 	// Draw replacement cards from deck
 	if discardCount > 0 && state.Deck != nil {
@@ -436,8 +415,33 @@ func processDiscard(state *GameState, playerIndex int, cardIDs []string) error {
 	return nil
 }
 
-// processPendingDiscards processes any pending discards in turn order
-func processPendingDiscards(state *GameState) {
+// removeCards splits cards into those named by ids and the rest, without
+// changing cards. ok is false if any id is missing or named twice.
+func removeCards(cards []Card, ids []string) (removed, kept []Card, ok bool) {
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if want[id] {
+			return nil, nil, false
+		}
+		want[id] = true
+	}
+	kept = []Card{}
+	for _, c := range cards {
+		if want[c.ID] {
+			removed = append(removed, c)
+		} else {
+			kept = append(kept, c)
+		}
+	}
+	if len(removed) != len(ids) {
+		return nil, nil, false
+	}
+	return removed, kept, true
+}
+
+// processPendingDiscards processes any pending discards in turn order,
+// returning one that couldn't be made
+func processPendingDiscards(state *GameState) *DroppedDiscard {
 	// Move to next player who hasn't discarded yet
 	for {
 		nextPlayer := NextPlayer(state.CurrentPlayer)
@@ -459,13 +463,13 @@ func processPendingDiscards(state *GameState) {
 					if err != nil {
 						// If there's an error, clear the pending and let them try again
 						state.PendingDiscards[nextPlayer] = nil
-						return
+						return &DroppedDiscard{Seat: nextPlayer, Err: err}
 					}
 					// Continue to check next player
 					break
 				}
 				// No pending discard, wait for this player
-				return
+				return nil
 			}
 			nextPlayer = NextPlayer(nextPlayer)
 		}
@@ -478,7 +482,7 @@ func processPendingDiscards(state *GameState) {
 				Cards:  []TrickCard{},
 				Leader: state.BidWinner,
 			}
-			return
+			return nil
 		}
 	}
 }
@@ -551,7 +555,7 @@ func applyPlayCard(state *GameState, action Action) (*GameState, error) {
 		if trumpLed {
 			// Trump led: must play trump if you have it
 			if !playedIsTrump && hasLeadSuit {
-				return nil, ErrMustFollowSuit
+				return nil, errMustFollowSuit(leadSuit)
 			}
 		} else {
 			// Non-trump led: must follow suit if you have it
@@ -559,10 +563,10 @@ func applyPlayCard(state *GameState, action Action) (*GameState, error) {
 			if hasLeadSuit {
 				// Must follow suit - can't play trump or other suits
 				if playedCard.Suit != leadSuit && !playedIsTrump {
-					return nil, ErrMustFollowSuit
+					return nil, errMustFollowSuit(leadSuit)
 				}
 				if playedIsTrump {
-					return nil, ErrMustFollowSuit
+					return nil, errMustFollowSuit(leadSuit)
 				}
 			}
 			// If no lead suit, can play anything (including trump)
@@ -689,7 +693,7 @@ func CheckGameOver(state *GameState) (bool, int) {
 // applyChangeName allows a player to change their name at any time
 func applyChangeName(state *GameState, action Action) (*GameState, error) {
 	if action.PlayerIndex < 0 || action.PlayerIndex > 3 {
-		return nil, errors.New("invalid seat index")
+		return nil, ErrInvalidSeat
 	}
 	if state.Players[action.PlayerIndex] == nil {
 		return nil, ErrSeatEmpty
@@ -704,44 +708,81 @@ func applyChangeName(state *GameState, action Action) (*GameState, error) {
 func applyTransferHouse(state *GameState, action Action) (*GameState, error) {
 	// Only house can transfer
 	if action.PlayerIndex != state.House {
-		return nil, errors.New("only the house can transfer ownership")
+		return nil, errHouseOnly(action.Type, "transfer ownership")
 	}
 
 	targetSeat := action.TargetSeat
 	if targetSeat < 0 || targetSeat > 3 {
-		return nil, errors.New("invalid seat index")
+		return nil, ErrInvalidSeat
 	}
 
 	// Can't transfer to yourself
 	if targetSeat == state.House {
-		return nil, errors.New("already the house")
+		return nil, ErrAlreadyHouse
 	}
 
 	// Target must be a seated, connected player
 	if state.Players[targetSeat] == nil || !state.Players[targetSeat].Connected || state.Players[targetSeat].Name == "" {
-		return nil, errors.New("target seat is empty or disconnected")
+		return nil, ErrTargetUnavailable
 	}
 
 	state.House = targetSeat
 	return state, nil
 }
 
+// applyMutePlayer mutes or unmutes a player's chat
+// Only the house can do this
+func applyMutePlayer(state *GameState, action Action) (*GameState, error) {
+	// Only house can mute
+	if action.PlayerIndex != state.House {
+		return nil, errHouseOnly(action.Type, "mute players")
+	}
+
+	targetSeat := action.TargetSeat
+	if targetSeat < 0 || targetSeat > 3 {
+		return nil, ErrInvalidSeat
+	}
+
+	// Can't mute yourself
+	if targetSeat == state.House {
+		return nil, errCannotTargetSelf(action.Type, "mute")
+	}
+
+	if state.Players[targetSeat] == nil {
+		return nil, ErrSeatEmpty
+	}
+
+	state.Players[targetSeat].Muted = action.Muted
+	return state, nil
+}
+
+// applySetEmotes turns table emotes on or off
+// Only the house can do this
+func applySetEmotes(state *GameState, action Action) (*GameState, error) {
+	if action.PlayerIndex != state.House {
+		return nil, errHouseOnly(action.Type, "change emote settings")
+	}
+
+	state.EmotesDisabled = !action.Enabled
+	return state, nil
+}
+
 // applyKickPlayer kicks a player from their seat
 // Only the house can do this
 func applyKickPlayer(state *GameState, action Action) (*GameState, error) {
 	// Only house can kick
 	if action.PlayerIndex != state.House {
-		return nil, errors.New("only the house can kick players")
+		return nil, errHouseOnly(action.Type, "kick players")
 	}
 
 	targetSeat := action.TargetSeat
 	if targetSeat < 0 || targetSeat > 3 {
-		return nil, errors.New("invalid seat index")
+		return nil, ErrInvalidSeat
 	}
 
 	// Can't kick yourself
 	if targetSeat == state.House {
-		return nil, errors.New("cannot kick yourself")
+		return nil, errCannotTargetSelf(action.Type, "kick")
 	}
 
 	if state.Players[targetSeat] == nil {
@@ -766,7 +807,7 @@ func applyKickPlayer(state *GameState, action Action) (*GameState, error) {
 func applyResetGame(state *GameState, action Action) (*GameState, error) {
 	// Only house can reset
 	if action.PlayerIndex != state.House {
-		return nil, errors.New("only the house can reset the game")
+		return nil, errHouseOnly(action.Type, "reset the game")
 	}
 
 	// Preserve games won, players, and house
@@ -781,15 +822,17 @@ func applyResetGame(state *GameState, action Action) (*GameState, error) {
 	players := state.Players
 	targetScore := state.TargetScore
 	house := state.House
+	emotesDisabled := state.EmotesDisabled
 
 	// Reset to fresh game state
 	*state = *NewGameState(targetScore)
 
-	// Restore players, games won, and house
+	// Restore players, games won, house and table settings
 	state.Players = players
 	state.Teams[0].GamesWon = gamesWon[0]
 	state.Teams[1].GamesWon = gamesWon[1]
 	state.House = house
+	state.EmotesDisabled = emotesDisabled
 
 	// Clear hands
 	for i := 0; i < 4; i++ {
@@ -0,0 +1,80 @@
+package game
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDiscardsLimitedByDeck(t *testing.T) {
+	tb := newTable(t, 1)
+	state := tb.state
+	for len(state.Bids) < 4 {
+		tb.apply(Action{Type: ActionPlaceBid, PlayerIndex: state.CurrentPlayer})
+	}
+	winner := state.BidWinner
+	tb.apply(Action{Type: ActionSelectTrump, PlayerIndex: winner, TrumpSuit: "hearts"})
+	tb.apply(Action{Type: ActionTakeKitty, PlayerIndex: winner, CardIDs: cardIDs(state.Kitty)})
+	if err := tb.apply(Action{Type: ActionDiscard, PlayerIndex: winner, CardIDs: cardIDs(state.Players[winner].Hand)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 22 cards are left after the deal: the bid winner draws 6, and the
+	// next two players 6 each, which leaves 4 for the last
+	for i := 0; i < 2; i++ {
+		seat := state.CurrentPlayer
+		if err := tb.apply(Action{Type: ActionDiscardDraw, PlayerIndex: seat, CardIDs: cardIDs(state.Players[seat].Hand)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	last := state.CurrentPlayer
+	err := tb.apply(Action{Type: ActionDiscardDraw, PlayerIndex: last, CardIDs: cardIDs(state.Players[last].Hand)})
+	var gameErr *Error
+	if !errors.As(err, &gameErr) || gameErr.Code != CodeNotEnoughCards || gameErr.Params["remaining"] != 4 {
+		t.Fatalf("Expected not_enough_cards with 4 remaining, got %v", err)
+	}
+
+	if err := tb.apply(Action{Type: ActionDiscardDraw, PlayerIndex: last, CardIDs: cardIDs(state.Players[last].Hand[:4])}); err != nil {
+		t.Fatal(err)
+	}
+	if state.Phase != PhasePlaying {
+		t.Errorf("Expected play to start, got %s", state.Phase)
+	}
+}
+
+func TestPendingDiscardDroppedWhenDeckShort(t *testing.T) {
+	tb := newTable(t, 1)
+	state := tb.state
+	for len(state.Bids) < 4 {
+		tb.apply(Action{Type: ActionPlaceBid, PlayerIndex: state.CurrentPlayer})
+	}
+	winner := state.BidWinner
+	tb.apply(Action{Type: ActionSelectTrump, PlayerIndex: winner, TrumpSuit: "hearts"})
+	tb.apply(Action{Type: ActionTakeKitty, PlayerIndex: winner, CardIDs: cardIDs(state.Kitty)})
+
+	if err := tb.apply(Action{Type: ActionDiscard, PlayerIndex: winner, CardIDs: cardIDs(state.Players[winner].Hand)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The last player chooses ahead of their turn to throw their whole hand
+	last := (winner + 3) % 4
+	if err := tb.apply(Action{Type: ActionDiscardDraw, PlayerIndex: last, CardIDs: cardIDs(state.Players[last].Hand)}); err != nil {
+		t.Fatal(err)
+	}
+	var dropped *DroppedDiscard
+	for i := 0; i < 2; i++ {
+		seat := state.CurrentPlayer
+		var err error
+		_, dropped, err = ApplyDiscard(state, Action{Type: ActionDiscardDraw, PlayerIndex: seat, CardIDs: cardIDs(state.Players[seat].Hand)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var gameErr *Error
+	if dropped == nil || dropped.Seat != last || !errors.As(dropped.Err, &gameErr) || gameErr.Code != CodeNotEnoughCards {
+		t.Fatalf("Expected the pending discard to be dropped with not_enough_cards, got %+v", dropped)
+	}
+	if state.Phase != PhaseDiscard || state.CurrentPlayer != last || len(state.Players[last].Hand) != 6 {
+		t.Errorf("The last player should be asked to discard again, phase %s, turn %d", state.Phase, state.CurrentPlayer)
+	}
+}
@@ -0,0 +1,132 @@
+package game
+
+import (
+	"fmt"
+)
+
+// ErrorCode is a stable, machine-readable identifier for an engine error.
+// Codes never change once published; messages may.
+type ErrorCode string
+
+const (
+	CodeNotYourTurn       ErrorCode = "not_your_turn"
+	CodeInvalidAction     ErrorCode = "invalid_action"
+	CodeInvalidSeat       ErrorCode = "invalid_seat"
+	CodeSeatTaken         ErrorCode = "seat_taken"
+	CodeSeatEmpty         ErrorCode = "seat_empty"
+	CodeNotEnoughPlayers  ErrorCode = "not_enough_players"
+	CodeHouseOnly         ErrorCode = "house_only" // Params: action
+	CodeAlreadyHouse      ErrorCode = "already_house"
+	CodeTargetUnavailable ErrorCode = "target_unavailable"
+	CodeCannotTargetSelf  ErrorCode = "cannot_target_self" // Params: action
+	CodeInvalidBid        ErrorCode = "invalid_bid"        // Params: min, max
+	CodeBidTooLow         ErrorCode = "bid_too_low"        // Params: highBid, minBid
+	CodeInvalidTrump      ErrorCode = "invalid_trump"
+	CodeTrumpNotSelected  ErrorCode = "trump_not_selected"
+	CodeKittyNotTaken     ErrorCode = "kitty_not_taken"
+	CodeCardNotInHand     ErrorCode = "card_not_in_hand"
+	CodeCardNotInKitty    ErrorCode = "card_not_in_kitty"
+	CodeMustDiscardToSix  ErrorCode = "must_discard_to_six" // Params: handSize
+	CodeDiscardComplete   ErrorCode = "discard_complete"
+	CodeNotEnoughCards    ErrorCode = "not_enough_cards" // Params: remaining
+	CodeMustFollowSuit    ErrorCode = "must_follow_suit" // Params: suit
+)
+
+// Error is an engine error with a stable code and optional parameters
+// (e.g. the minimum bid) so clients can localize the message or react to it
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Params  map[string]any
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Is matches on code, so errors.Is(err, ErrBidTooLow) holds for any minimum bid
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// NewError creates an error with a code and message
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// with returns a copy of the error with its own message and parameters
+func (e *Error) with(message string, params map[string]any) *Error {
+	return &Error{Code: e.Code, Message: message, Params: params}
+}
+
+// Common errors
+var (
+	ErrNotYourTurn       = NewError(CodeNotYourTurn, "not your turn")
+	ErrInvalidAction     = NewError(CodeInvalidAction, "invalid action for current phase")
+	ErrInvalidSeat       = NewError(CodeInvalidSeat, "invalid seat index")
+	ErrSeatTaken         = NewError(CodeSeatTaken, "seat already taken")
+	ErrSeatEmpty         = NewError(CodeSeatEmpty, "seat is empty")
+	ErrNotEnoughPlayers  = NewError(CodeNotEnoughPlayers, "need 4 players to start")
+	ErrHouseOnly         = NewError(CodeHouseOnly, "only the house can do that")
+	ErrAlreadyHouse      = NewError(CodeAlreadyHouse, "already the house")
+	ErrTargetUnavailable = NewError(CodeTargetUnavailable, "target seat is empty or disconnected")
+	ErrCannotTargetSelf  = NewError(CodeCannotTargetSelf, "cannot target yourself")
+	ErrInvalidBid        = &Error{Code: CodeInvalidBid, Message: "invalid bid amount", Params: map[string]any{"min": MinBid, "max": MaxBid}}
+	ErrBidTooLow         = NewError(CodeBidTooLow, "bid is too low")
+	ErrInvalidTrump      = NewError(CodeInvalidTrump, "invalid trump suit")
+	ErrTrumpNotSelected  = NewError(CodeTrumpNotSelected, "must select trump before discarding")
+	ErrKittyNotTaken     = NewError(CodeKittyNotTaken, "must take cards from kitty first")
+	ErrCardNotInHand     = NewError(CodeCardNotInHand, "card not in hand")
+	ErrCardNotInKitty    = NewError(CodeCardNotInKitty, "card not in kitty")
+	ErrMustDiscardToSix  = NewError(CodeMustDiscardToSix, "must discard down to 6 cards")
+	ErrDiscardComplete   = NewError(CodeDiscardComplete, "already completed discard")
+	ErrNotEnoughCards    = NewError(CodeNotEnoughCards, "not enough cards left in the deck to replace the discards")
+	ErrMustFollowSuit    = NewError(CodeMustFollowSuit, "must follow suit if able")
+)
+
+// Errors is the full catalog, for documentation and protocol tooling
+var Errors = []*Error{
+	ErrNotYourTurn, ErrInvalidAction, ErrInvalidSeat, ErrSeatTaken, ErrSeatEmpty,
+	ErrNotEnoughPlayers, ErrHouseOnly, ErrAlreadyHouse, ErrTargetUnavailable,
+	ErrCannotTargetSelf, ErrInvalidBid, ErrBidTooLow, ErrInvalidTrump,
+	ErrTrumpNotSelected, ErrKittyNotTaken, ErrCardNotInHand, ErrCardNotInKitty,
+	ErrMustDiscardToSix, ErrDiscardComplete, ErrNotEnoughCards, ErrMustFollowSuit,
+}
+
+// Bid limits (0 is a pass)
+const (
+	MinBid = 2
+	MaxBid = 6
+)
+
+// errHouseOnly reports a house-only action attempted by someone else
+func errHouseOnly(action ActionType, what string) error {
+	return ErrHouseOnly.with("only the house can "+what, map[string]any{"action": string(action)})
+}
+
+// errCannotTargetSelf reports the house targeting their own seat
+func errCannotTargetSelf(action ActionType, what string) error {
+	return ErrCannotTargetSelf.with("cannot "+what+" yourself", map[string]any{"action": string(action)})
+}
+
+// errBidTooLow reports a bid that doesn't beat the current high bid
+func errBidTooLow(highBid int) error {
+	return ErrBidTooLow.with(fmt.Sprintf("must bid higher than %d", highBid),
+		map[string]any{"highBid": highBid, "minBid": highBid + 1})
+}
+
+// errMustDiscardToSix reports a hand still over six cards after discarding
+func errMustDiscardToSix(handSize int) error {
+	return ErrMustDiscardToSix.with(ErrMustDiscardToSix.Message, map[string]any{"handSize": handSize})
+}
+
+// errNotEnoughCards reports more discards than the deck can replace
+func errNotEnoughCards(remaining int) error {
+	return ErrNotEnoughCards.with(fmt.Sprintf("only %d cards left in the deck", remaining), map[string]any{"remaining": remaining})
+}
+
+// errMustFollowSuit reports a card that breaks the follow-suit rule
+func errMustFollowSuit(suit Suit) error {
+	return ErrMustFollowSuit.with(ErrMustFollowSuit.Message, map[string]any{"suit": suit.String()})
+}
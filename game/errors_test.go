@@ -0,0 +1,61 @@
+package game
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestErrorCodesAreUnique(t *testing.T) {
+	seen := make(map[ErrorCode]bool)
+	for _, e := range Errors {
+		if e.Code == "" {
+			t.Errorf("Error %q has no code", e.Message)
+		}
+		if seen[e.Code] {
+			t.Errorf("Duplicate error code %s", e.Code)
+		}
+		seen[e.Code] = true
+	}
+}
+
+func TestBidTooLowCarriesMinimumBid(t *testing.T) {
+	state := NewGameState(21)
+	for i := 0; i < 4; i++ {
+		state, _ = ApplyAction(state, Action{Type: ActionJoinSeat, PlayerIndex: i, PlayerName: "P"})
+	}
+	state, err := ApplyAction(state, Action{Type: ActionStartGame, PlayerIndex: state.House})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err = ApplyAction(state, Action{Type: ActionPlaceBid, PlayerIndex: state.CurrentPlayer, BidAmount: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ApplyAction(state, Action{Type: ActionPlaceBid, PlayerIndex: state.CurrentPlayer, BidAmount: 3})
+	if !errors.Is(err, ErrBidTooLow) {
+		t.Fatalf("Expected ErrBidTooLow, got %v", err)
+	}
+	var gameErr *Error
+	if !errors.As(err, &gameErr) || gameErr.Params["minBid"] != 4 {
+		t.Errorf("Expected minBid 4, got %+v", gameErr)
+	}
+	if err.Error() != "must bid higher than 3" {
+		t.Errorf("Unexpected message %q", err.Error())
+	}
+}
+
+func TestHouseOnlyNamesTheAction(t *testing.T) {
+	state := NewGameState(21)
+	state, _ = ApplyAction(state, Action{Type: ActionJoinSeat, PlayerIndex: 0, PlayerName: "House"})
+	state, _ = ApplyAction(state, Action{Type: ActionJoinSeat, PlayerIndex: 1, PlayerName: "Guest"})
+
+	_, err := ApplyAction(state, Action{Type: ActionKickPlayer, PlayerIndex: 1, TargetSeat: 0})
+	var gameErr *Error
+	if !errors.As(err, &gameErr) || gameErr.Code != CodeHouseOnly || gameErr.Params["action"] != string(ActionKickPlayer) {
+		t.Errorf("Expected house_only for kickPlayer, got %+v", err)
+	}
+	if errors.Is(err, ErrNotYourTurn) {
+		t.Error("Errors with different codes should not match")
+	}
+}
@@ -0,0 +1,428 @@
+package game
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand/v2"
+	"testing"
+)
+
+// These tests play random games through ApplyAction and check the engine's
+// invariants after every action, legal or not
+
+// chooser picks a number in [0, n); ok is false once it has run out of choices
+type chooser func(n int) (choice int, ok bool)
+
+// rngChooser never runs out
+func rngChooser(rng *rand.Rand) chooser {
+	return func(n int) (int, bool) { return rng.IntN(n), true }
+}
+
+// bytesChooser takes its choices from fuzzer input, one byte each
+func bytesChooser(data []byte) chooser {
+	return func(n int) (int, bool) {
+		if len(data) == 0 {
+			return 0, false
+		}
+		b := data[0]
+		data = data[1:]
+		return int(b) % n, true
+	}
+}
+
+// table tracks a game along with what the engine throws away, so every card
+// can be accounted for
+type table struct {
+	t         *testing.T
+	state     *GameState
+	rng       *rand.Rand
+	discarded map[string]bool
+	scores    [2]int
+}
+
+// newTable seats four players and starts a game, dealt from seed
+func newTable(t *testing.T, seed uint64) *table {
+	t.Helper()
+	state := NewGameState(21)
+	for i := 0; i < 4; i++ {
+		if _, err := ApplyAction(state, Action{Type: ActionJoinSeat, PlayerIndex: i, PlayerName: fmt.Sprint("P", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ApplyAction(state, Action{Type: ActionStartGame, PlayerIndex: state.House}); err != nil {
+		t.Fatal(err)
+	}
+	tb := &table{t: t, state: state, rng: rand.New(rand.NewPCG(seed, 0))}
+	tb.redeal()
+	return tb
+}
+
+// redeal replaces the engine's shuffle with one from the table's seed, so a
+// failing game can be replayed
+func (tb *table) redeal() {
+	state := tb.state
+	state.Deck = NewDeck()
+	tb.rng.Shuffle(len(state.Deck.Cards), func(i, j int) {
+		state.Deck.Cards[i], state.Deck.Cards[j] = state.Deck.Cards[j], state.Deck.Cards[i]
+	})
+	for i := 0; i < 4; i++ {
+		state.Players[i].Hand = state.Deck.Deal(6)
+	}
+	state.Kitty = state.Deck.Deal(6)
+	tb.discarded = map[string]bool{}
+	tb.check("dealing")
+}
+
+// cards returns where every card the engine still holds is, failing on duplicates
+func (tb *table) cards(when string) map[string]string {
+	tb.t.Helper()
+	state := tb.state
+	where := map[string]string{}
+	add := func(place string, cards ...Card) {
+		for _, c := range cards {
+			if other, dup := where[c.ID]; dup {
+				tb.t.Fatalf("%s: %s is in both %s and %s", when, c.ID, other, place)
+			}
+			where[c.ID] = place
+		}
+	}
+	for i, p := range state.Players {
+		if p != nil {
+			add(fmt.Sprint("hand ", i), p.Hand...)
+		}
+	}
+	add("kitty", state.Kitty...)
+	if state.Deck != nil {
+		add("deck", state.Deck.Cards...)
+	}
+	for team, won := range state.CardsWon {
+		add(fmt.Sprint("team ", team, " tricks"), won...)
+	}
+	// A full trick has already gone to its winner's team
+	if trick := state.CurrentTrick; trick != nil && len(trick.Cards) < 4 {
+		for _, tc := range trick.Cards {
+			add("current trick", tc.Card)
+		}
+	}
+	return where
+}
+
+// check verifies the invariants that hold after any action
+func (tb *table) check(when string) {
+	tb.t.Helper()
+	state := tb.state
+	if state.Phase == PhaseLobby {
+		return
+	}
+
+	where := tb.cards(when)
+	for id := range tb.discarded {
+		if place, ok := where[id]; ok {
+			tb.t.Fatalf("%s: discarded %s is back in %s", when, id, place)
+		}
+	}
+	if n := len(where) + len(tb.discarded); n != 52 {
+		tb.t.Fatalf("%s: %d cards held and %d discarded, want 52", when, len(where), len(tb.discarded))
+	}
+
+	// Completed tricks and the cards each team won agree
+	var won [2][]Card
+	for i, trick := range state.CompletedTricks {
+		if want := referenceWinner(trick.Cards, *state.Trump); trick.Winner != want {
+			tb.t.Fatalf("%s: trick %d %v won by %d, want %d", when, i, trick.Cards, trick.Winner, want)
+		}
+		team := state.GetTeamForPlayer(trick.Winner)
+		for _, tc := range trick.Cards {
+			won[team] = append(won[team], tc.Card)
+		}
+	}
+	for team := range won {
+		if !sameCards(won[team], state.CardsWon[team]) {
+			tb.t.Fatalf("%s: team %d won %v but tricks give %v", when, team, state.CardsWon[team], won[team])
+		}
+	}
+	if state.TricksPlayed != len(state.CompletedTricks) {
+		tb.t.Fatalf("%s: %d tricks played but %d completed", when, state.TricksPlayed, len(state.CompletedTricks))
+	}
+
+	for i, team := range state.Teams {
+		if team.Score != tb.scores[i] {
+			tb.t.Fatalf("%s: team %d score went from %d to %d outside ApplyScore", when, i, tb.scores[i], team.Score)
+		}
+	}
+}
+
+// apply applies an action and checks the result
+func (tb *table) apply(action Action) error {
+	tb.t.Helper()
+	state := tb.state
+	phase := state.Phase
+	before := tb.cards("before " + string(action.Type))
+	snap, err := MarshalSnapshot(state)
+	if err != nil {
+		tb.t.Fatal(err)
+	}
+
+	_, err = ApplyAction(state, action)
+	when := fmt.Sprintf("%s %+v in %s", action.Type, action, phase)
+	if err != nil {
+		// A rejected action changes nothing
+		after, _ := MarshalSnapshot(state)
+		if !bytes.Equal(snap, after) {
+			tb.t.Fatalf("%s: rejected (%v) but the state changed", when, err)
+		}
+		return err
+	}
+	if state.Phase == PhaseLobby {
+		// Reset: a new match starts from nothing
+		tb.scores = [2]int{}
+		return nil
+	}
+	if phase == PhaseLobby {
+		tb.redeal() // Started again after a reset
+		return nil
+	}
+
+	// Cards leave the game only by being discarded
+	after := tb.cards(when)
+	for id, place := range before {
+		if _, ok := after[id]; ok {
+			continue
+		}
+		switch action.Type {
+		case ActionTakeKitty, ActionDiscard, ActionDiscardDraw:
+			if place == "deck" || place == "current trick" {
+				tb.t.Fatalf("%s: %s discarded from %s", when, id, place)
+			}
+			tb.discarded[id] = true
+		default:
+			tb.t.Fatalf("%s: %s vanished from %s", when, id, place)
+		}
+	}
+	tb.check(when)
+
+	if phase != PhasePlaying && state.Phase == PhasePlaying {
+		for i, p := range state.Players {
+			if len(p.Hand) != 6 {
+				tb.t.Fatalf("%s: seat %d starts play with %d cards", when, i, len(p.Hand))
+			}
+		}
+	}
+	return nil
+}
+
+// score scores a finished hand the way the server does, then deals the next
+// one unless the game is over. It reports whether the game goes on.
+func (tb *table) score() bool {
+	tb.t.Helper()
+	state := tb.state
+	result := CalculateScore(state)
+	ApplyScore(state, result)
+	tb.scores[0] += result.Team0Change
+	tb.scores[1] += result.Team1Change
+	tb.check("scoring")
+	if over, _ := CheckGameOver(state); over {
+		return false
+	}
+	StartNewHand(state)
+	tb.redeal()
+	return true
+}
+
+// subset picks some of ids, each with probability 1/2
+func subset(ids []string, choose chooser) []string {
+	picked := []string{}
+	for _, id := range ids {
+		if n, _ := choose(2); n == 1 {
+			picked = append(picked, id)
+		}
+	}
+	return picked
+}
+
+func cardIDs(cards []Card) []string {
+	ids := make([]string, len(cards))
+	for i, c := range cards {
+		ids[i] = c.ID
+	}
+	return ids
+}
+
+// nextAction picks an action a player might send: usually a sensible one for
+// the phase, sometimes nonsense. ok is false once choose has run out.
+func nextAction(state *GameState, choose chooser) (action Action, ok bool) {
+	kind, ok := choose(10)
+	if !ok {
+		return Action{}, false
+	}
+	if kind == 0 {
+		// Anything, from anyone
+		types := []ActionType{
+			ActionLeaveSeat, ActionChangeName, ActionKickPlayer, ActionTransferHouse,
+			ActionMutePlayer, ActionSetEmotes, ActionStartGame, ActionPlaceBid,
+			ActionSelectTrump, ActionTakeKitty, ActionDiscard, ActionDiscardDraw,
+			ActionPlayCard, ActionResetGame, ActionJoinSeat, "bogus",
+		}
+		t, _ := choose(len(types))
+		seat, _ := choose(6)
+		target, _ := choose(6)
+		bid, _ := choose(8)
+		card, _ := choose(52)
+		return Action{
+			Type: types[t], PlayerIndex: seat - 1, TargetSeat: target - 1, BidAmount: bid - 1,
+			CardID: NewDeck().Cards[card].ID, CardIDs: []string{NewDeck().Cards[card].ID}, TrumpSuit: "hearts",
+		}, true
+	}
+
+	switch state.Phase {
+	case PhaseBidding:
+		amount, _ := choose(MaxBid + 2)
+		if amount == 1 {
+			amount = 0
+		}
+		return Action{Type: ActionPlaceBid, PlayerIndex: state.CurrentPlayer, BidAmount: amount}, true
+	case PhaseKitty:
+		winner := state.BidWinner
+		switch {
+		case state.Trump == nil:
+			suit, _ := choose(5)
+			return Action{Type: ActionSelectTrump, PlayerIndex: winner, TrumpSuit: []string{"spades", "hearts", "diamonds", "clubs", "stars"}[suit]}, true
+		case len(state.Kitty) > 0:
+			return Action{Type: ActionTakeKitty, PlayerIndex: winner, CardIDs: subset(cardIDs(state.Kitty), choose)}, true
+		default:
+			// Usually down to six or fewer, sometimes not enough
+			hand := cardIDs(state.Players[winner].Hand)
+			ids := subset(hand, choose)
+			if short, _ := choose(4); short > 0 && len(hand)-len(ids) > 6 {
+				ids = hand[:len(hand)-6+len(ids)%(len(hand)-5)]
+			}
+			return Action{Type: ActionDiscard, PlayerIndex: winner, CardIDs: ids}, true
+		}
+	case PhaseDiscard:
+		// Anyone still to go, in turn or ahead of it
+		seat, _ := choose(4)
+		ids := subset(cardIDs(state.Players[seat].Hand), choose)
+		if dup, _ := choose(8); dup == 0 && len(ids) > 0 {
+			ids = append(ids, ids[0])
+		}
+		return Action{Type: ActionDiscardDraw, PlayerIndex: seat, CardIDs: ids}, true
+	case PhasePlaying:
+		hand := state.Players[state.CurrentPlayer].Hand
+		if len(hand) == 0 {
+			return Action{Type: ActionPlayCard, PlayerIndex: state.CurrentPlayer}, true
+		}
+		card, _ := choose(len(hand))
+		return Action{Type: ActionPlayCard, PlayerIndex: state.CurrentPlayer, CardID: hand[card].ID}, true
+	}
+	return Action{Type: ActionStartGame, PlayerIndex: state.House}, true
+}
+
+// play drives the table with choose until it runs out, the game ends or
+// limit actions have been tried
+func (tb *table) play(choose chooser, limit int) (hands int) {
+	for i := 0; i < limit; i++ {
+		if tb.state.Phase == PhaseScoring {
+			hands++
+			if !tb.score() {
+				return hands
+			}
+			continue
+		}
+		action, ok := nextAction(tb.state, choose)
+		if !ok {
+			return hands
+		}
+		tb.apply(action)
+	}
+	return hands
+}
+
+// referenceWinner finds a trick's winner without Card.Beats: trump beats the
+// lead suit, which beats everything else, and the off jack ranks between the
+// jack and ten of trump
+func referenceWinner(cards []TrickCard, trump Suit) int {
+	power := func(c Card, lead Suit) int {
+		switch {
+		case c.Suit == trump && c.Rank == Jack:
+			return 200 + 2*int(Jack)
+		case c.Suit == trump.OffSuit() && c.Rank == Jack:
+			return 200 + 2*int(Jack) - 1
+		case c.Suit == trump:
+			return 200 + 2*int(c.Rank)
+		case c.Suit == lead:
+			return 100 + int(c.Rank)
+		}
+		return 0
+	}
+	lead := cards[0].Card.Suit
+	if power(cards[0].Card, lead) >= 200 {
+		lead = trump
+	}
+	best, winner := -1, -1
+	for _, tc := range cards {
+		if p := power(tc.Card, lead); p > best {
+			best, winner = p, tc.PlayerIndex
+		}
+	}
+	return winner
+}
+
+// sameCards reports whether a and b hold the same cards in the same order
+func sameCards(a, b []Card) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID != b[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRandomGamesKeepInvariants(t *testing.T) {
+	tables := 30
+	if testing.Short() {
+		tables = 5
+	}
+	hands := 0
+	for seed := uint64(0); seed < uint64(tables); seed++ {
+		tb := newTable(t, seed)
+		hands += tb.play(rngChooser(rand.New(rand.NewPCG(seed, 1))), 2000)
+	}
+	// A table scores a hand every hundred or so actions
+	if hands < tables*10 {
+		t.Errorf("Expected at least %d hands scored over %d tables, got %d", tables*10, tables, hands)
+	}
+}
+
+func TestReferenceWinnerAgreesWithBeats(t *testing.T) {
+	// Every ordered pair of cards under every trump and lead suit
+	deck := NewDeck().Cards
+	for _, trump := range AllSuits() {
+		for _, a := range deck {
+			for _, b := range deck {
+				if a.ID == b.ID {
+					continue
+				}
+				trick := &Trick{Cards: []TrickCard{{Card: a, PlayerIndex: 0}, {Card: b, PlayerIndex: 1}}}
+				trick.LeadSuit = a.Suit
+				if a.IsTrump(trump) {
+					trick.LeadSuit = trump
+				}
+				if got, want := determineTrickWinner(trick, trump), referenceWinner(trick.Cards, trump); got != want {
+					t.Fatalf("%s led, %s followed, %s trump: engine says %d, reference %d", a.ID, b.ID, trump, got, want)
+				}
+			}
+		}
+	}
+}
+
+func FuzzApplyAction(f *testing.F) {
+	f.Add(uint64(1), []byte{})
+	f.Add(uint64(2), bytes.Repeat([]byte{1, 7, 3, 2, 9, 4}, 40))
+	f.Add(uint64(3), bytes.Repeat([]byte{0, 5, 1, 2, 3}, 60))
+	f.Fuzz(func(t *testing.T, seed uint64, choices []byte) {
+		newTable(t, seed).play(bytesChooser(choices), len(choices)+10)
+	})
+}
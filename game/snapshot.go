@@ -0,0 +1,124 @@
+package game
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// snapshotPlayer is a Player with the fields its JSON form leaves out
+type snapshotPlayer struct {
+	*Player
+	SessionToken string `json:"sessionToken,omitempty"`
+	Account      string `json:"account,omitempty"`
+}
+
+// snapshot is a GameState with the fields its JSON form leaves out,
+// so a game can be saved mid-hand and picked up where it left off
+type snapshot struct {
+	*GameState
+	Players         [4]*snapshotPlayer `json:"players"`
+	Deck            []Card             `json:"deck"`
+	DiscardComplete [4]bool            `json:"discardComplete"`
+	PendingDiscards [4][]string        `json:"pendingDiscards"`
+	CompletedTricks []CompletedTrick   `json:"completedTricks"`
+	CardsWon        [2][]Card          `json:"cardsWon"`
+}
+
+// MarshalSnapshot encodes the complete game state, including hands,
+// the deck and session tokens. Snapshots are secret: never send one to a client.
+func MarshalSnapshot(state *GameState) ([]byte, error) {
+	s := snapshot{
+		GameState:       state,
+		DiscardComplete: state.DiscardComplete,
+		PendingDiscards: state.PendingDiscards,
+		CompletedTricks: state.CompletedTricks,
+		CardsWon:        state.CardsWon,
+	}
+	if state.Deck != nil {
+		s.Deck = state.Deck.Cards
+	}
+	for i, p := range state.Players {
+		if p != nil {
+			s.Players[i] = &snapshotPlayer{Player: p, SessionToken: p.SessionToken, Account: p.Account}
+		}
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalSnapshot decodes a state written by MarshalSnapshot
+func UnmarshalSnapshot(data []byte) (*GameState, error) {
+	s := snapshot{GameState: &GameState{}}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+
+	state := s.GameState
+	state.DiscardComplete = s.DiscardComplete
+	state.PendingDiscards = s.PendingDiscards
+	state.CompletedTricks = s.CompletedTricks
+	state.CardsWon = s.CardsWon
+	if s.Deck != nil {
+		state.Deck = &Deck{Cards: s.Deck}
+	}
+	for i, p := range s.Players {
+		if p != nil && p.Player != nil {
+			p.Player.SessionToken = p.SessionToken
+			p.Player.Account = p.Account
+			state.Players[i] = p.Player
+		}
+	}
+	return state, nil
+}
+
+// Clone returns a deep copy of the state, sharing nothing with it
+func (g *GameState) Clone() *GameState {
+	c := *g
+	for i, p := range g.Players {
+		if p != nil {
+			player := *p
+			player.Hand = slices.Clone(p.Hand)
+			c.Players[i] = &player
+		}
+	}
+	for i, t := range g.Teams {
+		if t != nil {
+			team := *t
+			team.PlayerIndices = slices.Clone(t.PlayerIndices)
+			c.Teams[i] = &team
+		}
+	}
+	if g.Deck != nil {
+		c.Deck = &Deck{Cards: slices.Clone(g.Deck.Cards)}
+	}
+	c.CurrentTrick = g.CurrentTrick.clone()
+	c.LastTrick = g.LastTrick.clone()
+	if g.Trump != nil {
+		trump := *g.Trump
+		c.Trump = &trump
+	}
+	c.Bids = slices.Clone(g.Bids)
+	c.Kitty = slices.Clone(g.Kitty)
+	for i := range g.PendingDiscards {
+		c.PendingDiscards[i] = slices.Clone(g.PendingDiscards[i])
+	}
+	if g.CompletedTricks != nil {
+		c.CompletedTricks = make([]CompletedTrick, len(g.CompletedTricks))
+		for i, t := range g.CompletedTricks {
+			c.CompletedTricks[i] = CompletedTrick{Cards: slices.Clone(t.Cards), Winner: t.Winner}
+		}
+	}
+	for i := range g.CardsWon {
+		c.CardsWon[i] = slices.Clone(g.CardsWon[i])
+	}
+	return &c
+}
+
+// clone returns a deep copy of the trick, or nil for no trick
+func (t *Trick) clone() *Trick {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	c.Cards = slices.Clone(t.Cards)
+	return &c
+}
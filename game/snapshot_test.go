@@ -0,0 +1,66 @@
+package game
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	state := NewGameState(21)
+	for i := 0; i < 4; i++ {
+		if _, err := ApplyAction(state, Action{Type: ActionJoinSeat, PlayerIndex: i, PlayerName: "P"}); err != nil {
+			t.Fatal(err)
+		}
+		state.Players[i].SessionToken = "token"
+		state.Players[i].Account = "acct"
+	}
+	if _, err := ApplyAction(state, Action{Type: ActionStartGame}); err != nil {
+		t.Fatal(err)
+	}
+	state.PendingDiscards[1] = []string{state.Players[1].Hand[0].ID}
+	state.DiscardComplete[2] = true
+
+	data, err := MarshalSnapshot(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := UnmarshalSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(state, restored) {
+		t.Errorf("Restored state differs:\n got %+v\nwant %+v", restored, state)
+	}
+}
+
+func TestCloneSharesNothing(t *testing.T) {
+	state := NewGameState(21)
+	for i := 0; i < 4; i++ {
+		if _, err := ApplyAction(state, Action{Type: ActionJoinSeat, PlayerIndex: i, PlayerName: "P"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := ApplyAction(state, Action{Type: ActionStartGame}); err != nil {
+		t.Fatal(err)
+	}
+	state.PendingDiscards[1] = []string{state.Players[1].Hand[0].ID}
+	state.CurrentTrick = &Trick{Cards: []TrickCard{{Card: state.Players[0].Hand[0]}}}
+
+	before, _ := MarshalSnapshot(state)
+	clone := state.Clone()
+	if !reflect.DeepEqual(state, clone) {
+		t.Fatalf("Clone differs:\n got %+v\nwant %+v", clone, state)
+	}
+
+	clone.Players[0].Hand[0] = Card{ID: "x"}
+	clone.Players[1].Name = "Renamed"
+	clone.Teams[0].Score = 9
+	clone.Deck.Cards[0] = Card{ID: "x"}
+	clone.Kitty[0] = Card{ID: "x"}
+	clone.PendingDiscards[1][0] = "x"
+	clone.CurrentTrick.Cards[0].PlayerIndex = 3
+	clone.Bids = append(clone.Bids, Bid{Amount: 2})
+	if after, _ := MarshalSnapshot(state); string(after) != string(before) {
+		t.Error("Changing the clone changed the original")
+	}
+}
@@ -24,10 +24,13 @@ type Player struct {
 	SeatIndex    int    `json:"seatIndex"`
 	Hand         []Card `json:"hand,omitempty"`
 	SessionToken string `json:"-"`
+	Account      string `json:"-"` // Registered username, "" for guests
 	Connected    bool   `json:"connected"`
+	Muted        bool   `json:"muted"` // Muted by the house: cannot send chat
 }
 
 // GenerateSessionToken creates a random session token
+// (the server swaps it for a signed, expiring token when seating a client)
 func GenerateSessionToken() string {
 	b := make([]byte, 16)
 	rand.Read(b)
@@ -44,7 +47,7 @@ type Team struct {
 // Bid represents a bid made by a player
 type Bid struct {
 	PlayerIndex int `json:"playerIndex"`
-	Amount      int `json:"amount"` // 0 = pass, 2-4 = bid
+	Amount      int `json:"amount"` // 0 = pass, 2-6 = bid
 }
 
 // TrickCard represents a card played in a trick with its player
@@ -102,6 +105,9 @@ type GameState struct {
 
 	// Track if trump has been played this hand (broken)
 	TrumpBroken bool `json:"trumpBroken"`
+
+	// Table setting: house can turn off emotes
+	EmotesDisabled bool `json:"emotesDisabled"`
 }
 
 // NewGameState creates a new game in lobby phase
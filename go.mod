@@ -2,4 +2,7 @@ module setback
 
 go 1.25.6
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require (
+	github.com/gorilla/websocket v1.5.3
+	golang.org/x/crypto v0.54.0
+)
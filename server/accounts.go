@@ -0,0 +1,396 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// SessionCookieName is the cookie that carries a signed account session
+const SessionCookieName = "setback_session"
+
+// SessionLifetime is how long a login lasts
+const SessionLifetime = 30 * 24 * time.Hour
+
+// MinPasswordLength is the shortest password accepted at registration
+const MinPasswordLength = 8
+
+// MaxPasswordLength is the longest password accepted, in bytes: bcrypt
+// can't hash more
+const MaxPasswordLength = 72
+
+// maxCredentialsSize caps a register or login request body, in bytes
+const maxCredentialsSize = 4096
+
+// Account errors
+var (
+	ErrInvalidUsername    = errors.New("username must be 3-20 letters, digits, '-' or '_'")
+	ErrPasswordTooShort   = errors.New("password must be at least 8 characters")
+	ErrPasswordTooLong    = errors.New("password must be at most 72 bytes")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrInvalidCredentials = errors.New("invalid username or password")
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{3,20}$`)
+
+// Account is a registered user
+type Account struct {
+	Username     string    `json:"username"`
+	PasswordHash string    `json:"passwordHash"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// AccountStore keeps registered accounts in a JSON file
+// and issues signed session cookies for them
+type AccountStore struct {
+	path        string
+	revokedPath string
+	signer      *Signer
+	accounts    map[string]*Account // Keyed by lower-case username
+	revoked     map[string]int64    // Logged-out session ID -> expiry, kept until the session would expire anyway
+	mu          sync.RWMutex
+}
+
+// NewAccountStore loads accounts from dataDir, creating the session key if needed
+func NewAccountStore(dataDir string) (*AccountStore, error) {
+	key, err := LoadOrCreateKey(filepath.Join(dataDir, "session.key"))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &AccountStore{
+		path:        filepath.Join(dataDir, "accounts.json"),
+		revokedPath: filepath.Join(dataDir, "revoked-sessions.json"),
+		signer:      NewSigner(key),
+		accounts:    make(map[string]*Account),
+		revoked:     make(map[string]int64),
+	}
+
+	var accounts []*Account
+	if err := readJSONFile(s.path, &accounts); err != nil {
+		return nil, err
+	}
+	for _, a := range accounts {
+		s.accounts[strings.ToLower(a.Username)] = a
+	}
+	if err := readJSONFile(s.revokedPath, &s.revoked); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// readJSONFile decodes the JSON file at path into v, leaving v alone if
+// there's no such file
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// Register creates a new account
+func (s *AccountStore) Register(username, password string) (*Account, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, ErrInvalidUsername
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+	if len(password) > MaxPasswordLength {
+		return nil, ErrPasswordTooLong
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strings.ToLower(username)
+	if _, exists := s.accounts[key]; exists {
+		return nil, ErrUsernameTaken
+	}
+
+	account := &Account{
+		Username:     username,
+		PasswordHash: string(hash),
+		CreatedAt:    time.Now(),
+	}
+	s.accounts[key] = account
+
+	if err := s.save(); err != nil {
+		delete(s.accounts, key)
+		return nil, err
+	}
+	return account, nil
+}
+
+// Authenticate checks a username and password
+func (s *AccountStore) Authenticate(username, password string) (*Account, error) {
+	s.mu.RLock()
+	account := s.accounts[strings.ToLower(username)]
+	s.mu.RUnlock()
+
+	if account == nil {
+		return nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return account, nil
+}
+
+// save writes all accounts to disk. Caller must hold s.mu.
+func (s *AccountStore) save() error {
+	accounts := make([]*Account, 0, len(s.accounts))
+	for _, a := range s.accounts {
+		accounts = append(accounts, a)
+	}
+	return writeJSONFile(s.path, accounts)
+}
+
+// writeJSONFile writes v to path as indented JSON
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Write to a temp file and rename so a crash can't leave a partial file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// session is the signed content of a session cookie
+type session struct {
+	username string
+	expires  int64  // Unix seconds
+	id       string // Random; lets a logout revoke this session alone
+}
+
+// SetSession issues a signed session cookie for an account
+func (s *AccountStore) SetSession(w http.ResponseWriter, r *http.Request, username string) {
+	expires := time.Now().Add(SessionLifetime)
+	id := make([]byte, 8)
+	rand.Read(id)
+	payload := username + "|" + strconv.FormatInt(expires.Unix(), 10) + "|" + hex.EncodeToString(id)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    s.signer.Sign([]byte(payload)),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// ClearSession removes the session cookie
+func (s *AccountStore) ClearSession(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// session reads and checks a request's session cookie: its signature,
+// expiry and revocation
+func (s *AccountStore) session(r *http.Request) (session, bool) {
+	var sess session
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return sess, false
+	}
+	payload, err := s.signer.Verify(cookie.Value)
+	if err != nil {
+		return sess, false
+	}
+
+	parts := strings.Split(string(payload), "|")
+	if len(parts) != 3 {
+		return sess, false
+	}
+	sess.username, sess.id = parts[0], parts[2]
+	sess.expires, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > sess.expires {
+		return sess, false
+	}
+
+	s.mu.RLock()
+	_, revoked := s.revoked[sess.id]
+	s.mu.RUnlock()
+	return sess, !revoked
+}
+
+// RevokeSession ends the request's session, so its cookie stops working
+// even if it was copied before logging out
+func (s *AccountStore) RevokeSession(r *http.Request) error {
+	sess, ok := s.session(r)
+	if !ok {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Forget revocations for sessions that have expired on their own
+	now := time.Now().Unix()
+	for id, expires := range s.revoked {
+		if now > expires {
+			delete(s.revoked, id)
+		}
+	}
+	s.revoked[sess.id] = sess.expires
+	return writeJSONFile(s.revokedPath, s.revoked)
+}
+
+// SessionAccount returns the logged-in username for a request, or "" for guests
+func (s *AccountStore) SessionAccount(r *http.Request) string {
+	sess, ok := s.session(r)
+	if !ok {
+		return ""
+	}
+
+	// The account must still exist
+	s.mu.RLock()
+	account := s.accounts[strings.ToLower(sess.username)]
+	s.mu.RUnlock()
+	if account == nil {
+		return ""
+	}
+	return account.Username
+}
+
+// credentials is the request body for register and login
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// accountResponse is returned by the account endpoints
+type accountResponse struct {
+	Username string `json:"username,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// RegisterRoutes adds the account HTTP endpoints to mux
+func (s *AccountStore) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/register", s.handleRegister)
+	mux.HandleFunc("POST /api/login", s.handleLogin)
+	mux.HandleFunc("POST /api/logout", s.handleLogout)
+	mux.HandleFunc("GET /api/me", s.handleMe)
+}
+
+// readCredentials decodes a register or login body. Only JSON is accepted:
+// browsers send text/plain and form posts cross-site without a preflight.
+func readCredentials(w http.ResponseWriter, r *http.Request) (credentials, bool) {
+	var creds credentials
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, accountResponse{Error: "request body must be application/json"})
+		return creds, false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxCredentialsSize)
+	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSON(w, http.StatusRequestEntityTooLarge, accountResponse{Error: "request body is too large"})
+			return creds, false
+		}
+		writeJSON(w, http.StatusBadRequest, accountResponse{Error: "invalid request body"})
+		return creds, false
+	}
+	return creds, true
+}
+
+func (s *AccountStore) handleRegister(w http.ResponseWriter, r *http.Request) {
+	creds, ok := readCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := s.Register(creds.Username, creds.Password)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, ErrUsernameTaken) {
+			status = http.StatusConflict
+		} else if !errors.Is(err, ErrInvalidUsername) && !errors.Is(err, ErrPasswordTooShort) && !errors.Is(err, ErrPasswordTooLong) {
+			slog.Error("Registering account failed", "err", err)
+			status = http.StatusInternalServerError
+		}
+		writeJSON(w, status, accountResponse{Error: err.Error()})
+		return
+	}
+
+	s.SetSession(w, r, account.Username)
+	slog.Info("Account registered", "account", account.Username)
+	writeJSON(w, http.StatusCreated, accountResponse{Username: account.Username})
+}
+
+func (s *AccountStore) handleLogin(w http.ResponseWriter, r *http.Request) {
+	creds, ok := readCredentials(w, r)
+	if !ok {
+		return
+	}
+
+	account, err := s.Authenticate(creds.Username, creds.Password)
+	if err != nil {
+		writeJSON(w, http.StatusUnauthorized, accountResponse{Error: err.Error()})
+		return
+	}
+
+	s.SetSession(w, r, account.Username)
+	writeJSON(w, http.StatusOK, accountResponse{Username: account.Username})
+}
+
+func (s *AccountStore) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if err := s.RevokeSession(r); err != nil {
+		slog.Error("Revoking session failed", "err", err)
+		writeJSON(w, http.StatusInternalServerError, accountResponse{Error: "logging out failed"})
+		return
+	}
+	s.ClearSession(w)
+	writeJSON(w, http.StatusOK, accountResponse{})
+}
+
+func (s *AccountStore) handleMe(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, accountResponse{Username: s.SessionAccount(r)})
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Writing JSON response failed", "err", err)
+	}
+}
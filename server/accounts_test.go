@@ -0,0 +1,290 @@
+package server
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"setback/game"
+)
+
+func TestRegisterAndAuthenticate(t *testing.T) {
+	store, err := NewAccountStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Register("Alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username, password string
+		want               error
+	}{
+		{"alice", "another password", ErrUsernameTaken},
+		{"al", "long enough", ErrInvalidUsername},
+		{"bob smith", "long enough", ErrInvalidUsername},
+		{"bob", "short", ErrPasswordTooShort},
+		{"bob", strings.Repeat("x", MaxPasswordLength+1), ErrPasswordTooLong},
+	}
+	for _, tt := range tests {
+		if _, err := store.Register(tt.username, tt.password); !errors.Is(err, tt.want) {
+			t.Errorf("Register(%q, %q): expected %v, got %v", tt.username, tt.password, tt.want, err)
+		}
+	}
+
+	if account, err := store.Authenticate("ALICE", "correct horse"); err != nil || account.Username != "Alice" {
+		t.Errorf("Expected to log in as Alice, got %v, %v", account, err)
+	}
+	for _, creds := range [][2]string{{"Alice", "wrong horse"}, {"nobody", "correct horse"}} {
+		if _, err := store.Authenticate(creds[0], creds[1]); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q): expected invalid credentials, got %v", creds[0], err)
+		}
+	}
+}
+
+func TestAccountsPersist(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewAccountStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Register("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	store.SetSession(rec, httptest.NewRequest("POST", "/api/login", nil), "alice")
+
+	reopened, err := NewAccountStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Authenticate("alice", "correct horse"); err != nil {
+		t.Errorf("Account should survive a restart: %v", err)
+	}
+	if account := reopened.accounts["alice"]; account == nil || strings.Contains(account.PasswordHash, "correct horse") {
+		t.Error("Password should be stored hashed")
+	}
+
+	// The session key is kept too, so logins survive a restart
+	req := httptest.NewRequest("GET", "/api/me", nil)
+	req.AddCookie(rec.Result().Cookies()[0])
+	if got := reopened.SessionAccount(req); got != "alice" {
+		t.Errorf("Session should survive a restart, got %q", got)
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	store, err := NewAccountStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := store.Register(name, "correct horse"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other, err := NewAccountStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	store.SetSession(rec, httptest.NewRequest("POST", "/api/login", nil), "alice")
+	cookie := rec.Result().Cookies()[0]
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Session cookie should be HttpOnly and SameSite=Lax, got %+v", cookie)
+	}
+
+	// Bob's name under Alice's signature
+	_, sig, _ := strings.Cut(cookie.Value, ".")
+	future := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	tampered := base64.RawURLEncoding.EncodeToString([]byte("bob|"+future+"|00")) + "." + sig
+	expired := store.signer.Sign([]byte("alice|" + strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10) + "|00"))
+	tests := []struct {
+		name  string
+		value string
+		want  string
+	}{
+		{"valid", cookie.Value, "alice"},
+		{"tampered", tampered, ""},
+		{"expired", expired, ""},
+		{"unknown account", store.signer.Sign([]byte("mallory|" + future + "|00")), ""},
+		{"no session ID", store.signer.Sign([]byte("alice|" + future)), ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/me", nil)
+		req.AddCookie(&http.Cookie{Name: SessionCookieName, Value: tt.value})
+		if got := store.SessionAccount(req); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+
+	// Another server's key doesn't verify
+	req := httptest.NewRequest("GET", "/api/me", nil)
+	req.AddCookie(cookie)
+	if got := other.SessionAccount(req); got != "" {
+		t.Errorf("Cookie signed with another key was accepted as %q", got)
+	}
+}
+
+func TestAccountEndpointsRequireJSON(t *testing.T) {
+	store, err := NewAccountStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	store.RegisterRoutes(mux)
+
+	post := func(path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	body := `{"username":"alice","password":"correct horse"}`
+
+	for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+		for _, path := range []string{"/api/register", "/api/login"} {
+			if rec := post(path, contentType, body); rec.Code != http.StatusUnsupportedMediaType {
+				t.Errorf("%s as %q: expected 415, got %d", path, contentType, rec.Code)
+			}
+		}
+	}
+	if len(store.accounts) != 0 {
+		t.Fatal("A non-JSON request registered an account")
+	}
+
+	if rec := post("/api/register", "application/json; charset=utf-8", body); rec.Code != http.StatusCreated || len(rec.Result().Cookies()) == 0 {
+		t.Errorf("Expected registration with a session cookie, got %d", rec.Code)
+	}
+	if rec := post("/api/register", "application/json", body); rec.Code != http.StatusConflict {
+		t.Errorf("Expected a conflict for a taken name, got %d", rec.Code)
+	}
+	if rec := post("/api/login", "application/json", `{"username":"alice","password":"wrong horse"}`); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a bad password to be refused, got %d", rec.Code)
+	}
+	if rec := post("/api/login", "application/json", body); rec.Code != http.StatusOK {
+		t.Errorf("Expected to log in, got %d", rec.Code)
+	}
+}
+
+func TestLogoutRevokesSession(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewAccountStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Register("alice", "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	store.RegisterRoutes(mux)
+
+	login := func() *http.Cookie {
+		rec := httptest.NewRecorder()
+		store.SetSession(rec, httptest.NewRequest("POST", "/api/login", nil), "alice")
+		return rec.Result().Cookies()[0]
+	}
+	me := func(store *AccountStore, cookie *http.Cookie) string {
+		req := httptest.NewRequest("GET", "/api/me", nil)
+		req.AddCookie(cookie)
+		return store.SessionAccount(req)
+	}
+	stolen, other := login(), login()
+
+	req := httptest.NewRequest("POST", "/api/logout", nil)
+	req.AddCookie(stolen)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected to log out, got %d", rec.Code)
+	}
+
+	// A copy of the cookie kept after logging out no longer works,
+	// even after a restart, but other logins do
+	reopened, err := NewAccountStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []*AccountStore{store, reopened} {
+		if got := me(s, stolen); got != "" {
+			t.Errorf("Logged-out session still signs in as %q", got)
+		}
+		if got := me(s, other); got != "alice" {
+			t.Errorf("Another session should stay logged in, got %q", got)
+		}
+	}
+}
+
+func TestAccountRequestLimits(t *testing.T) {
+	store, err := NewAccountStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	store.RegisterRoutes(mux)
+
+	tests := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"password too long for bcrypt", `{"username":"alice","password":"` + strings.Repeat("x", 100) + `"}`, http.StatusBadRequest},
+		{"body too large", `{"username":"alice","password":"` + strings.Repeat("x", maxCredentialsSize) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/register", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestAccountLoginTakesOverSeat(t *testing.T) {
+	gs, clients := startedGame(t)
+	startPlaying(t, gs, clients)
+	seat := gs.State.CurrentPlayer
+	gs.State.Players[seat].Account = "alice"
+	old := clients[seat]
+	old.Account = "alice"
+	drain(old)
+
+	// Logging in on another device takes the seat over
+	device := helloClient(gs)
+	device.Account = "alice"
+	gs.HandleMessage(device, ClientMessage{Type: MsgRejoin})
+	if device.SeatIndex != seat || gs.Hub.GetClientBySeat(seat) != device {
+		t.Fatalf("Expected the new login to take seat %d", seat)
+	}
+	if old.SeatIndex != -1 || old.Token != "" {
+		t.Errorf("The old connection should be unseated, got seat %d", old.SeatIndex)
+	}
+	if lastOfType(drain(old), MsgNotice) == nil {
+		t.Error("The old connection should be told its seat was taken over")
+	}
+
+	card := gs.State.Players[seat].Hand[0].ID
+	gs.HandleMessage(old, ClientMessage{Type: MsgPlayCard, CardID: card})
+	if reply := lastOfType(drain(old), MsgError); reply == nil || reply.Error.Code != string(game.CodeInvalidAction) {
+		t.Errorf("The old connection's card should be rejected, got %+v", reply)
+	}
+	if len(gs.State.Players[seat].Hand) != 6 {
+		t.Fatal("The old connection played a card for the seat")
+	}
+	gs.HandleMessage(device, ClientMessage{Type: MsgPlayCard, CardID: card})
+	if len(gs.State.Players[seat].Hand) != 5 {
+		t.Error("The new connection should be able to play")
+	}
+}
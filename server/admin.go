@@ -0,0 +1,347 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"setback/game"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxNoticeLength is the longest server notice accepted, in characters
+const MaxNoticeLength = 500
+
+// MaxTargetScore is the highest target score the admin may set
+const MaxTargetScore = 500
+
+// Admin errors
+var (
+	ErrInvalidTargetScore = errors.New("target score must be between 1 and 500")
+	ErrNoticeEmpty        = errors.New("notice is empty")
+	ErrNoticeTooLong      = errors.New("notice is too long")
+)
+
+// Admin serves the operator API: listing and kicking connections, banning
+// IPs, resetting or closing tables and broadcasting notices. Every request
+// needs "Authorization: Bearer <token>".
+type Admin struct {
+	token  string
+	tables []*GameServer
+}
+
+// NewAdmin creates an admin API over the given tables. The token must not
+// be empty; leave the admin API unregistered to disable it.
+func NewAdmin(token string, tables ...*GameServer) *Admin {
+	return &Admin{token: token, tables: tables}
+}
+
+// ClientInfo is a connection as listed by the admin API
+type ClientInfo struct {
+	ID              uint64     `json:"id"`
+	Table           string     `json:"table"`
+	IP              string     `json:"ip"`
+	Seat            int        `json:"seat"` // -1 for spectators
+	Name            string     `json:"name,omitempty"`
+	Account         string     `json:"account,omitempty"`
+	ProtocolVersion int        `json:"protocolVersion"` // 0 until hello
+	ConnectedAt     time.Time  `json:"connectedAt"`
+	LaggingSince    *time.Time `json:"laggingSince,omitempty"` // Set while its send buffer is full
+}
+
+// targetRequest is the body of a target score change
+type targetRequest struct {
+	TargetScore int `json:"targetScore"`
+}
+
+// noticeRequest is the body of a server notice
+type noticeRequest struct {
+	Text string `json:"text"`
+}
+
+// RegisterRoutes adds the admin endpoints to mux
+func (a *Admin) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/admin/clients", a.authorize(a.handleListClients))
+	mux.HandleFunc("POST /api/admin/clients/{id}/kick", a.authorize(a.handleKick))
+	mux.HandleFunc("POST /api/admin/clients/{id}/ban", a.authorize(a.handleBan))
+	mux.HandleFunc("GET /api/admin/bans", a.authorize(a.handleListBans))
+	mux.HandleFunc("DELETE /api/admin/bans/{ip}", a.authorize(a.handleUnban))
+	mux.HandleFunc("POST /api/admin/tables/{id}/reset", a.authorize(a.withTable(a.handleReset)))
+	mux.HandleFunc("POST /api/admin/tables/{id}/close", a.authorize(a.withTable(a.handleClose)))
+	mux.HandleFunc("PUT /api/admin/tables/{id}/target", a.authorize(a.withTable(a.handleTarget)))
+	mux.HandleFunc("POST /api/admin/notice", a.authorize(a.handleNotice))
+}
+
+// authorize rejects requests without the admin bearer token
+func (a *Admin) authorize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="setback-admin"`)
+			writeJSON(w, http.StatusUnauthorized, apiError{Error: "admin token required"})
+			return
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		h(w, r)
+	}
+}
+
+// withTable resolves the {id} path segment, answering 404 for unknown tables
+func (a *Admin) withTable(h func(http.ResponseWriter, *http.Request, *GameServer)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, gs := range a.tables {
+			if gs.TableID == r.PathValue("id") {
+				h(w, r, gs)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, apiError{Error: "no such table"})
+	}
+}
+
+// withClient runs fn on the hub goroutine of the table with the connection
+// named by the {id} path segment, reporting false if there's no such connection
+func (a *Admin) withClient(r *http.Request, fn func(gs *GameServer, client *Client)) bool {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return false
+	}
+	for _, gs := range a.tables {
+		found := false
+		gs.Hub.Do(func() {
+			if client := gs.Hub.GetClientByID(id); client != nil {
+				found = true
+				fn(gs, client)
+			}
+		})
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Admin) handleListClients(w http.ResponseWriter, r *http.Request) {
+	clients := []ClientInfo{}
+	for _, gs := range a.tables {
+		clients = append(clients, gs.clientInfo()...)
+	}
+	writeJSON(w, http.StatusOK, clients)
+}
+
+func (a *Admin) handleKick(w http.ResponseWriter, r *http.Request) {
+	found := a.withClient(r, func(gs *GameServer, client *Client) {
+		gs.logger(client).Info("Admin kicked client", "ip", client.IP)
+		gs.expel(client, "You were disconnected by the server operator.")
+	})
+	if !found {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "no such client"})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) handleBan(w http.ResponseWriter, r *http.Request) {
+	var ip string
+	found := a.withClient(r, func(gs *GameServer, client *Client) {
+		ip = client.IP
+		gs.logger(client).Info("Admin banned IP", "ip", ip)
+	})
+	if !found {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "no such client"})
+		return
+	}
+
+	// Ban on every table's hub, disconnecting all of the IP's connections
+	for _, gs := range a.tables {
+		gs.Hub.Do(func() {
+			for _, c := range gs.Hub.Ban(ip) {
+				gs.expel(c, "You have been banned by the server operator.")
+			}
+		})
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// expel tells a client why it is being removed, gives up its seat, revoking
+// its seat token so it can't rejoin, and disconnects it
+func (gs *GameServer) expel(client *Client, notice string) {
+	gs.Hub.SendToClient(client, NewNoticeMessage(notice))
+	if client.SeatIndex >= 0 {
+		if err := gs.handleLeaveSeat(client); err != nil {
+			gs.logger(client).Warn("Giving up the seat failed", "err", err)
+		}
+	}
+	gs.Hub.Disconnect(client)
+}
+
+func (a *Admin) handleListBans(w http.ResponseWriter, r *http.Request) {
+	var bans []string
+	if len(a.tables) > 0 {
+		bans = a.tables[0].Hub.Bans()
+	}
+	writeJSON(w, http.StatusOK, append([]string{}, bans...))
+}
+
+func (a *Admin) handleUnban(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	found := false
+	for _, gs := range a.tables {
+		if gs.Hub.Unban(ip) {
+			found = true
+		}
+	}
+	if !found {
+		writeJSON(w, http.StatusNotFound, apiError{Error: "IP is not banned"})
+		return
+	}
+	slog.Info("Admin unbanned IP", "ip", ip)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *Admin) handleReset(w http.ResponseWriter, r *http.Request, gs *GameServer) {
+	gs.AdminReset()
+	writeJSON(w, http.StatusOK, gs.Summary())
+}
+
+func (a *Admin) handleClose(w http.ResponseWriter, r *http.Request, gs *GameServer) {
+	gs.Close("This table was closed by the server operator.")
+	writeJSON(w, http.StatusOK, gs.Summary())
+}
+
+func (a *Admin) handleTarget(w http.ResponseWriter, r *http.Request, gs *GameServer) {
+	var req targetRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid request body"})
+		return
+	}
+	if err := gs.SetTargetScore(req.TargetScore); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, gs.Summary())
+}
+
+func (a *Admin) handleNotice(w http.ResponseWriter, r *http.Request) {
+	var req noticeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: "invalid request body"})
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: ErrNoticeEmpty.Error()})
+		return
+	}
+	if utf8.RuneCountInString(text) > MaxNoticeLength {
+		writeJSON(w, http.StatusBadRequest, apiError{Error: ErrNoticeTooLong.Error()})
+		return
+	}
+
+	for _, gs := range a.tables {
+		gs.Hub.Do(func() { gs.Hub.BroadcastMessage(NewNoticeMessage(text)) })
+	}
+	slog.Info("Admin notice sent", "text", text)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// clientInfo lists the table's connections for the admin API
+func (gs *GameServer) clientInfo() []ClientInfo {
+	var infos []ClientInfo
+	gs.Hub.Do(func() {
+		clients := gs.Hub.ClientList()
+		infos = make([]ClientInfo, 0, len(clients))
+		for _, c := range clients {
+			info := ClientInfo{
+				ID:              c.ID,
+				Table:           gs.TableID,
+				IP:              c.IP,
+				Seat:            c.SeatIndex,
+				Account:         c.Account,
+				ProtocolVersion: c.ProtocolVersion,
+				ConnectedAt:     c.ConnectedAt,
+			}
+			if c.SeatIndex >= 0 && c.SeatIndex < 4 && gs.State.Players[c.SeatIndex] != nil {
+				info.Name = gs.State.Players[c.SeatIndex].Name
+			}
+			if c.lagging {
+				since := c.lagSince
+				info.LaggingSince = &since
+			}
+			infos = append(infos, info)
+		}
+	})
+	return infos
+}
+
+// AdminReset returns the table to the lobby, keeping its players,
+// as if the house had reset the game
+func (gs *GameServer) AdminReset() {
+	gs.Hub.Do(gs.adminReset)
+}
+
+func (gs *GameServer) adminReset() {
+	// With no house there is nobody seated and nothing to reset
+	if gs.State.House >= 0 {
+		action := game.Action{
+			Type:        game.ActionResetGame,
+			PlayerIndex: gs.State.House,
+		}
+		if _, err := game.ApplyAction(gs.State, action); err != nil {
+			gs.logger(nil).Error("Admin reset failed", "err", err)
+			return
+		}
+	}
+	gs.history.reset()
+	gs.logger(nil).Info("Table reset by admin")
+	gs.broadcastState()
+}
+
+// Close clears the table: every client is told why and disconnected,
+// all seat tokens are revoked and the table starts over empty.
+// Clients that reconnect find a fresh lobby.
+func (gs *GameServer) Close(reason string) {
+	gs.Hub.Do(func() { gs.close(reason) })
+}
+
+func (gs *GameServer) close(reason string) {
+	for _, p := range gs.State.Players {
+		if p != nil {
+			gs.Tokens.Revoke(p.SessionToken)
+		}
+	}
+	gs.State = game.NewGameState(gs.State.TargetScore)
+	gs.chat = chatLog{}
+	gs.seatRequests = [4]*requestLog{}
+	gs.history.reset()
+
+	clients := gs.Hub.ClientList()
+	for _, c := range clients {
+		gs.Hub.UnseatClient(c)
+		c.Token = ""
+		gs.Hub.SendToClient(c, NewNoticeMessage(reason))
+	}
+	for _, c := range clients {
+		gs.Hub.Disconnect(c)
+	}
+	gs.logger(nil).Info("Table closed by admin", "clients", len(clients))
+}
+
+// SetTargetScore changes the score needed to win. A team already past
+// the new target wins when the current hand is scored.
+func (gs *GameServer) SetTargetScore(score int) error {
+	if score < 1 || score > MaxTargetScore {
+		return ErrInvalidTargetScore
+	}
+
+	gs.Hub.Do(func() {
+		gs.State.TargetScore = score
+		gs.logger(nil).Info("Target score set by admin", "targetScore", score)
+		gs.broadcastState()
+	})
+	return nil
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"setback/game"
+)
+
+const testAdminToken = "s3cret"
+
+// adminRequest sends an authorized admin request and decodes a JSON response into v
+func adminRequest(t *testing.T, mux *http.ServeMux, method, path string, body, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+	return rec
+}
+
+// adminGame starts a game whose clients are registered with the hub
+// (so the admin API can see them) and returns the admin routes
+func adminGame(t *testing.T) (*GameServer, [4]*Client, *http.ServeMux) {
+	t.Helper()
+	gs, clients := startedGame(t)
+	for i, c := range clients {
+		c.IP = "10.0.0." + string(rune('1'+i))
+	}
+	register(gs.Hub, clients[:]...)
+
+	mux := http.NewServeMux()
+	NewAdmin(testAdminToken, gs).RegisterRoutes(mux)
+	return gs, clients, mux
+}
+
+// waitClosed collects a client's messages until the hub closes its send channel
+func waitClosed(t *testing.T, client *Client) []ServerMessage {
+	t.Helper()
+	var msgs []ServerMessage
+	for {
+		select {
+		case data, ok := <-client.Send:
+			if !ok {
+				return msgs
+			}
+			var msg ServerMessage
+			json.Unmarshal(data, &msg)
+			msgs = append(msgs, msg)
+		case <-time.After(time.Second):
+			t.Fatal("Client was not disconnected")
+			return nil
+		}
+	}
+}
+
+func TestAdminRequiresToken(t *testing.T) {
+	_, _, mux := adminGame(t)
+
+	for _, auth := range []string{"", "Bearer wrong", testAdminToken} {
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/clients", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: expected 401, got %d", auth, rec.Code)
+		}
+	}
+
+	if rec := adminRequest(t, mux, http.MethodGet, "/api/admin/clients", nil, nil); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200 with the token, got %d", rec.Code)
+	}
+}
+
+func TestAdminListsAndKicksClients(t *testing.T) {
+	gs, clients, mux := adminGame(t)
+
+	var infos []ClientInfo
+	adminRequest(t, mux, http.MethodGet, "/api/admin/clients", nil, &infos)
+	if len(infos) != 4 {
+		t.Fatalf("Expected 4 clients, got %+v", infos)
+	}
+	for _, info := range infos {
+		if info.ID == 0 || info.Seat < 0 || info.Name != "P" || info.Table != DefaultTableID {
+			t.Errorf("Unexpected client %+v", info)
+		}
+	}
+
+	kicked := clients[1]
+	token := kicked.Token
+	rec := adminRequest(t, mux, http.MethodPost, "/api/admin/clients/"+strconv.FormatUint(kicked.ID, 10)+"/kick", nil, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rec.Code)
+	}
+	if notice := lastOfType(waitClosed(t, kicked), MsgNotice); notice == nil {
+		t.Error("Kicked client should be told why")
+	}
+
+	// The kicked player's seat is open, and its token no longer rejoins it
+	gs.Hub.Do(func() {
+		if p := gs.State.Players[1]; p.Name != "" || p.Connected {
+			t.Errorf("Seat 1 should be open after the kick, got %+v", p)
+		}
+		rejoin := helloClient(gs)
+		gs.HandleMessage(rejoin, ClientMessage{Type: MsgRejoin, Token: token})
+		if reply := lastOfType(drain(rejoin), MsgError); rejoin.SeatIndex != -1 || reply == nil || reply.Error.Code != string(ErrRejoinFailed.Code) {
+			t.Errorf("Rejoining after a kick should fail, got seat %d, %+v", rejoin.SeatIndex, reply)
+		}
+	})
+
+	if rec := adminRequest(t, mux, http.MethodPost, "/api/admin/clients/999999/kick", nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown client, got %d", rec.Code)
+	}
+}
+
+func TestAdminBanAndUnban(t *testing.T) {
+	gs, clients, mux := adminGame(t)
+	banned := clients[2]
+
+	rec := adminRequest(t, mux, http.MethodPost, "/api/admin/clients/"+strconv.FormatUint(banned.ID, 10)+"/ban", nil, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rec.Code)
+	}
+	waitClosed(t, banned)
+	if !gs.Hub.IsBanned(banned.IP) {
+		t.Fatal("IP should be banned")
+	}
+
+	var bans []string
+	adminRequest(t, mux, http.MethodGet, "/api/admin/bans", nil, &bans)
+	if len(bans) != 1 || bans[0] != banned.IP {
+		t.Errorf("Unexpected bans %v", bans)
+	}
+
+	if rec := adminRequest(t, mux, http.MethodDelete, "/api/admin/bans/"+banned.IP, nil, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	if gs.Hub.IsBanned(banned.IP) {
+		t.Error("IP should no longer be banned")
+	}
+	if rec := adminRequest(t, mux, http.MethodDelete, "/api/admin/bans/"+banned.IP, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 unbanning twice, got %d", rec.Code)
+	}
+}
+
+func TestAdminTargetResetAndNotice(t *testing.T) {
+	gs, clients, mux := adminGame(t)
+
+	var summary TableSummary
+	adminRequest(t, mux, http.MethodPut, "/api/admin/tables/main/target", targetRequest{TargetScore: 31}, &summary)
+	if summary.TargetScore != 31 || gs.State.TargetScore != 31 {
+		t.Errorf("Target score not changed: %+v", summary)
+	}
+	if update := lastOfType(drain(clients[0]), MsgStateUpdate); update == nil || update.State.TargetScore != 31 {
+		t.Error("Players should be sent the new target score")
+	}
+	if rec := adminRequest(t, mux, http.MethodPut, "/api/admin/tables/main/target", targetRequest{TargetScore: 0}, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for target 0, got %d", rec.Code)
+	}
+	if rec := adminRequest(t, mux, http.MethodPut, "/api/admin/tables/nope/target", targetRequest{TargetScore: 31}, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown table, got %d", rec.Code)
+	}
+
+	adminRequest(t, mux, http.MethodPost, "/api/admin/tables/main/reset", nil, &summary)
+	if summary.Phase != string(game.PhaseLobby) || len(summary.Players) != 4 {
+		t.Errorf("Reset should return to the lobby keeping players: %+v", summary)
+	}
+
+	rec := adminRequest(t, mux, http.MethodPost, "/api/admin/notice", noticeRequest{Text: "Restarting soon"}, nil)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d", rec.Code)
+	}
+	deadline := time.After(time.Second)
+	for _, c := range clients {
+		var notice *ServerMessage
+		for notice == nil {
+			select {
+			case data := <-c.Send:
+				var msg ServerMessage
+				json.Unmarshal(data, &msg)
+				if msg.Type == MsgNotice {
+					notice = &msg
+				}
+			case <-deadline:
+				t.Fatal("Notice was not delivered to every client")
+			}
+		}
+		if notice.Notice != "Restarting soon" {
+			t.Errorf("Unexpected notice %q", notice.Notice)
+		}
+	}
+	if rec := adminRequest(t, mux, http.MethodPost, "/api/admin/notice", noticeRequest{Text: "  "}, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an empty notice, got %d", rec.Code)
+	}
+}
+
+func TestAdminCloseTable(t *testing.T) {
+	gs, clients, mux := adminGame(t)
+	oldToken := clients[0].Token
+
+	var summary TableSummary
+	adminRequest(t, mux, http.MethodPost, "/api/admin/tables/main/close", nil, &summary)
+	for _, c := range clients {
+		waitClosed(t, c)
+	}
+	if summary.Phase != string(game.PhaseLobby) {
+		t.Errorf("Closed table should be back in the lobby: %+v", summary)
+	}
+	for _, p := range summary.Players {
+		if p.Name != "" {
+			t.Errorf("Closed table should have no players: %+v", summary.Players)
+		}
+	}
+
+	// Old sessions can't reclaim their seats
+	reconnected := helloClient(gs)
+	gs.HandleMessage(reconnected, ClientMessage{Type: MsgRejoin, Token: oldToken})
+	if errMsg := lastOfType(drain(reconnected), MsgError); errMsg == nil || errMsg.Error.Code != string(ErrRejoinFailed.Code) {
+		t.Error("Rejoin with a pre-close token should fail")
+	}
+}
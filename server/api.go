@@ -0,0 +1,140 @@
+package server
+
+import (
+	"net/http"
+	"time"
+)
+
+// API serves read-only table information as JSON, for scoreboards and
+// bots that don't speak the WebSocket protocol. Everything it returns is
+// public: table state goes through BuildPublicState, so no hands or kitty.
+type API struct {
+	tables []*GameServer
+}
+
+// NewAPI creates an API over the given tables
+func NewAPI(tables ...*GameServer) *API {
+	return &API{tables: tables}
+}
+
+// TableSummary is a table as listed by the API
+type TableSummary struct {
+	ID          string         `json:"id"`
+	Phase       string         `json:"phase"`
+	Players     []PublicPlayer `json:"players"`
+	Scores      [2]int         `json:"scores"`
+	GamesWon    [2]int         `json:"gamesWon"`
+	TargetScore int            `json:"targetScore"`
+	HandsPlayed int            `json:"handsPlayed"` // In the current match
+	Spectators  int            `json:"spectators"`
+}
+
+// ScoreHistory is the current match's score after each hand
+type ScoreHistory struct {
+	TargetScore int          `json:"targetScore"`
+	StartedAt   time.Time    `json:"startedAt"`
+	Scores      []ScorePoint `json:"scores"`
+}
+
+// apiError is the body of an API error response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// RegisterRoutes adds the API endpoints to mux
+func (a *API) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/tables", a.handleListTables)
+	mux.HandleFunc("GET /api/tables/{id}/state", a.withTable(a.handleState))
+	mux.HandleFunc("GET /api/tables/{id}/scores", a.withTable(a.handleScores))
+	mux.HandleFunc("GET /api/tables/{id}/hands", a.withTable(a.handleHands))
+}
+
+// table finds a table by ID
+func (a *API) table(id string) *GameServer {
+	for _, gs := range a.tables {
+		if gs.TableID == id {
+			return gs
+		}
+	}
+	return nil
+}
+
+// withTable resolves the {id} path segment, answering 404 for unknown tables
+func (a *API) withTable(h func(http.ResponseWriter, *GameServer)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gs := a.table(r.PathValue("id"))
+		if gs == nil {
+			writeAPI(w, http.StatusNotFound, apiError{Error: "no such table"})
+			return
+		}
+		h(w, gs)
+	}
+}
+
+func (a *API) handleListTables(w http.ResponseWriter, r *http.Request) {
+	tables := make([]TableSummary, 0, len(a.tables))
+	for _, gs := range a.tables {
+		tables = append(tables, gs.Summary())
+	}
+	writeAPI(w, http.StatusOK, tables)
+}
+
+func (a *API) handleState(w http.ResponseWriter, gs *GameServer) {
+	var state *PublicState
+	gs.Hub.Do(func() { state = BuildPublicState(gs.State) })
+	writeAPI(w, http.StatusOK, state)
+}
+
+func (a *API) handleScores(w http.ResponseWriter, gs *GameServer) {
+	var history ScoreHistory
+	gs.Hub.Do(func() {
+		history = ScoreHistory{
+			TargetScore: gs.State.TargetScore,
+			StartedAt:   gs.history.StartedAt,
+			Scores:      gs.history.scores(),
+		}
+	})
+	writeAPI(w, http.StatusOK, history)
+}
+
+func (a *API) handleHands(w http.ResponseWriter, gs *GameServer) {
+	var hands []HandRecord
+	gs.Hub.Do(func() { hands = append([]HandRecord{}, gs.history.Hands...) })
+	writeAPI(w, http.StatusOK, hands)
+}
+
+// Summary describes the table for listings.
+// Safe to call from any goroutine but the hub's.
+func (gs *GameServer) Summary() (summary TableSummary) {
+	gs.Hub.Do(func() { summary = gs.summary() })
+	return summary
+}
+
+// summary describes the table for listings
+func (gs *GameServer) summary() TableSummary {
+	state := BuildPublicState(gs.State)
+	summary := TableSummary{
+		ID:          gs.TableID,
+		Phase:       string(state.Phase),
+		Players:     state.Players,
+		TargetScore: state.TargetScore,
+		HandsPlayed: len(gs.history.Hands),
+	}
+	for i, team := range state.Teams {
+		summary.Scores[i] = team.Score
+		summary.GamesWon[i] = team.GamesWon
+	}
+	for _, client := range gs.Hub.ClientList() {
+		if client.SeatIndex < 0 {
+			summary.Spectators++
+		}
+	}
+	return summary
+}
+
+// writeAPI writes a JSON response that any origin may read; it's all public
+func writeAPI(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, v)
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"setback/game"
+)
+
+// startPlaying passes every bid and skips the discards, so the next move is a card
+func startPlaying(t *testing.T, gs *GameServer, clients [4]*Client) {
+	t.Helper()
+	pass := 0
+	for gs.State.Phase == game.PhaseBidding {
+		gs.HandleMessage(clients[gs.State.CurrentPlayer], ClientMessage{Type: MsgPlaceBid, Amount: &pass})
+	}
+
+	winner := clients[gs.State.BidWinner]
+	gs.HandleMessage(winner, ClientMessage{Type: MsgSelectTrump, TrumpSuit: "spades"})
+	gs.HandleMessage(winner, ClientMessage{Type: MsgTakeKitty, CardIDs: []string{}})
+	gs.HandleMessage(winner, ClientMessage{Type: MsgDiscard, CardIDs: []string{}})
+	for _, c := range clients {
+		gs.HandleMessage(c, ClientMessage{Type: MsgDiscardDraw, CardIDs: []string{}})
+	}
+	if gs.State.Phase != game.PhasePlaying {
+		t.Fatalf("Expected playing phase, got %s", gs.State.Phase)
+	}
+}
+
+// playHand drives the current hand to scoring through HandleMessage:
+// everyone passes (so the dealer takes it at 2), nobody discards, and
+// each player plays the first legal card
+func playHand(t *testing.T, gs *GameServer, clients [4]*Client) {
+	t.Helper()
+	startPlaying(t, gs, clients)
+
+	for gs.State.Phase == game.PhasePlaying {
+		seat := gs.State.CurrentPlayer
+		hand := append([]game.Card(nil), gs.State.Players[seat].Hand...)
+		played := false
+		for _, card := range hand {
+			gs.HandleMessage(clients[seat], ClientMessage{Type: MsgPlayCard, CardID: card.ID})
+			if len(gs.State.Players[seat].Hand) < len(hand) {
+				played = true
+				break
+			}
+		}
+		if !played {
+			t.Fatalf("Seat %d has no legal card", seat)
+		}
+	}
+	for _, c := range clients {
+		drain(c)
+	}
+}
+
+func getJSON(t *testing.T, mux *http.ServeMux, path string, v any) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if v != nil && rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return rec
+}
+
+func TestAPIListsTablesAndPublicState(t *testing.T) {
+	gs, _ := startedGame(t)
+	mux := http.NewServeMux()
+	NewAPI(gs).RegisterRoutes(mux)
+
+	var tables []TableSummary
+	getJSON(t, mux, "/api/tables", &tables)
+	if len(tables) != 1 || tables[0].ID != DefaultTableID || tables[0].Phase != string(game.PhaseBidding) {
+		t.Fatalf("Unexpected tables %+v", tables)
+	}
+
+	rec := getJSON(t, mux, "/api/tables/main/state", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, hidden := range []string{"yourHand", "kitty\"", "hand\""} {
+		if strings.Contains(body, hidden) {
+			t.Errorf("Public state leaks %s: %s", hidden, body)
+		}
+	}
+	if rec.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("API responses should be readable cross-origin")
+	}
+
+	if rec := getJSON(t, mux, "/api/tables/nope/state", nil); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown table, got %d", rec.Code)
+	}
+}
+
+func TestAPIRecordsCompletedHands(t *testing.T) {
+	gs, clients := startedGame(t)
+	mux := http.NewServeMux()
+	NewAPI(gs).RegisterRoutes(mux)
+
+	playHand(t, gs, clients)
+
+	var hands []HandRecord
+	getJSON(t, mux, "/api/tables/main/hands", &hands)
+	if len(hands) != 1 {
+		t.Fatalf("Expected 1 hand, got %d", len(hands))
+	}
+	hand := hands[0]
+	if hand.Number != 1 || hand.Trump != "spades" || hand.WinningBid != 2 || len(hand.Tricks) != 6 {
+		t.Errorf("Unexpected hand record %+v", hand)
+	}
+	if hand.Scores != [2]int{gs.State.Teams[0].Score, gs.State.Teams[1].Score} {
+		t.Errorf("Recorded scores %v don't match the table", hand.Scores)
+	}
+
+	var history ScoreHistory
+	getJSON(t, mux, "/api/tables/main/scores", &history)
+	if len(history.Scores) != 1 || history.Scores[0].Scores != hand.Scores {
+		t.Errorf("Unexpected score history %+v", history)
+	}
+
+	// Resetting the game starts a new match
+	gs.HandleMessage(clients[gs.State.House], ClientMessage{Type: MsgResetGame})
+	getJSON(t, mux, "/api/tables/main/hands", &hands)
+	if len(hands) != 0 {
+		t.Errorf("Expected an empty history after reset, got %d hands", len(hands))
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"fmt"
+	"setback/game"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ChatChannel identifies who a chat message is addressed to
+type ChatChannel string
+
+const (
+	ChatTable ChatChannel = "table" // Everyone at the table
+	ChatTeam  ChatChannel = "team"  // The sender's team only
+)
+
+// MaxChatLength is the longest chat message accepted, in characters
+const MaxChatLength = 280
+
+// ChatHistorySize is how many recent messages the table keeps
+const ChatHistorySize = 50
+
+// Chat errors
+var (
+	ErrChatEmpty      = game.NewError("chat_empty", "chat message is empty")
+	ErrChatTooLong    = &game.Error{Code: "chat_too_long", Message: "chat message is too long", Params: map[string]any{"max": MaxChatLength}}
+	ErrChatMuted      = game.NewError("chat_muted", "you have been muted by the house")
+	ErrInvalidChannel = game.NewError("invalid_channel", "invalid chat channel")
+	ErrTeamChatNoSeat = game.NewError("team_chat_no_seat", "must be seated to use team chat")
+	ErrNotSpectator   = game.NewError("not_spectator", "that client is not a spectator at this table")
+
+	// errMuteHouseOnly matches the engine's error for muting a seat
+	errMuteHouseOnly = &game.Error{Code: game.CodeHouseOnly, Message: "only the house can mute players",
+		Params: map[string]any{"action": string(game.ActionMutePlayer)}}
+)
+
+// chatAudience determines which clients receive a chat message
+type chatAudience int
+
+const (
+	audienceAll        chatAudience = iota // Players and spectators
+	audienceTeam                           // The sender's team
+	audienceSpectators                     // Unseated clients only
+)
+
+// ChatMessage is a chat line as delivered to clients
+type ChatMessage struct {
+	SeatIndex int          `json:"seatIndex"`          // -1 for spectators
+	ClientID  uint64       `json:"clientId,omitempty"` // Spectators only: lets the house mute them
+	Name      string       `json:"name"`
+	Channel   ChatChannel  `json:"channel"`
+	Text      string       `json:"text"`
+	SentAt    time.Time    `json:"sentAt"`
+	audience  chatAudience // Not sent; used for routing and history
+	team      int          // The sender's team, for audienceTeam
+}
+
+// visibleTo reports whether a client should see this message
+func (m ChatMessage) visibleTo(client *Client) bool {
+	switch m.audience {
+	case audienceAll:
+		return true
+	case audienceTeam:
+		// Partners sit opposite each other: team 0 is seats 0 and 2
+		return client.SeatIndex >= 0 && client.SeatIndex%2 == m.team
+	case audienceSpectators:
+		return client.SeatIndex < 0
+	}
+	return false
+}
+
+// chatLog is a bounded history of recent chat messages
+type chatLog struct {
+	messages []ChatMessage
+}
+
+// add appends a message, dropping the oldest once the log is full
+func (l *chatLog) add(msg ChatMessage) {
+	l.messages = append(l.messages, msg)
+	if len(l.messages) > ChatHistorySize {
+		l.messages = l.messages[len(l.messages)-ChatHistorySize:]
+	}
+}
+
+// visibleTo returns the messages a client is allowed to see. Team
+// messages sent before joinedTeam, when the client joined its team,
+// were never meant for it and are left out.
+func (l *chatLog) visibleTo(client *Client, joinedTeam time.Time) []ChatMessage {
+	visible := make([]ChatMessage, 0, len(l.messages))
+	for _, m := range l.messages {
+		if m.audience == audienceTeam && m.SentAt.Before(joinedTeam) {
+			continue
+		}
+		if m.visibleTo(client) {
+			visible = append(visible, m)
+		}
+	}
+	return visible
+}
+
+// chatMutes are the house's mutes beyond a seat's own Muted flag, kept so
+// a mute follows the muted person rather than the seat
+type chatMutes struct {
+	accounts map[string]bool // Registered players, by username
+	clients  map[uint64]bool // Guests, by connection, so leaving the seat doesn't lift it
+	ips      map[string]bool // Guest spectators, who have nothing better to go by
+}
+
+func newChatMutes() chatMutes {
+	return chatMutes{
+		accounts: make(map[string]bool),
+		clients:  make(map[uint64]bool),
+		ips:      make(map[string]bool),
+	}
+}
+
+// muted reports whether a client may not chat. player is the client's
+// seat, nil for spectators.
+func (m chatMutes) muted(client *Client, player *game.Player) bool {
+	if player != nil && player.Muted {
+		return true
+	}
+	if client.Account != "" {
+		return m.accounts[client.Account]
+	}
+	return m.clients[client.ID] || (player == nil && m.ips[client.IP])
+}
+
+// set mutes or unmutes a client: by account if it has one, otherwise by
+// connection, and by IP too while it spectates so reconnecting doesn't lift it
+func (m chatMutes) set(client *Client, muted bool) {
+	if client.Account != "" {
+		if muted {
+			m.accounts[client.Account] = true
+		} else {
+			delete(m.accounts, client.Account)
+		}
+		return
+	}
+	if !muted {
+		delete(m.clients, client.ID)
+		delete(m.ips, client.IP)
+		return
+	}
+	m.clients[client.ID] = true
+	if client.SeatIndex < 0 {
+		m.ips[client.IP] = true
+	}
+}
+
+// handInProgress reports whether cards are out (spectators can't talk to players)
+func handInProgress(phase game.Phase) bool {
+	switch phase {
+	case game.PhaseBidding, game.PhaseKitty, game.PhaseDiscard, game.PhasePlaying:
+		return true
+	}
+	return false
+}
+
+func (gs *GameServer) handleChat(client *Client, msg ClientMessage) error {
+	text := strings.TrimSpace(msg.Text)
+	if text == "" {
+		return ErrChatEmpty
+	}
+	if utf8.RuneCountInString(text) > MaxChatLength {
+		return ErrChatTooLong
+	}
+
+	channel := msg.Channel
+	if channel == "" {
+		channel = ChatTable
+	}
+
+	chat := ChatMessage{
+		SeatIndex: client.SeatIndex,
+		Channel:   channel,
+		Text:      text,
+		SentAt:    time.Now(),
+	}
+
+	if client.SeatIndex >= 0 {
+		player := gs.State.Players[client.SeatIndex]
+		if player == nil {
+			return game.ErrSeatEmpty
+		}
+		if gs.mutes.muted(client, player) {
+			return ErrChatMuted
+		}
+		chat.Name = player.Name
+	} else {
+		if gs.mutes.muted(client, nil) {
+			return ErrChatMuted
+		}
+		// Spectators are named by the server so they can't pass as a player
+		chat.SeatIndex = -1
+		chat.ClientID = client.ID
+		chat.Name = spectatorName(client)
+	}
+
+	switch channel {
+	case ChatTable:
+		chat.audience = audienceAll
+		// Spectators can only talk among themselves while a hand is being played
+		if client.SeatIndex < 0 && handInProgress(gs.State.Phase) {
+			chat.audience = audienceSpectators
+		}
+	case ChatTeam:
+		if client.SeatIndex < 0 {
+			return ErrTeamChatNoSeat
+		}
+		chat.audience = audienceTeam
+		chat.team = gs.State.GetTeamForPlayer(client.SeatIndex)
+	default:
+		return ErrInvalidChannel
+	}
+
+	gs.chat.add(chat)
+	gs.Hub.BroadcastTo(ServerMessage{Type: MsgChatMessage, Chat: &chat}, chat.visibleTo)
+	return nil
+}
+
+// spectatorName is how a spectator is shown in chat
+func spectatorName(client *Client) string {
+	return fmt.Sprintf("Spectator %d", client.ID)
+}
+
+// handleMutePlayer mutes or unmutes a seat, or a spectator named by clientId.
+// Mutes also follow the muted client (see chatMutes), so they stick when
+// it leaves its seat or reconnects.
+func (gs *GameServer) handleMutePlayer(client *Client, msg ClientMessage) error {
+	if client.SeatIndex < 0 {
+		return game.ErrInvalidAction
+	}
+
+	muted := true
+	if msg.Muted != nil {
+		muted = *msg.Muted
+	}
+
+	if msg.ClientID != nil {
+		if client.SeatIndex != gs.State.House {
+			return errMuteHouseOnly
+		}
+		target := gs.Hub.GetClientByID(*msg.ClientID)
+		if target == nil || target.SeatIndex >= 0 {
+			return ErrNotSpectator
+		}
+		gs.mutes.set(target, muted)
+		return nil
+	}
+
+	if msg.SeatIndex == nil {
+		return game.ErrInvalidAction
+	}
+
+	targetSeat := *msg.SeatIndex
+	action := game.Action{
+		Type:        game.ActionMutePlayer,
+		PlayerIndex: client.SeatIndex,
+		TargetSeat:  targetSeat,
+		Muted:       muted,
+	}
+
+	_, err := game.ApplyAction(gs.State, action)
+	if err != nil {
+		return err
+	}
+
+	if target := gs.Hub.GetClientBySeat(targetSeat); target != nil {
+		gs.mutes.set(target, muted)
+	}
+	return nil
+}
+
+// sendChatHistory sends the recent chat a client is allowed to see
+func (gs *GameServer) sendChatHistory(client *Client) {
+	var joinedTeam time.Time
+	if client.SeatIndex >= 0 {
+		joinedTeam = gs.joinedTeam[client.SeatIndex]
+	}
+	history := gs.chat.visibleTo(client, joinedTeam)
+	if len(history) == 0 {
+		return
+	}
+	gs.Hub.SendToClient(client, ServerMessage{
+		Type:        MsgChatHistory,
+		ChatHistory: history,
+	})
+}
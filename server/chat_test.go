@@ -0,0 +1,290 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"setback/game"
+)
+
+// chatTable starts a table with n registered clients that have said hello,
+// each from its own IP. Tests run on the hub goroutine through gs.Hub.Do.
+func chatTable(t *testing.T, n int) (*GameServer, []*Client) {
+	t.Helper()
+	gs := NewGameServer(NewHub(), 21)
+	go gs.Run()
+	clients := make([]*Client, n)
+	for i := range clients {
+		clients[i] = newTestClient(gs.Hub, newFakeConn())
+		clients[i].ID = uint64(i + 1)
+		clients[i].IP = "10.0.1." + string(rune('1'+i))
+	}
+	register(gs.Hub, clients...)
+	gs.Hub.Do(func() {
+		for _, c := range clients {
+			gs.HandleMessage(c, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion})
+			drain(c)
+		}
+	})
+	return gs, clients
+}
+
+// sit seats a client and throws away what it was sent
+func sit(gs *GameServer, client *Client, seat int, name string) {
+	gs.HandleMessage(client, ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: name})
+	drain(client)
+}
+
+// chatLines returns the chat messages among msgs
+func chatLines(msgs []ServerMessage) []ChatMessage {
+	var lines []ChatMessage
+	for _, m := range msgs {
+		if m.Type == MsgChatMessage {
+			lines = append(lines, *m.Chat)
+		}
+	}
+	return lines
+}
+
+func TestSpectatorChatNamedByServer(t *testing.T) {
+	gs, clients := chatTable(t, 2)
+	player, spectator := clients[0], clients[1]
+	gs.Hub.Do(func() {
+		sit(gs, player, 0, "Alice")
+		drain(spectator)
+
+		gs.HandleMessage(spectator, ClientMessage{Type: MsgChat, Text: "hi", PlayerName: "Alice"})
+		lines := chatLines(drain(player))
+		if len(lines) != 1 {
+			t.Fatalf("Expected 1 chat line, got %d", len(lines))
+		}
+		if lines[0].Name != "Spectator 2" || lines[0].SeatIndex != -1 || lines[0].ClientID != spectator.ID {
+			t.Errorf("Spectator should be named by the server, got %+v", lines[0])
+		}
+	})
+}
+
+func TestHouseMutesSpectator(t *testing.T) {
+	gs, clients := chatTable(t, 3)
+	house, guest, spectator := clients[0], clients[1], clients[2]
+	gs.Hub.Do(func() {
+		sit(gs, house, 0, "House")
+		sit(gs, guest, 1, "Guest")
+
+		id := spectator.ID
+		gs.HandleMessage(guest, ClientMessage{Type: MsgMutePlayer, ClientID: &id})
+		if reply := lastOfType(drain(guest), MsgError); reply == nil || reply.Error.Code != string(game.CodeHouseOnly) {
+			t.Errorf("Only the house should mute, got %+v", reply)
+		}
+
+		gs.HandleMessage(house, ClientMessage{Type: MsgMutePlayer, ClientID: &id})
+		gs.HandleMessage(spectator, ClientMessage{Type: MsgChat, Text: "spam"})
+		if reply := lastOfType(drain(spectator), MsgError); reply == nil || reply.Error.Code != string(ErrChatMuted.Code) {
+			t.Errorf("Muted spectator should be refused, got %+v", reply)
+		}
+
+		// The mute follows the spectator's IP into a new connection
+		again := newTestClient(gs.Hub, newFakeConn())
+		again.IP = spectator.IP
+		gs.HandleMessage(again, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion})
+		gs.HandleMessage(again, ClientMessage{Type: MsgChat, Text: "spam"})
+		if reply := lastOfType(drain(again), MsgError); reply == nil || reply.Error.Code != string(ErrChatMuted.Code) {
+			t.Errorf("Reconnecting should not lift the mute, got %+v", reply)
+		}
+
+		seat := 1
+		gs.HandleMessage(house, ClientMessage{Type: MsgMutePlayer, ClientID: &guest.ID})
+		if reply := lastOfType(drain(house), MsgError); reply == nil || reply.Error.Code != string(ErrNotSpectator.Code) {
+			t.Errorf("Seated players are muted by seat, got %+v", reply)
+		}
+		gs.HandleMessage(house, ClientMessage{Type: MsgMutePlayer, SeatIndex: &seat})
+		gs.HandleMessage(guest, ClientMessage{Type: MsgLeaveSeat})
+		gs.HandleMessage(guest, ClientMessage{Type: MsgChat, Text: "spam"})
+		if reply := lastOfType(drain(guest), MsgError); reply == nil || reply.Error.Code != string(ErrChatMuted.Code) {
+			t.Errorf("Leaving the seat should not lift the mute, got %+v", reply)
+		}
+
+		unmute := false
+		gs.HandleMessage(house, ClientMessage{Type: MsgMutePlayer, ClientID: &id, Muted: &unmute})
+		gs.HandleMessage(spectator, ClientMessage{Type: MsgChat, Text: "sorry"})
+		if reply := lastOfType(drain(spectator), MsgError); reply != nil {
+			t.Errorf("Unmuted spectator should chat, got %+v", reply.Error)
+		}
+	})
+}
+
+func TestMuteSurvivesNewGame(t *testing.T) {
+	gs, clients := startedGame(t)
+	register(gs.Hub, clients[:]...)
+	gs.Hub.Do(func() {
+		seat := (gs.State.House + 1) % 4
+		gs.HandleMessage(clients[gs.State.House], ClientMessage{Type: MsgMutePlayer, SeatIndex: &seat})
+		gs.State.Phase = game.PhaseFinished
+		gs.HandleMessage(clients[0], ClientMessage{Type: MsgNewHand})
+		if gs.State.Phase != game.PhaseLobby {
+			t.Fatalf("Expected the lobby, got %s", gs.State.Phase)
+		}
+		if !gs.State.Players[seat].Muted {
+			t.Error("Mute should carry over to the next game")
+		}
+	})
+}
+
+func TestChatHistorySentOnConnect(t *testing.T) {
+	gs, clients := chatTable(t, 1)
+	gs.Hub.Do(func() {
+		gs.HandleMessage(clients[0], ClientMessage{Type: MsgChat, Text: "anyone here?"})
+	})
+
+	late := newTestClient(gs.Hub, newFakeConn())
+	register(gs.Hub, late)
+	gs.SendState(late)
+	gs.Hub.Do(func() {
+		history := lastOfType(drain(late), MsgChatHistory)
+		if history == nil || len(history.ChatHistory) != 1 || history.ChatHistory[0].Text != "anyone here?" {
+			t.Errorf("Expected the chat history on connect, got %+v", history)
+		}
+	})
+}
+
+// received reports which clients got a chat line with the given text
+func received(clients []*Client, text string) []bool {
+	got := make([]bool, len(clients))
+	for i, c := range clients {
+		for _, line := range chatLines(drain(c)) {
+			if line.Text == text {
+				got[i] = true
+			}
+		}
+	}
+	return got
+}
+
+func TestChatRouting(t *testing.T) {
+	gs, clients := chatTable(t, 5)
+	spectator := clients[4]
+	gs.Hub.Do(func() {
+		for i := 0; i < 4; i++ {
+			sit(gs, clients[i], i, "P")
+		}
+		drain(spectator)
+
+		check := func(from *Client, channel ChatChannel, text string, want []bool) {
+			t.Helper()
+			gs.HandleMessage(from, ClientMessage{Type: MsgChat, Channel: channel, Text: text})
+			got := received(clients, text)
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("%q: client %d received %v, want %v", text, i, got[i], want[i])
+				}
+			}
+		}
+
+		check(clients[0], ChatTable, "lobby table", []bool{true, true, true, true, true})
+		check(spectator, ChatTable, "lobby spectator", []bool{true, true, true, true, true})
+		check(clients[1], ChatTeam, "team 1", []bool{false, true, false, true, false})
+
+		gs.HandleMessage(clients[gs.State.House], ClientMessage{Type: MsgStartGame})
+		check(clients[2], ChatTable, "hand table", []bool{true, true, true, true, true})
+		check(clients[2], ChatTeam, "hand team 0", []bool{true, false, true, false, false})
+		check(spectator, ChatTable, "hand spectator", []bool{false, false, false, false, true})
+
+		gs.HandleMessage(spectator, ClientMessage{Type: MsgChat, Channel: ChatTeam, Text: "psst"})
+		if reply := lastOfType(drain(spectator), MsgError); reply == nil || reply.Error.Code != string(ErrTeamChatNoSeat.Code) {
+			t.Errorf("Spectators have no team, got %+v", reply)
+		}
+	})
+}
+
+func TestChatRejectsBadMessages(t *testing.T) {
+	gs, clients := chatTable(t, 1)
+	tests := []struct {
+		msg  ClientMessage
+		want *game.Error
+	}{
+		{ClientMessage{Type: MsgChat, Text: "   "}, ErrChatEmpty},
+		{ClientMessage{Type: MsgChat, Text: strings.Repeat("a", MaxChatLength+1)}, ErrChatTooLong},
+		{ClientMessage{Type: MsgChat, Text: "hi", Channel: "whisper"}, ErrInvalidChannel},
+	}
+	gs.Hub.Do(func() {
+		for _, tt := range tests {
+			gs.HandleMessage(clients[0], tt.msg)
+			if reply := lastOfType(drain(clients[0]), MsgError); reply == nil || reply.Error.Code != string(tt.want.Code) {
+				t.Errorf("%+v: expected %s, got %+v", tt.msg, tt.want.Code, reply)
+			}
+		}
+	})
+}
+
+func TestChatHistoryOnJoin(t *testing.T) {
+	gs, clients := chatTable(t, 2)
+	gs.Hub.Do(func() {
+		sit(gs, clients[0], 0, "P")
+		gs.HandleMessage(clients[0], ClientMessage{Type: MsgChat, Text: "hello all"})
+		gs.HandleMessage(clients[0], ClientMessage{Type: MsgChat, Channel: ChatTeam, Text: "team only"})
+		drain(clients[1])
+	})
+
+	// A spectator sees table chat but not the team's
+	gs.SendState(clients[1])
+	gs.Hub.Do(func() {
+		history := lastOfType(drain(clients[1]), MsgChatHistory)
+		if history == nil || len(history.ChatHistory) != 1 || history.ChatHistory[0].Text != "hello all" {
+			t.Errorf("Spectator should see only table chat, got %+v", history)
+		}
+
+		// Joining the team later doesn't bring its earlier chat along
+		seat := 2
+		gs.HandleMessage(clients[1], ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: "Partner"})
+		history = lastOfType(drain(clients[1]), MsgChatHistory)
+		if history == nil || len(history.ChatHistory) != 1 {
+			t.Errorf("A new partner should not see the team's earlier chat, got %+v", history)
+		}
+
+		// What the team says from then on is replayed
+		gs.HandleMessage(clients[0], ClientMessage{Type: MsgChat, Channel: ChatTeam, Text: "welcome"})
+		drain(clients[1])
+		gs.sendChatHistory(clients[1])
+		history = lastOfType(drain(clients[1]), MsgChatHistory)
+		if history == nil || len(history.ChatHistory) != 2 || history.ChatHistory[1].Text != "welcome" {
+			t.Errorf("Partner should see team chat from after joining, got %+v", history)
+		}
+	})
+}
+
+func TestMuteFollowsThePerson(t *testing.T) {
+	gs, clients := chatTable(t, 4)
+	house, guest, roommate, member := clients[0], clients[1], clients[2], clients[3]
+	roommate.IP = guest.IP
+	member.Account = "mallory"
+	gs.Hub.Do(func() {
+		sit(gs, house, 0, "House")
+		sit(gs, guest, 1, "Guest")
+
+		// Muting a seated guest doesn't silence others on its IP
+		seat := 1
+		gs.HandleMessage(house, ClientMessage{Type: MsgMutePlayer, SeatIndex: &seat})
+		gs.HandleMessage(roommate, ClientMessage{Type: MsgChat, Text: "not me"})
+		if reply := lastOfType(drain(roommate), MsgError); reply != nil {
+			t.Errorf("A spectator sharing the muted player's IP should chat, got %+v", reply.Error)
+		}
+
+		// A registered player's mute follows the account, not the IP
+		id := member.ID
+		gs.HandleMessage(house, ClientMessage{Type: MsgMutePlayer, ClientID: &id})
+		again := newTestClient(gs.Hub, newFakeConn())
+		again.IP, again.Account = "10.0.9.9", "mallory"
+		neighbour := newTestClient(gs.Hub, newFakeConn())
+		neighbour.IP = member.IP
+		for _, c := range []*Client{again, neighbour} {
+			gs.HandleMessage(c, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion})
+			gs.HandleMessage(c, ClientMessage{Type: MsgChat, Text: "hi"})
+		}
+		if reply := lastOfType(drain(again), MsgError); reply == nil || reply.Error.Code != string(ErrChatMuted.Code) {
+			t.Errorf("The account should stay muted from another IP, got %+v", reply)
+		}
+		if reply := lastOfType(drain(neighbour), MsgError); reply != nil {
+			t.Errorf("A guest on the account's IP should chat, got %+v", reply.Error)
+		}
+	})
+}
@@ -0,0 +1,312 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the server configuration. It is built from the defaults, then
+// a JSON config file, then SETBACK_* environment variables, then flags.
+type Config struct {
+	Listen         string      `json:"listen"`         // Address to listen on, e.g. ":8080"
+	TLS            TLSSettings `json:"tls"`            // Serve HTTPS when cert and key are set
+	StaticDir      string      `json:"staticDir"`      // Serve the web client from here instead of the embedded copy
+	AllowedOrigins []string    `json:"allowedOrigins"` // Origins allowed to open WebSockets besides our own
+	Dev            bool        `json:"dev"`            // Accept WebSockets from any origin
+	DataDir        string      `json:"dataDir"`        // Accounts, keys and saved tables
+	AdminToken     string      `json:"adminToken"`     // Enables the admin API
+	Rules          Rules       `json:"rules"`
+	Timers         Timers      `json:"timers"`
+	Limits         Limits      `json:"limits"`
+	Log            LogConfig   `json:"log"`
+}
+
+// TLSSettings are the certificate and key paths, reloaded on SIGHUP
+type TLSSettings struct {
+	Cert     string `json:"cert"`
+	Key      string `json:"key"`
+	Redirect string `json:"redirect"` // Plain-HTTP address that redirects to HTTPS, e.g. ":80"
+}
+
+// Enabled reports whether TLS is configured
+func (t TLSSettings) Enabled() bool {
+	return t.Cert != "" || t.Key != ""
+}
+
+// Rules are the table's default rules
+type Rules struct {
+	TargetScore int `json:"targetScore"`
+}
+
+// Timers are connection and shutdown timings
+type Timers struct {
+	PingPeriod      Duration `json:"pingPeriod"`
+	PongWait        Duration `json:"pongWait"`
+	WriteWait       Duration `json:"writeWait"`
+	MaxLag          Duration `json:"maxLag"` // 0 = never disconnect slow clients
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+}
+
+// Limits cap what a client may send
+type Limits struct {
+	MaxMessageSize int64 `json:"maxMessageSize"`
+	MaxConnsPerIP  int   `json:"maxConnsPerIP"` // 0 = no cap
+}
+
+// LogConfig selects the log level and format
+type LogConfig struct {
+	Level  string `json:"level"`  // debug, info, warn or error
+	Format string `json:"format"` // text or json
+}
+
+// Duration is a time.Duration written as a string like "30s" in config files
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"30s\", got %s", data)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	d.Duration = v
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// DefaultConfig returns the configuration used when nothing is set
+func DefaultConfig() Config {
+	conn := DefaultConnConfig()
+	return Config{
+		Listen:  ":8080",
+		DataDir: "data",
+		Rules: Rules{
+			TargetScore: 52,
+		},
+		Timers: Timers{
+			PingPeriod:      Duration{conn.PingPeriod},
+			PongWait:        Duration{conn.PongWait},
+			WriteWait:       Duration{conn.WriteWait},
+			MaxLag:          Duration{conn.MaxLag},
+			ShutdownTimeout: Duration{15 * time.Second},
+		},
+		Limits: Limits{
+			MaxMessageSize: conn.MaxMessageSize,
+			MaxConnsPerIP:  8,
+		},
+		Log: LogConfig{Level: "info", Format: LogFormatText},
+	}
+}
+
+// LoadConfig reads a JSON config file over the defaults.
+// Unknown keys are an error, so typos don't go unnoticed.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// envVars maps each SETBACK_* environment variable to the setting it overrides
+var envVars = []struct {
+	name string
+	set  func(c *Config, v string) error
+}{
+	{"SETBACK_LISTEN", func(c *Config, v string) error { c.Listen = v; return nil }},
+	{"SETBACK_TLS_CERT", func(c *Config, v string) error { c.TLS.Cert = v; return nil }},
+	{"SETBACK_TLS_KEY", func(c *Config, v string) error { c.TLS.Key = v; return nil }},
+	{"SETBACK_TLS_REDIRECT", func(c *Config, v string) error { c.TLS.Redirect = v; return nil }},
+	{"SETBACK_STATIC_DIR", func(c *Config, v string) error { c.StaticDir = v; return nil }},
+	{"SETBACK_ALLOWED_ORIGINS", func(c *Config, v string) error { c.AllowedOrigins = splitList(v); return nil }},
+	{"SETBACK_DEV", func(c *Config, v string) error { return parseBool(v, &c.Dev) }},
+	{"SETBACK_DATA_DIR", func(c *Config, v string) error { c.DataDir = v; return nil }},
+	{"SETBACK_ADMIN_TOKEN", func(c *Config, v string) error { c.AdminToken = v; return nil }},
+	{"SETBACK_TARGET_SCORE", func(c *Config, v string) error { return parseInt(v, &c.Rules.TargetScore) }},
+	{"SETBACK_PING_PERIOD", func(c *Config, v string) error { return parseDuration(v, &c.Timers.PingPeriod) }},
+	{"SETBACK_PONG_WAIT", func(c *Config, v string) error { return parseDuration(v, &c.Timers.PongWait) }},
+	{"SETBACK_WRITE_WAIT", func(c *Config, v string) error { return parseDuration(v, &c.Timers.WriteWait) }},
+	{"SETBACK_MAX_LAG", func(c *Config, v string) error { return parseDuration(v, &c.Timers.MaxLag) }},
+	{"SETBACK_SHUTDOWN_TIMEOUT", func(c *Config, v string) error { return parseDuration(v, &c.Timers.ShutdownTimeout) }},
+	{"SETBACK_MAX_MESSAGE_SIZE", func(c *Config, v string) error {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", v)
+		}
+		c.Limits.MaxMessageSize = n
+		return nil
+	}},
+	{"SETBACK_MAX_CONNS_PER_IP", func(c *Config, v string) error { return parseInt(v, &c.Limits.MaxConnsPerIP) }},
+	{"SETBACK_LOG_LEVEL", func(c *Config, v string) error { c.Log.Level = v; return nil }},
+	{"SETBACK_LOG_FORMAT", func(c *Config, v string) error { c.Log.Format = v; return nil }},
+}
+
+// ApplyEnv overrides settings from SETBACK_* environment variables, looked up
+// with lookup (os.LookupEnv outside tests). Unparseable values are reported together.
+func (c *Config) ApplyEnv(lookup func(string) (string, bool)) error {
+	var errs []error
+	for _, ev := range envVars {
+		if v, ok := lookup(ev.name); ok {
+			if err := ev.set(c, strings.TrimSpace(v)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", ev.name, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the configuration, reporting every problem at once
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, port, err := net.SplitHostPort(c.Listen); err != nil || port == "" {
+		fail("listen: %q is not a host:port address (e.g. \":8080\")", c.Listen)
+	}
+	if c.TLS.Enabled() {
+		if c.TLS.Cert == "" || c.TLS.Key == "" {
+			fail("tls: cert and key must be set together")
+		}
+		for _, path := range []string{c.TLS.Cert, c.TLS.Key} {
+			if path != "" {
+				if _, err := os.Stat(path); err != nil {
+					fail("tls: %v", err)
+				}
+			}
+		}
+		if c.TLS.Cert != "" && c.TLS.Key != "" {
+			if _, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key); err != nil && !errors.Is(err, os.ErrNotExist) {
+				fail("tls: %v", err)
+			}
+		}
+	}
+	if c.TLS.Redirect != "" {
+		if !c.TLS.Enabled() {
+			fail("tls.redirect: needs tls.cert and tls.key")
+		}
+		if _, port, err := net.SplitHostPort(c.TLS.Redirect); err != nil || port == "" {
+			fail("tls.redirect: %q is not a host:port address (e.g. \":80\")", c.TLS.Redirect)
+		} else if c.TLS.Redirect == c.Listen {
+			fail("tls.redirect: %q is also the HTTPS listen address", c.TLS.Redirect)
+		}
+	}
+	if c.StaticDir != "" {
+		if info, err := os.Stat(c.StaticDir); err != nil || !info.IsDir() {
+			fail("staticDir: %q is not a directory", c.StaticDir)
+		}
+	}
+	for _, origin := range c.AllowedOrigins {
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			fail("allowedOrigins: %q must start with http:// or https://", origin)
+		}
+	}
+	if c.DataDir == "" {
+		fail("dataDir: must be set")
+	}
+
+	if c.Rules.TargetScore < 1 || c.Rules.TargetScore > MaxTargetScore {
+		fail("rules.targetScore: %d is not between 1 and %d", c.Rules.TargetScore, MaxTargetScore)
+	}
+
+	t := c.Timers
+	timers := []struct {
+		name string
+		d    Duration
+	}{{"pingPeriod", t.PingPeriod}, {"pongWait", t.PongWait}, {"writeWait", t.WriteWait}, {"shutdownTimeout", t.ShutdownTimeout}}
+	for _, timer := range timers {
+		if timer.d.Duration <= 0 {
+			fail("timers.%s: must be positive", timer.name)
+		}
+	}
+	if t.PingPeriod.Duration >= t.PongWait.Duration {
+		fail("timers.pingPeriod (%v) must be less than timers.pongWait (%v)", t.PingPeriod, t.PongWait)
+	}
+	if t.MaxLag.Duration < 0 {
+		fail("timers.maxLag: must be 0 (never) or more")
+	}
+	if c.Limits.MaxMessageSize < 512 {
+		fail("limits.maxMessageSize: %d is too small (minimum 512 bytes)", c.Limits.MaxMessageSize)
+	}
+	if c.Limits.MaxConnsPerIP < 0 {
+		fail("limits.maxConnsPerIP: must be 0 (no cap) or more")
+	}
+	if _, err := NewLogHandler(nil, c.Log.Format, c.Log.Level); err != nil {
+		fail("log: %v", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConnConfig returns the WebSocket connection settings
+func (c *Config) ConnConfig() ConnConfig {
+	return ConnConfig{
+		WriteWait:      c.Timers.WriteWait.Duration,
+		PongWait:       c.Timers.PongWait.Duration,
+		PingPeriod:     c.Timers.PingPeriod.Duration,
+		MaxMessageSize: c.Limits.MaxMessageSize,
+		MaxLag:         c.Timers.MaxLag.Duration,
+	}
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseBool(s string, dst *bool) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q", s)
+	}
+	*dst = v
+	return nil
+}
+
+func parseInt(s string, dst *int) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", s)
+	}
+	*dst = v
+	return nil
+}
+
+func parseDuration(s string, dst *Duration) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q", s)
+	}
+	dst.Duration = v
+	return nil
+}
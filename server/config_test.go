@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "setback.json")
+	if err := os.WriteFile(path, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDefaultConfigIsValid(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Default config should be valid: %v", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+		"listen": "127.0.0.1:9000",
+		"rules": {"targetScore": 21},
+		"timers": {"pongWait": "90s"},
+		"log": {"level": "debug"}
+	}`)
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "127.0.0.1:9000" || cfg.Rules.TargetScore != 21 || cfg.Log.Level != "debug" {
+		t.Errorf("File settings not applied: %+v", cfg)
+	}
+	if cfg.Timers.PongWait.Duration != 90*time.Second {
+		t.Errorf("pongWait = %v, want 90s", cfg.Timers.PongWait)
+	}
+	// Anything the file leaves out keeps its default
+	if cfg.Timers.PingPeriod != DefaultConfig().Timers.PingPeriod || cfg.DataDir != "data" || cfg.Log.Format != LogFormatText {
+		t.Errorf("Unset settings should keep their defaults: %+v", cfg)
+	}
+}
+
+func TestLoadConfigRejectsMistakes(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{`{"listne": ":80"}`, `unknown field "listne"`},
+		{`{"timers": {"pongWait": 60}}`, `duration must be a string`},
+		{`{"timers": {"pongWait": "a minute"}}`, `invalid duration "a minute"`},
+		{`{"rules": {"targetScore": "21"}}`, `targetScore`},
+	}
+	for _, tt := range tests {
+		path := writeConfig(t, tt.body)
+		_, err := LoadConfig(path)
+		if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), path) {
+			t.Errorf("LoadConfig(%s) = %v, want an error naming the file and %q", tt.body, err, tt.want)
+		}
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	env := map[string]string{
+		"SETBACK_LISTEN":           ":9090",
+		"SETBACK_ALLOWED_ORIGINS":  "https://a.example, https://b.example,",
+		"SETBACK_DEV":              "true",
+		"SETBACK_TARGET_SCORE":     "31",
+		"SETBACK_PING_PERIOD":      "20s",
+		"SETBACK_MAX_MESSAGE_SIZE": "8192",
+		"SETBACK_LOG_FORMAT":       " json ",
+	}
+	cfg := DefaultConfig()
+	cfg.Listen = ":7000" // as if set by a config file, which env overrides
+	err := cfg.ApplyEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":9090" || !cfg.Dev || cfg.Rules.TargetScore != 31 || cfg.Log.Format != LogFormatJSON {
+		t.Errorf("Environment not applied: %+v", cfg)
+	}
+	if len(cfg.AllowedOrigins) != 2 || cfg.AllowedOrigins[1] != "https://b.example" {
+		t.Errorf("AllowedOrigins = %q", cfg.AllowedOrigins)
+	}
+	if cfg.Timers.PingPeriod.Duration != 20*time.Second || cfg.Limits.MaxMessageSize != 8192 {
+		t.Errorf("Timers or limits not applied: %+v", cfg)
+	}
+	if cfg.DataDir != "data" {
+		t.Error("Unset variables should leave settings alone")
+	}
+}
+
+func TestApplyEnvReportsBadValues(t *testing.T) {
+	env := map[string]string{
+		"SETBACK_DEV":          "sometimes",
+		"SETBACK_TARGET_SCORE": "lots",
+		"SETBACK_PONG_WAIT":    "60",
+	}
+	cfg := DefaultConfig()
+	err := cfg.ApplyEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+	if err == nil {
+		t.Fatal("Bad environment values should be an error")
+	}
+	for _, want := range []string{
+		`SETBACK_DEV: invalid boolean "sometimes"`,
+		`SETBACK_TARGET_SCORE: invalid number "lots"`,
+		`SETBACK_PONG_WAIT: invalid duration "60"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error %q should mention %q", err, want)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Listen = "8080"
+	cfg.TLS.Cert = filepath.Join(t.TempDir(), "missing.pem")
+	cfg.StaticDir = filepath.Join(t.TempDir(), "nope")
+	cfg.AllowedOrigins = []string{"example.com"}
+	cfg.Rules.TargetScore = 0
+	cfg.Timers.PingPeriod = Duration{2 * time.Minute}
+	cfg.Limits.MaxMessageSize = 10
+	cfg.Log.Level = "loud"
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Validate should fail")
+	}
+	for _, want := range []string{
+		`listen: "8080" is not a host:port address`,
+		`tls: cert and key must be set together`,
+		`missing.pem`,
+		`staticDir: `,
+		`allowedOrigins: "example.com" must start with http:// or https://`,
+		`rules.targetScore: 0 is not between 1 and 500`,
+		`timers.pingPeriod (2m0s) must be less than timers.pongWait`,
+		`limits.maxMessageSize: 10 is too small`,
+		`log: `,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate error should mention %q, got:\n%v", want, err)
+		}
+	}
+}
+
+func TestConfigConnConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.ConnConfig() != DefaultConnConfig() {
+		t.Errorf("Default ConnConfig = %+v, want %+v", cfg.ConnConfig(), DefaultConnConfig())
+	}
+}
+
+func TestExampleConfigMatchesDefaults(t *testing.T) {
+	cfg, err := LoadConfig("../setback.example.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := json.Marshal(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.AllowedOrigins = nil // [] in the file, to show it's a list
+	if got, _ := json.Marshal(cfg); string(got) != string(want) {
+		t.Errorf("setback.example.json should list the defaults:\n got %s\nwant %s", got, want)
+	}
+}
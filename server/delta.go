@@ -0,0 +1,130 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// FeatureDelta is the hello feature for receiving stateDelta messages
+const FeatureDelta = "delta"
+
+// PatchOp is one JSON-patch-style (RFC 6902) operation.
+// Paths are JSON pointers into the client's last stateUpdate message.
+type PatchOp struct {
+	Op    string `json:"op"` // "add", "remove" or "replace"
+	Path  string `json:"path"`
+	Value any    `json:"value,omitempty"`
+}
+
+// stateView is a client's personalized stateUpdate as generic JSON,
+// kept so the next update can be sent as a diff against it
+type stateView map[string]any
+
+// buildStateView renders the stateUpdate message a seat would receive
+func buildStateView(msg ServerMessage) (stateView, []byte, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	var view stateView
+	if err := json.Unmarshal(data, &view); err != nil {
+		return nil, nil, err
+	}
+	return view, data, nil
+}
+
+// diffJSON appends the operations that turn old into new.
+// Objects are compared key by key; arrays and scalars are replaced whole.
+func diffJSON(path string, old, new any, ops []PatchOp) []PatchOp {
+	oldObj, oldIsObj := old.(map[string]any)
+	newObj, newIsObj := new.(map[string]any)
+
+	if oldIsObj && newIsObj {
+		for key := range oldObj {
+			if _, ok := newObj[key]; !ok {
+				ops = append(ops, PatchOp{Op: "remove", Path: path + "/" + escapePointer(key)})
+			}
+		}
+		for key, newVal := range newObj {
+			childPath := path + "/" + escapePointer(key)
+			oldVal, ok := oldObj[key]
+			if !ok {
+				ops = append(ops, PatchOp{Op: "add", Path: childPath, Value: newVal})
+				continue
+			}
+			ops = diffJSON(childPath, oldVal, newVal, ops)
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(old, new) {
+		ops = append(ops, PatchOp{Op: "replace", Path: path, Value: new})
+	}
+	return ops
+}
+
+// escapePointer escapes a key for use in a JSON pointer (RFC 6901)
+func escapePointer(key string) string {
+	key = strings.ReplaceAll(key, "~", "~0")
+	return strings.ReplaceAll(key, "/", "~1")
+}
+
+// sendState sends a client the current state for its seat: a delta against
+// what it last received if it supports deltas and is in sync, otherwise the full state
+func (gs *GameServer) sendState(client *Client, seatIndex int, forceFull bool) {
+	// A lagging client only gets full states until it catches up
+	if !gs.Hub.keepingUp(client) {
+		return
+	}
+	full := NewStateUpdateMessage(gs.State, seatIndex)
+	full.Seq = gs.stateSeq
+
+	view, data, err := buildStateView(full)
+	if err != nil {
+		gs.logger(client).Error("Building state view failed", "err", err)
+		return
+	}
+
+	if !forceFull && !client.lagging && client.Features[FeatureDelta] && client.lastView != nil {
+		patch := diffJSON("", map[string]any(client.lastView), map[string]any(view), nil)
+
+		// Only the sequence number moved: the client's view is still current,
+		// and the next delta can be based on the seq it already has
+		if len(patch) == 1 && patch[0].Path == "/seq" {
+			return
+		}
+
+		delta := ServerMessage{
+			Type:    MsgStateDelta,
+			Seq:     gs.stateSeq,
+			BaseSeq: client.lastSeq,
+			Patch:   patch,
+		}
+		// A delta that's no smaller than the full state isn't worth it
+		if deltaData, err := json.Marshal(delta); err == nil && len(deltaData) < len(data) {
+			client.lastView = view
+			client.lastSeq = gs.stateSeq
+			gs.Hub.queueState(client, deltaData, data)
+			return
+		}
+	}
+
+	client.lastView = view
+	client.lastSeq = gs.stateSeq
+	gs.Hub.queueState(client, data, data)
+}
+
+// SendState sends a client the full current state and the chat it can see
+// (e.g. right after connecting). Safe to call from any goroutine but the hub's.
+func (gs *GameServer) SendState(client *Client) {
+	gs.Hub.Do(func() {
+		gs.sendState(client, client.SeatIndex, true)
+		gs.sendChatHistory(client)
+	})
+}
+
+// handleResync sends the full state to a client that missed an update
+func (gs *GameServer) handleResync(client *Client) {
+	gs.sendState(client, client.SeatIndex, true)
+}
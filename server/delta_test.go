@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"setback/game"
+)
+
+// applyPatch applies add/remove/replace operations the way the browser client does
+func applyPatch(t *testing.T, doc map[string]any, ops []PatchOp) {
+	t.Helper()
+	for _, op := range ops {
+		keys := strings.Split(op.Path, "/")[1:]
+		for i, k := range keys {
+			keys[i] = strings.ReplaceAll(strings.ReplaceAll(k, "~1", "/"), "~0", "~")
+		}
+		var parent any = doc
+		for _, k := range keys[:len(keys)-1] {
+			parent = parent.(map[string]any)[k]
+		}
+		obj := parent.(map[string]any)
+		last := keys[len(keys)-1]
+		switch op.Op {
+		case "remove":
+			delete(obj, last)
+		case "add", "replace":
+			obj[last] = op.Value
+		default:
+			t.Fatalf("unexpected op %q", op.Op)
+		}
+	}
+}
+
+func decode(t *testing.T, data []byte) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestDiffJSONRoundTrip(t *testing.T) {
+	old := map[string]any{"a": 1.0, "b": map[string]any{"c": "x", "d~/": true}, "gone": 1.0}
+	new := map[string]any{"a": 2.0, "b": map[string]any{"c": "x", "d~/": false}, "list": []any{1.0}}
+
+	ops := diffJSON("", old, new, nil)
+	applyPatch(t, old, ops)
+	if !reflect.DeepEqual(old, new) {
+		t.Errorf("Patched %v, want %v", old, new)
+	}
+	if len(ops) != 4 {
+		t.Errorf("Expected 4 ops, got %d: %+v", len(ops), ops)
+	}
+}
+
+func TestSendStateDeltasTrackFullState(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	client := newTestClient(gs.Hub, newFakeConn())
+	client.SeatIndex = 0
+	client.Features = map[string]bool{FeatureDelta: true}
+
+	// Mirror of what the client has applied so far
+	var view map[string]any
+	var seq uint64
+
+	step := func(desc string, action game.Action) {
+		t.Helper()
+		newState, err := game.ApplyAction(gs.State, action)
+		if err != nil {
+			t.Fatalf("%s: %v", desc, err)
+		}
+		gs.State = newState
+		gs.stateSeq++
+		gs.sendState(client, client.SeatIndex, view == nil)
+
+		msg := decode(t, <-client.Send)
+		switch msg["type"] {
+		case string(MsgStateUpdate):
+			view = msg
+		case string(MsgStateDelta):
+			if uint64(msg["baseSeq"].(float64)) != seq {
+				t.Fatalf("%s: delta based on %v, client has %d", desc, msg["baseSeq"], seq)
+			}
+			var delta ServerMessage
+			if err := json.Unmarshal(mustMarshal(t, msg), &delta); err != nil {
+				t.Fatal(err)
+			}
+			applyPatch(t, view, delta.Patch)
+		default:
+			t.Fatalf("%s: unexpected message %v", desc, msg["type"])
+		}
+		seq = gs.stateSeq
+
+		want := NewStateUpdateMessage(gs.State, 0)
+		want.Seq = gs.stateSeq
+		if !reflect.DeepEqual(view, decode(t, mustMarshal(t, want))) {
+			t.Fatalf("%s: patched state differs from the full state", desc)
+		}
+	}
+
+	for i := 0; i < 4; i++ {
+		step("join", game.Action{Type: game.ActionJoinSeat, PlayerIndex: i, PlayerName: "P"})
+	}
+	step("start", game.Action{Type: game.ActionStartGame, PlayerIndex: 0})
+	step("bid", game.Action{Type: game.ActionPlaceBid, PlayerIndex: gs.State.CurrentPlayer, BidAmount: 2})
+	step("pass", game.Action{Type: game.ActionPlaceBid, PlayerIndex: gs.State.CurrentPlayer, BidAmount: 0})
+}
+
+func TestResyncSendsFullState(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	client := newTestClient(gs.Hub, newFakeConn())
+	client.Features = map[string]bool{FeatureDelta: true}
+
+	gs.stateSeq = 1
+	gs.sendState(client, -1, true)
+	<-client.Send
+
+	gs.stateSeq = 2
+	gs.handleResync(client)
+	msg := decode(t, <-client.Send)
+	if msg["type"] != string(MsgStateUpdate) || msg["seq"] != 2.0 {
+		t.Errorf("Expected full stateUpdate at seq 2, got %v at %v", msg["type"], msg["seq"])
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
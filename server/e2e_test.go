@@ -0,0 +1,510 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"setback/game"
+)
+
+// These tests run the real HTTP and WebSocket stack on a loopback port and
+// play whole games with scripted clients, checking every message they get
+
+// e2eServer starts a table behind the same handlers main registers and
+// returns its WebSocket URL
+func e2eServer(t *testing.T, targetScore int) (*GameServer, string) {
+	t.Helper()
+	captureLogs(t) // Every action is logged; keep them out of the test output
+	hub := NewHub()
+	// Scripted clients play far faster than people, all from loopback
+	unlimited := RateLimit{Rate: 1e6, Burst: 1e6}
+	hub.RateLimits = RateLimitConfig{Overall: unlimited, Default: unlimited, Strikes: unlimited}
+	hub.MaxConnsPerIP = 0
+	gs := NewGameServer(hub, targetScore)
+	go gs.Run()
+
+	health := &Health{}
+	health.SetReady(true)
+	mux := http.NewServeMux()
+	health.RegisterRoutes(mux)
+	NewAPI(gs).RegisterRoutes(mux)
+	upgrader := websocket.Upgrader{CheckOrigin: NewOriginPolicy(nil, false).Check}
+	mux.Handle("/ws", WebSocketHandler(gs, upgrader, health, nil))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return gs, "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+// e2eClient is a scripted client. Seated clients play a legal move whenever
+// it's theirs to make; spectators (seat -1) only watch. Every message is
+// checked as it arrives.
+type e2eClient struct {
+	t       *testing.T
+	name    string
+	seat    int
+	conn    *websocket.Conn
+	rng     *rand.Rand
+	sent    int
+	pending string // requestId of the message awaiting its reply
+
+	// What the client has been told
+	state   *PublicState
+	hand    []game.Card
+	kitty   []game.Card
+	seq     uint64
+	scored  *[2]int // Team scores before the hand just scored, until the state showing it
+	over    bool    // The game just ended, until the state showing it
+	results []game.ScoreResult
+	winners []int
+	views   map[uint64]e2eView
+}
+
+// e2eView is what a client was sent in one state update
+type e2eView struct {
+	state []byte // The public state, as JSON
+	hand  []game.Card
+	kitty []game.Card
+}
+
+func dialE2E(t *testing.T, url, name string, seat int) *e2eClient {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("%s: dialing: %v", name, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	c := &e2eClient{
+		t: t, name: name, seat: -1, conn: conn,
+		rng:   rand.New(rand.NewPCG(uint64(seat+1), 3)),
+		views: make(map[uint64]e2eView),
+	}
+	c.call(ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion})
+	if seat >= 0 {
+		c.call(ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: name})
+		if c.seat != seat {
+			t.Fatalf("%s: asked for seat %d, got %d", name, seat, c.seat)
+		}
+	}
+	return c
+}
+
+// send sends msg with a fresh requestId; the client waits for its reply
+// before doing anything else
+func (c *e2eClient) send(msg ClientMessage) {
+	c.sent++
+	msg.RequestID = fmt.Sprintf("%s-%d", c.name, c.sent)
+	c.pending = msg.RequestID
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Errorf("%s: sending %s: %v", c.name, msg.Type, err)
+	}
+}
+
+// call sends msg and reads until it's answered
+func (c *e2eClient) call(msg ClientMessage) {
+	c.t.Helper()
+	c.send(msg)
+	for c.pending != "" {
+		if !c.read() {
+			c.t.FailNow()
+		}
+	}
+}
+
+// read reads and checks one message, reporting false if the connection failed
+func (c *e2eClient) read() bool {
+	c.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, data, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Errorf("%s: reading: %v (last state %+v)", c.name, err, c.state)
+		return false
+	}
+	var msg ServerMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.t.Errorf("%s: bad message %s: %v", c.name, data, err)
+		return false
+	}
+	c.check(msg, data)
+	return true
+}
+
+// play reads and plays until the client has seen games end
+func (c *e2eClient) play(games int) {
+	c.act()
+	for len(c.winners) < games || c.over {
+		if !c.read() {
+			return
+		}
+		c.act()
+	}
+}
+
+// check verifies a message against everything the client was told before it
+func (c *e2eClient) check(msg ServerMessage, data []byte) {
+	t := c.t
+	if msg.RequestID != "" && msg.RequestID == c.pending {
+		c.pending = ""
+	}
+	// Taking a seat issues its token, before anything shows the seat
+	if msg.Type == MsgSession {
+		if msg.YourSeat == nil || msg.YourToken == "" {
+			t.Errorf("%s: session without a seat or token: %s", c.name, data)
+			return
+		}
+		c.seat = *msg.YourSeat
+	}
+	if c.seat < 0 {
+		var fields map[string]json.RawMessage
+		json.Unmarshal(data, &fields)
+		for _, field := range []string{"yourHand", "kitty", "yourSeat", "yourToken"} {
+			if _, ok := fields[field]; ok {
+				t.Errorf("%s: spectator was sent %s: %s", c.name, field, data)
+			}
+		}
+	} else if msg.Type != MsgStateUpdate && (msg.YourHand != nil || msg.Kitty != nil) {
+		t.Errorf("%s: %s carries cards: %s", c.name, msg.Type, data)
+	}
+
+	switch msg.Type {
+	case MsgError:
+		// Scripted clients only make legal moves
+		t.Errorf("%s: error %+v", c.name, msg.Error)
+	case MsgStateDelta:
+		t.Errorf("%s: sent a delta without asking for them", c.name)
+	case MsgStateUpdate:
+		c.checkState(msg)
+	case MsgScoreUpdate:
+		c.checkScore(msg)
+	case MsgGameOver:
+		c.checkGameOver(msg)
+	}
+}
+
+func (c *e2eClient) checkState(msg ServerMessage) {
+	t, s := c.t, msg.State
+	if s == nil {
+		t.Errorf("%s: state update without a state", c.name)
+		return
+	}
+	if msg.Seq < c.seq {
+		t.Errorf("%s: state %d after %d", c.name, msg.Seq, c.seq)
+	}
+
+	// Only your own hand, and the kitty only while you're picking from it
+	if c.seat < 0 {
+		if msg.YourSeat != nil || msg.YourHand != nil || msg.Kitty != nil {
+			t.Errorf("%s: spectator sent seat %v, hand %v, kitty %v", c.name, msg.YourSeat, msg.YourHand, msg.Kitty)
+		}
+	} else {
+		if msg.YourSeat == nil || *msg.YourSeat != c.seat {
+			t.Errorf("%s: told it sits at %v", c.name, msg.YourSeat)
+		}
+		if n := s.Players[c.seat].CardCount; len(msg.YourHand) != n {
+			t.Errorf("%s: sent %d cards but holds %d", c.name, len(msg.YourHand), n)
+		}
+		picking := s.Phase == game.PhaseKitty && s.BidWinner == c.seat
+		if !picking && msg.Kitty != nil {
+			t.Errorf("%s: sent the kitty in %s (bid winner %d)", c.name, s.Phase, s.BidWinner)
+		}
+		if picking && len(msg.Kitty) != s.KittyCount {
+			t.Errorf("%s: sent %d kitty cards of %d", c.name, len(msg.Kitty), s.KittyCount)
+		}
+		if dup := duplicateCard(append(slices.Clone(msg.YourHand), msg.Kitty...)); dup != "" {
+			t.Errorf("%s: %s sent twice", c.name, dup)
+		}
+	}
+
+	// Scores only change when a hand is scored
+	scores := [2]int{s.Teams[0].Score, s.Teams[1].Score}
+	switch {
+	case c.scored != nil:
+		want := s.Phase == game.PhaseScoring
+		if c.over {
+			want = s.Phase == game.PhaseFinished
+		}
+		if !want {
+			t.Errorf("%s: after scoring (game over %v), state is in %s", c.name, c.over, s.Phase)
+		}
+		last := c.results[len(c.results)-1]
+		if expected := [2]int{c.scored[0] + last.Team0Change, c.scored[1] + last.Team1Change}; scores != expected {
+			t.Errorf("%s: scores %v after scoring %+v from %v", c.name, scores, last, *c.scored)
+		}
+		c.scored, c.over = nil, false
+	case c.state != nil && s.Phase != game.PhaseLobby:
+		if prev := [2]int{c.state.Teams[0].Score, c.state.Teams[1].Score}; scores != prev {
+			t.Errorf("%s: scores went from %v to %v without a scoreUpdate", c.name, prev, scores)
+		}
+	}
+
+	public, _ := json.Marshal(s)
+	c.views[msg.Seq] = e2eView{state: public, hand: msg.YourHand, kitty: msg.Kitty}
+	c.state, c.hand, c.kitty, c.seq = s, msg.YourHand, msg.Kitty, msg.Seq
+}
+
+func (c *e2eClient) checkScore(msg ServerMessage) {
+	t, r, s := c.t, msg.ScoreResult, c.state
+	if r == nil {
+		t.Errorf("%s: score update without a result", c.name)
+		return
+	}
+	// The last state seen is the one before the hand's final card
+	if s.Phase != game.PhasePlaying || s.TricksPlayed != 5 {
+		t.Errorf("%s: hand scored in %s after %d tricks", c.name, s.Phase, s.TricksPlayed)
+	}
+	if bidder := s.BidWinner % 2; r.BidderTeam != bidder || r.BidAmount != s.WinningBid {
+		t.Errorf("%s: scored team %d's bid of %d, want team %d's of %d", c.name, r.BidderTeam, r.BidAmount, bidder, s.WinningBid)
+	}
+	changes, points := [2]int{r.Team0Change, r.Team1Change}, [2]int{r.Team0Points, r.Team1Points}
+	want := points
+	if !r.BidMade {
+		want[r.BidderTeam] = -r.BidAmount
+	}
+	if r.BidMade != (points[r.BidderTeam] >= r.BidAmount) || changes != want {
+		t.Errorf("%s: inconsistent score %+v", c.name, r)
+	}
+	c.scored = &[2]int{s.Teams[0].Score, s.Teams[1].Score}
+	c.results = append(c.results, *r)
+}
+
+func (c *e2eClient) checkGameOver(msg ServerMessage) {
+	t := c.t
+	if msg.WinningTeam == nil {
+		t.Errorf("%s: game over without a winner", c.name)
+		return
+	}
+	if c.scored == nil {
+		t.Errorf("%s: game over without a hand scored", c.name)
+		return
+	}
+	team := *msg.WinningTeam
+	last := c.results[len(c.results)-1]
+	score := c.scored[team] + [2]int{last.Team0Change, last.Team1Change}[team]
+	if score < c.state.TargetScore {
+		t.Errorf("%s: team %d won with %d of %d", c.name, team, score, c.state.TargetScore)
+	}
+	c.over = true
+	c.winners = append(c.winners, team)
+}
+
+// act makes the client's move, if there's one to make
+func (c *e2eClient) act() {
+	s := c.state
+	if c.seat < 0 || c.pending != "" || s == nil {
+		return
+	}
+	me := s.Players[c.seat]
+	myTurn := s.CurrentPlayer == c.seat
+	switch s.Phase {
+	case game.PhaseLobby:
+		for _, p := range s.Players {
+			if !p.Connected {
+				return
+			}
+		}
+		if s.House == c.seat {
+			c.send(ClientMessage{Type: MsgStartGame})
+		}
+	case game.PhaseBidding:
+		if myTurn {
+			bid := 0
+			if high := highBid(s.Bids); high < 4 && c.rng.IntN(3) == 0 {
+				bid = max(high+1, game.MinBid)
+			}
+			c.send(ClientMessage{Type: MsgPlaceBid, Amount: &bid})
+		}
+	case game.PhaseKitty:
+		if s.BidWinner != c.seat {
+			return
+		}
+		switch {
+		case s.Trump == nil:
+			c.send(ClientMessage{Type: MsgSelectTrump, TrumpSuit: longestSuit(c.hand).String()})
+		case s.KittyCount > 0:
+			trump := parseSuit(*s.Trump)
+			c.send(ClientMessage{Type: MsgTakeKitty, CardIDs: cardIDs(c.kitty, func(card game.Card) bool { return card.IsTrump(trump) })})
+		default:
+			// Keep the best six trump
+			trump := parseSuit(*s.Trump)
+			hand := slices.Clone(c.hand)
+			slices.SortFunc(hand, func(a, b game.Card) int { return power(b, trump) - power(a, trump) })
+			keep := hand[:min(6, len(hand))]
+			c.send(ClientMessage{Type: MsgDiscard, CardIDs: cardIDs(hand, func(card game.Card) bool {
+				return !card.IsTrump(trump) || !slices.Contains(keep, card)
+			})})
+		}
+	case game.PhaseDiscard:
+		if me.DiscardComplete || me.DiscardReady {
+			return
+		}
+		// Up to two low off-suit cards, handed in early if there are any
+		trump := parseSuit(*s.Trump)
+		low := cardIDs(c.hand, func(card game.Card) bool { return !card.IsTrump(trump) && card.Rank < game.Ten })
+		low = low[:min(2, len(low))]
+		if myTurn || len(low) > 0 {
+			c.send(ClientMessage{Type: MsgDiscardDraw, CardIDs: low})
+		}
+	case game.PhasePlaying:
+		if myTurn {
+			legal := legalCards(c.hand, s)
+			c.send(ClientMessage{Type: MsgPlayCard, CardID: legal[c.rng.IntN(len(legal))].ID})
+		}
+	case game.PhaseScoring, game.PhaseFinished:
+		if s.House == c.seat {
+			c.send(ClientMessage{Type: MsgNewHand})
+		}
+	}
+}
+
+func highBid(bids []game.Bid) int {
+	high := 0
+	for _, b := range bids {
+		high = max(high, b.Amount)
+	}
+	return high
+}
+
+func parseSuit(name string) game.Suit {
+	for _, s := range game.AllSuits() {
+		if s.String() == name {
+			return s
+		}
+	}
+	panic("unknown suit " + name)
+}
+
+func longestSuit(hand []game.Card) game.Suit {
+	counts := map[game.Suit]int{}
+	best := game.AllSuits()[0]
+	for _, c := range hand {
+		counts[c.Suit]++
+		if counts[c.Suit] > counts[best] {
+			best = c.Suit
+		}
+	}
+	return best
+}
+
+// power orders cards for keeping: trump first, highest first
+func power(c game.Card, trump game.Suit) int {
+	if c.IsTrump(trump) {
+		return 100 + int(2*c.TrumpRank(trump))
+	}
+	return int(c.Rank)
+}
+
+func cardIDs(cards []game.Card, keep func(game.Card) bool) []string {
+	ids := []string{}
+	for _, c := range cards {
+		if keep(c) {
+			ids = append(ids, c.ID)
+		}
+	}
+	return ids
+}
+
+// legalCards is the hand narrowed by the follow-suit rule
+func legalCards(hand []game.Card, s *PublicState) []game.Card {
+	if s.CurrentTrick == nil || len(s.CurrentTrick.Cards) == 0 {
+		return hand
+	}
+	trump := parseSuit(*s.Trump)
+	lead := parseSuit(s.CurrentTrick.LeadSuit)
+	var follow []game.Card
+	for _, c := range hand {
+		if (lead == trump && c.IsTrump(trump)) || (lead != trump && c.Suit == lead && !c.IsTrump(trump)) {
+			follow = append(follow, c)
+		}
+	}
+	if len(follow) > 0 {
+		return follow
+	}
+	return hand
+}
+
+// duplicateCard returns a card that appears twice in cards, or ""
+func duplicateCard(cards []game.Card) string {
+	seen := map[string]bool{}
+	for _, c := range cards {
+		if seen[c.ID] {
+			return c.ID
+		}
+		seen[c.ID] = true
+	}
+	return ""
+}
+
+func TestEndToEndGames(t *testing.T) {
+	const games = 2
+	gs, url := e2eServer(t, 11)
+	var players, spectators []*e2eClient
+	for seat := 0; seat < 4; seat++ {
+		players = append(players, dialE2E(t, url, fmt.Sprint("P", seat), seat))
+	}
+	for i := 0; i < 2; i++ {
+		spectators = append(spectators, dialE2E(t, url, fmt.Sprint("S", i), -1))
+	}
+	everyone := append(slices.Clone(players), spectators...)
+
+	var wg sync.WaitGroup
+	for _, c := range everyone {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.play(games)
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// Everyone saw the same hands scored and games won
+	first := everyone[0]
+	if len(first.results) < games {
+		t.Fatalf("%d hands scored in %d games", len(first.results), games)
+	}
+	for _, c := range everyone[1:] {
+		if !reflect.DeepEqual(c.results, first.results) || !reflect.DeepEqual(c.winners, first.winners) {
+			t.Errorf("%s saw scores %v and winners %v, %s saw %v and %v",
+				c.name, c.results, c.winners, first.name, first.results, first.winners)
+		}
+	}
+
+	// Each state everyone was sent shows the same table, and no two seats
+	// were dealt the same card or sent the kitty's
+	for seq, view := range first.views {
+		var held []game.Card
+		for _, c := range everyone {
+			v, ok := c.views[seq]
+			if !ok {
+				continue // Coalesced for a lagging client
+			}
+			if string(v.state) != string(view.state) {
+				t.Errorf("State %d differs: %s saw %s, %s saw %s", seq, c.name, v.state, first.name, view.state)
+			}
+			held = append(append(held, v.hand...), v.kitty...)
+		}
+		if dup := duplicateCard(held); dup != "" {
+			t.Errorf("State %d: %s was sent to two seats", seq, dup)
+		}
+	}
+
+	m := gs.Hub.Metrics
+	m.mu.Lock()
+	hands := m.hands
+	m.mu.Unlock()
+	if int(hands) != len(first.results) {
+		t.Errorf("The server scored %d hands, clients saw %d", hands, len(first.results))
+	}
+	t.Logf("%d games, %d hands, %d states", games, len(first.results), len(first.views))
+}
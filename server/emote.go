@@ -0,0 +1,82 @@
+package server
+
+import (
+	"setback/game"
+	"time"
+)
+
+// Emotes is the fixed catalog of table-talk emotes.
+// Free text can carry signals between partners; a short fixed list can't.
+var Emotes = []string{"nice", "oops", "thinking", "gg"}
+
+// EmoteCooldown is the minimum time between emotes from one client
+const EmoteCooldown = 3 * time.Second
+
+// Emote errors
+var (
+	ErrUnknownEmote   = game.NewError("unknown_emote", "unknown emote")
+	ErrEmotesDisabled = game.NewError("emotes_disabled", "emotes are turned off at this table")
+	ErrEmoteTooSoon   = game.NewError("emote_too_soon", "slow down - wait a moment before sending another emote")
+	ErrEmoteNeedsSeat = game.NewError("emote_needs_seat", "must be seated to send emotes")
+)
+
+// EmotePayload is an emote as delivered to clients
+type EmotePayload struct {
+	SeatIndex int    `json:"seatIndex"`
+	Emote     string `json:"emote"`
+}
+
+// isKnownEmote reports whether name is in the catalog
+func isKnownEmote(name string) bool {
+	for _, e := range Emotes {
+		if e == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (gs *GameServer) handleSendEmote(client *Client, msg ClientMessage) error {
+	if client.SeatIndex < 0 {
+		return ErrEmoteNeedsSeat
+	}
+	if gs.State.EmotesDisabled {
+		return ErrEmotesDisabled
+	}
+	if !isKnownEmote(msg.Emote) {
+		return ErrUnknownEmote
+	}
+	if !gs.Hub.AllowEmote(client) {
+		return ErrEmoteTooSoon
+	}
+
+	seatIndex := client.SeatIndex
+	gs.Hub.BroadcastMessage(ServerMessage{
+		Type:  MsgEmote,
+		Emote: &EmotePayload{SeatIndex: seatIndex, Emote: msg.Emote},
+	})
+	return nil
+}
+
+func (gs *GameServer) handleSetEmotes(client *Client, msg ClientMessage) error {
+	if client.SeatIndex < 0 {
+		return game.ErrInvalidAction
+	}
+
+	if msg.Enabled == nil {
+		return game.ErrInvalidAction
+	}
+
+	action := game.Action{
+		Type:        game.ActionSetEmotes,
+		PlayerIndex: client.SeatIndex,
+		Enabled:     *msg.Enabled,
+	}
+
+	_, err := game.ApplyAction(gs.State, action)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"setback/game"
+)
+
+func TestEmotes(t *testing.T) {
+	gs, clients := chatTable(t, 3)
+	house, guest, spectator := clients[0], clients[1], clients[2]
+	expectError := func(client *Client, want *game.Error) {
+		t.Helper()
+		if reply := lastOfType(drain(client), MsgError); reply == nil || reply.Error.Code != string(want.Code) {
+			t.Errorf("Expected %s, got %+v", want.Code, reply)
+		}
+	}
+	gs.Hub.Do(func() {
+		sit(gs, house, 0, "House")
+		sit(gs, guest, 1, "Guest")
+		drain(spectator)
+
+		gs.HandleMessage(guest, ClientMessage{Type: MsgSendEmote, Emote: "shrug"})
+		expectError(guest, ErrUnknownEmote)
+
+		gs.HandleMessage(guest, ClientMessage{Type: MsgSendEmote, Emote: "nice"})
+		emote := lastOfType(drain(spectator), MsgEmote)
+		if emote == nil || emote.Emote.SeatIndex != 1 || emote.Emote.Emote != "nice" {
+			t.Errorf("Everyone should see the emote, got %+v", emote)
+		}
+
+		gs.HandleMessage(guest, ClientMessage{Type: MsgSendEmote, Emote: "gg"})
+		expectError(guest, ErrEmoteTooSoon)
+		guest.lastEmote = time.Now().Add(-EmoteCooldown)
+		gs.HandleMessage(guest, ClientMessage{Type: MsgSendEmote, Emote: "gg"})
+		if lastOfType(drain(guest), MsgEmote) == nil {
+			t.Error("Emote should be allowed after the cooldown")
+		}
+
+		gs.HandleMessage(spectator, ClientMessage{Type: MsgSendEmote, Emote: "nice"})
+		expectError(spectator, ErrEmoteNeedsSeat)
+
+		off := false
+		gs.HandleMessage(guest, ClientMessage{Type: MsgSetEmotes, Enabled: &off})
+		expectError(guest, game.ErrHouseOnly)
+		gs.HandleMessage(house, ClientMessage{Type: MsgSetEmotes, Enabled: &off})
+		gs.HandleMessage(house, ClientMessage{Type: MsgSendEmote, Emote: "oops"})
+		expectError(house, ErrEmotesDisabled)
+	})
+}
@@ -0,0 +1,27 @@
+package server
+
+import "setback/game"
+
+// Errors lists the error codes the server sends on top of the engine's
+// (game.Errors), for documentation and protocol tooling
+var Errors = []*game.Error{
+	game.NewError(ErrCodeHelloRequired, "a message was sent before hello"),
+	game.NewError(ErrCodeIncompatibleProtocol, "the client's protocol version is not supported"),
+	game.NewError(ErrCodeRateLimited, "the message was dropped for exceeding a rate limit"),
+	game.NewError(ErrCodeInvalidRequestID, "the requestId is too long"),
+	game.NewError(ErrCodeActionFailed, "the action failed for a reason without its own code"),
+	game.NewError(ErrCodeInternal, "the server failed handling the message; nothing was changed"),
+	ErrUnknownMessage,
+	ErrRejoinFailed,
+	ErrShuttingDown,
+	ErrChatEmpty,
+	ErrChatTooLong,
+	ErrChatMuted,
+	ErrInvalidChannel,
+	ErrTeamChatNoSeat,
+	ErrNotSpectator,
+	ErrUnknownEmote,
+	ErrEmotesDisabled,
+	ErrEmoteTooSoon,
+	ErrEmoteNeedsSeat,
+}
@@ -1,40 +1,112 @@
 package server
 
 import (
-	"errors"
-	"log"
+	"context"
+	"log/slog"
 	"setback/game"
-	"sync"
+	"time"
 )
 
-// GameServer handles game logic and message routing
+// DefaultTableID identifies the server's table in session tokens
+const DefaultTableID = "main"
+
+// GameServer handles game logic and message routing. Its state belongs to
+// the hub goroutine: messages and disconnects arrive there, and other
+// goroutines reach the table through Hub.Do.
 type GameServer struct {
-	Hub   *Hub
-	State *game.GameState
-	mu    sync.Mutex
+	Hub     *Hub
+	State   *game.GameState
+	TableID string
+	Tokens  *TokenIssuer
+	chat    chatLog
+	// stateSeq numbers state broadcasts so clients can spot a missed update
+	stateSeq uint64
+	// seatRequests is each seat's request dedupe log, handed to whoever rejoins the seat
+	seatRequests [4]*requestLog
+	history      matchHistory
+	// handStartedAt is when the current hand was dealt, for the hand duration metric
+	handStartedAt time.Time
+	// draining is set on shutdown: no new games start
+	draining bool
+	// mutes are the house's chat mutes that follow a person rather than a seat
+	mutes chatMutes
+	// joinedTeam is when each seat's player joined its team, so team chat
+	// from before then isn't replayed to them
+	joinedTeam [4]time.Time
 }
 
-// NewGameServer creates a new game server
+// NewGameServer creates a game server for the hub's table
 func NewGameServer(hub *Hub, targetScore int) *GameServer {
-	return &GameServer{
-		Hub:   hub,
-		State: game.NewGameState(targetScore),
-	}
+	gs := &GameServer{
+		Hub:     hub,
+		State:   game.NewGameState(targetScore),
+		TableID: DefaultTableID,
+		Tokens:  NewTokenIssuer(newRandomKey()),
+		history: matchHistory{StartedAt: time.Now()},
+		mutes:   newChatMutes(),
+	}
+	hub.OnMessage = gs.HandleMessage
+	hub.OnDisconnect = gs.HandleDisconnect
+	return gs
 }
 
-// Run starts processing incoming messages
+// Run runs the table: the hub goroutine, which handles every message,
+// connection and disconnection in turn. It never returns.
 func (gs *GameServer) Run() {
-	for msg := range gs.Hub.Incoming {
-		gs.HandleMessage(msg.Client, msg.Message)
-	}
+	gs.Hub.Run()
 }
 
-// HandleMessage routes a message to the appropriate handler
+// ErrUnknownMessage is returned for a message type the server doesn't handle
+var ErrUnknownMessage = game.NewError("unknown_message", "unknown message type")
+
+// HandleMessage handles a message from a client. A panic while handling
+// it rolls the table back to how it was before the message.
 func (gs *GameServer) HandleMessage(client *Client, msg ClientMessage) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
+	gs.Hub.Metrics.countMessage(msg.Type)
+
+	var before *undoPoint
+	if !readOnly[msg.Type] {
+		before = gs.saveUndo()
+	}
+	defer gs.recoverMessage(client, msg, before)
+	gs.handleMessage(client, msg)
+}
+
+// handleMessage routes a message to the appropriate handler
+func (gs *GameServer) handleMessage(client *Client, msg ClientMessage) {
+	// Clients must say which protocol they speak before anything else
+	if msg.Type == MsgHello {
+		gs.respond(client, msg, gs.handleHello(client, msg))
+		return
+	}
+	if client.ProtocolVersion == 0 {
+		reply := NewErrorMessage(ErrCodeHelloRequired, "Client is out of date. Please reload the page.")
+		gs.respond(client, msg, &reply)
+		return
+	}
+
+	log := gs.logger(client)
+
+	// A retried request gets its original answer instead of being applied again
+	if msg.RequestID != "" {
+		if len(msg.RequestID) > MaxRequestIDLength {
+			reply := NewErrorMessage(ErrCodeInvalidRequestID, "Request ID is too long")
+			gs.Hub.SendToClient(client, reply)
+			return
+		}
+		if reply, ok := gs.requestLogFor(client).lookup(msg.RequestID, time.Now()); ok {
+			log.Debug("Retried request answered from the dedupe window", actionAttrs(msg)...)
+			gs.Hub.SendToClient(client, reply)
+			return
+		}
+	}
+
+	if cards := cardAttrs(msg); cards != nil {
+		log.Debug("Action cards", append([]any{"action", msg.Type}, cards...)...)
+	}
 
 	var err error
+	broadcast := true
 
 	switch msg.Type {
 	case MsgJoinTable:
@@ -47,6 +119,10 @@ func (gs *GameServer) HandleMessage(client *Client, msg ClientMessage) {
 		err = gs.handleKickPlayer(client, msg)
 	case MsgTransferHouse:
 		err = gs.handleTransferHouse(client, msg)
+	case MsgMutePlayer:
+		err = gs.handleMutePlayer(client, msg)
+	case MsgSetEmotes:
+		err = gs.handleSetEmotes(client, msg)
 	case MsgStartGame:
 		err = gs.handleStartGame(client)
 	case MsgPlaceBid:
@@ -67,18 +143,42 @@ func (gs *GameServer) HandleMessage(client *Client, msg ClientMessage) {
 		err = gs.handleNewHand(client)
 	case MsgResetGame:
 		err = gs.handleResetGame(client)
+	case MsgResync:
+		gs.handleResync(client)
+		broadcast = false
+	case MsgChat:
+		// Chat is delivered on its own; no state change to broadcast
+		err = gs.handleChat(client, msg)
+		broadcast = false
+	case MsgSendEmote:
+		// Emotes are a lightweight event; no state change to broadcast
+		err = gs.handleSendEmote(client, msg)
+		broadcast = false
 	default:
-		gs.Hub.SendToClient(client, NewErrorMessage("unknown_message", "Unknown message type"))
-		return
+		err = ErrUnknownMessage
 	}
 
 	if err != nil {
-		gs.Hub.SendToClient(client, NewErrorMessage("action_failed", err.Error()))
+		reply := NewActionErrorMessage(err)
+		gs.Hub.Metrics.countError(reply.Error.Code)
+		log.Info("Action rejected", append(actionAttrs(msg), "outcome", reply.Error.Code)...)
+		gs.respond(client, msg, &reply)
 		return
 	}
 
-	// Broadcast state update to all seated players
-	gs.broadcastState()
+	// Chatter that doesn't change the table only shows at debug level
+	level := slog.LevelInfo
+	if !broadcast {
+		level = slog.LevelDebug
+	}
+	log.Log(context.Background(), level, "Action applied", append(actionAttrs(msg), "outcome", "ok")...)
+
+	// Broadcast state update to all seated players, then acknowledge,
+	// so the ack arrives after the state it produced
+	if broadcast {
+		gs.broadcastState()
+	}
+	gs.respond(client, msg, nil)
 }
 
 func (gs *GameServer) handleJoinTable(client *Client, msg ClientMessage) error {
@@ -86,6 +186,20 @@ func (gs *GameServer) handleJoinTable(client *Client, msg ClientMessage) error {
 		return game.ErrInvalidAction
 	}
 	seatIndex := *msg.SeatIndex
+	if seatIndex < 0 || seatIndex > 3 {
+		return game.ErrInvalidSeat
+	}
+
+	// Registered players default to their username
+	if msg.PlayerName == "" && client.Account != "" {
+		msg.PlayerName = client.Account
+	}
+
+	// Switching to the partner's seat keeps the team's chat history
+	joinedTeam := time.Now()
+	if client.SeatIndex >= 0 && gs.State.GetTeamForPlayer(client.SeatIndex) == gs.State.GetTeamForPlayer(seatIndex) {
+		joinedTeam = gs.joinedTeam[client.SeatIndex]
+	}
 
 	// If player is already seated elsewhere, leave that seat first (allows switching seats)
 	if client.SeatIndex >= 0 && client.SeatIndex != seatIndex {
@@ -98,6 +212,7 @@ func (gs *GameServer) handleJoinTable(client *Client, msg ClientMessage) error {
 			return err
 		}
 		gs.Hub.UnseatClient(client)
+		gs.revokeClientToken(client)
 	}
 
 	// Handle mid-game joining (taking over an empty seat)
@@ -112,10 +227,12 @@ func (gs *GameServer) handleJoinTable(client *Client, msg ClientMessage) error {
 		// Take over the seat
 		player.Name = msg.PlayerName
 		player.Connected = true
-		player.SessionToken = game.GenerateSessionToken()
+		player.Account = client.Account
 		gs.Hub.SeatClient(client, seatIndex)
-		client.Token = player.SessionToken
-		log.Printf("Player %s took over seat %d mid-game", msg.PlayerName, seatIndex)
+		gs.joinedTeam[seatIndex] = joinedTeam
+		gs.issueSeatToken(client, seatIndex)
+		gs.sendChatHistory(client)
+		gs.logger(client).Info("Seat taken over mid-game")
 		return nil
 	}
 
@@ -132,9 +249,11 @@ func (gs *GameServer) handleJoinTable(client *Client, msg ClientMessage) error {
 
 	// Seat the client
 	gs.Hub.SeatClient(client, seatIndex)
-	client.Token = gs.State.Players[seatIndex].SessionToken
+	gs.joinedTeam[seatIndex] = joinedTeam
+	gs.issueSeatToken(client, seatIndex)
+	gs.State.Players[seatIndex].Account = client.Account
+	gs.sendChatHistory(client)
 
-	log.Printf("Player %s joined seat %d", msg.PlayerName, seatIndex)
 	return nil
 }
 
@@ -154,12 +273,18 @@ func (gs *GameServer) handleLeaveSeat(client *Client) error {
 	}
 
 	gs.Hub.UnseatClient(client)
+	gs.revokeClientToken(client)
 	return nil
 }
 
 func (gs *GameServer) handleStartGame(client *Client) error {
+	if gs.draining {
+		return ErrShuttingDown
+	}
+
 	action := game.Action{
-		Type: game.ActionStartGame,
+		Type:        game.ActionStartGame,
+		PlayerIndex: client.SeatIndex,
 	}
 
 	_, err := game.ApplyAction(gs.State, action)
@@ -167,7 +292,8 @@ func (gs *GameServer) handleStartGame(client *Client) error {
 		return err
 	}
 
-	log.Printf("Game started")
+	gs.handStartedAt = time.Now()
+	gs.logger(nil).Info("Hand dealt", "dealer", gs.State.Dealer)
 	return nil
 }
 
@@ -192,7 +318,6 @@ func (gs *GameServer) handlePlaceBid(client *Client, msg ClientMessage) error {
 		return err
 	}
 
-	log.Printf("Player %d bid %d", client.SeatIndex, bidAmount)
 	return nil
 }
 
@@ -212,7 +337,6 @@ func (gs *GameServer) handleSelectTrump(client *Client, msg ClientMessage) error
 		return err
 	}
 
-	log.Printf("Player %d selected trump: %s", client.SeatIndex, msg.TrumpSuit)
 	return nil
 }
 
@@ -232,7 +356,6 @@ func (gs *GameServer) handleTakeKitty(client *Client, msg ClientMessage) error {
 		return err
 	}
 
-	log.Printf("Player %d took %d cards from kitty", client.SeatIndex, len(msg.CardIDs))
 	return nil
 }
 
@@ -247,12 +370,12 @@ func (gs *GameServer) handleDiscard(client *Client, msg ClientMessage) error {
 		CardIDs:     msg.CardIDs,
 	}
 
-	_, err := game.ApplyAction(gs.State, action)
+	_, dropped, err := game.ApplyDiscard(gs.State, action)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Player %d (bid winner) discarded %d cards, entering discard phase", client.SeatIndex, len(msg.CardIDs))
+	gs.reportDroppedDiscard(dropped)
 	return nil
 }
 
@@ -267,15 +390,30 @@ func (gs *GameServer) handleDiscardDraw(client *Client, msg ClientMessage) error
 		CardIDs:     msg.CardIDs,
 	}
 
-	_, err := game.ApplyAction(gs.State, action)
+	_, dropped, err := game.ApplyDiscard(gs.State, action)
 	if err != nil {
 		return err
 	}
 
-	log.Printf("Player %d discarded %d cards and drew replacements", client.SeatIndex, len(msg.CardIDs))
+	gs.reportDroppedDiscard(dropped)
 	return nil
 }
 
+// reportDroppedDiscard tells a player whose discard, chosen ahead of their
+// turn, couldn't be made when the turn came (e.g. the deck ran short)
+func (gs *GameServer) reportDroppedDiscard(dropped *game.DroppedDiscard) {
+	if dropped == nil {
+		return
+	}
+	reply := NewActionErrorMessage(dropped.Err)
+	gs.Hub.Metrics.countError(reply.Error.Code)
+	client := gs.Hub.GetClientBySeat(dropped.Seat)
+	gs.logger(client).Info("Pending discard dropped", "seat", dropped.Seat, "outcome", reply.Error.Code)
+	if client != nil {
+		gs.Hub.SendToClient(client, reply)
+	}
+}
+
 func (gs *GameServer) handlePlayCard(client *Client, msg ClientMessage) error {
 	if client.SeatIndex < 0 {
 		return game.ErrInvalidAction
@@ -292,8 +430,6 @@ func (gs *GameServer) handlePlayCard(client *Client, msg ClientMessage) error {
 		return err
 	}
 
-	log.Printf("Player %d played %s", client.SeatIndex, msg.CardID)
-
 	// Check if hand is complete (scoring phase)
 	if gs.State.Phase == game.PhaseScoring {
 		gs.handleScoring()
@@ -306,8 +442,16 @@ func (gs *GameServer) handleScoring() {
 	// Calculate score
 	result := game.CalculateScore(gs.State)
 	game.ApplyScore(gs.State, result)
+	gs.history.record(gs.State, result)
+	var duration time.Duration
+	if !gs.handStartedAt.IsZero() {
+		duration = time.Since(gs.handStartedAt)
+	}
+	gs.Hub.Metrics.observeHand(duration, !result.BidMade)
 
-	log.Printf("Hand complete. Score: Team 0: %d, Team 1: %d", gs.State.Teams[0].Score, gs.State.Teams[1].Score)
+	gs.logger(nil).Info("Hand scored",
+		"bidderTeam", result.BidderTeam, "bid", result.BidAmount, "bidMade", result.BidMade,
+		"team0", gs.State.Teams[0].Score, "team1", gs.State.Teams[1].Score)
 
 	// Send score update
 	scoreMsg := ServerMessage{
@@ -325,8 +469,8 @@ func (gs *GameServer) handleScoring() {
 			WinningTeam: &winningTeam,
 		}
 		gs.Hub.BroadcastMessage(gameOverMsg)
-		log.Printf("Game over! Team %d wins! (Games: %d-%d)", winningTeam,
-			gs.State.Teams[0].GamesWon, gs.State.Teams[1].GamesWon)
+		gs.logger(nil).Info("Game over", "winningTeam", winningTeam,
+			"gamesWon0", gs.State.Teams[0].GamesWon, "gamesWon1", gs.State.Teams[1].GamesWon)
 	}
 }
 
@@ -338,9 +482,14 @@ func (gs *GameServer) handleNewHand(client *Client) error {
 	// If game is over, reset to lobby but preserve games won
 	if gs.State.Phase == game.PhaseFinished {
 		gamesWon := [2]int{gs.State.Teams[0].GamesWon, gs.State.Teams[1].GamesWon}
+		emotesDisabled := gs.State.EmotesDisabled
+		house := gs.State.House
+		players := gs.State.Players
 		gs.State = game.NewGameState(gs.State.TargetScore)
 		gs.State.Teams[0].GamesWon = gamesWon[0]
 		gs.State.Teams[1].GamesWon = gamesWon[1]
+		gs.State.EmotesDisabled = emotesDisabled
+		gs.history.reset()
 		// Re-add all connected players
 		for i := 0; i < 4; i++ {
 			if c := gs.Hub.GetClientBySeat(i); c != nil {
@@ -348,8 +497,20 @@ func (gs *GameServer) handleNewHand(client *Client) error {
 					Name:         "Player " + string(rune('1'+i)),
 					SeatIndex:    i,
 					SessionToken: c.Token,
+					Account:      c.Account,
 					Connected:    true,
 				}
+				if players[i] != nil {
+					gs.State.Players[i].Muted = players[i].Muted
+				}
+			}
+		}
+		// The house stays, and deals the next game; if their seat is empty
+		// the first player seated takes over
+		for i := 0; i < 4 && gs.State.House < 0; i++ {
+			if gs.State.Players[(house+i+4)%4] != nil {
+				gs.State.House = (house + i + 4) % 4
+				gs.State.Dealer = gs.State.House
 			}
 		}
 		return nil
@@ -357,59 +518,118 @@ func (gs *GameServer) handleNewHand(client *Client) error {
 
 	// Start new hand
 	game.StartNewHand(gs.State)
-	log.Printf("New hand started. Dealer: %d", gs.State.Dealer)
+	gs.handStartedAt = time.Now()
+	gs.logger(nil).Info("Hand dealt", "dealer", gs.State.Dealer)
 	return nil
 }
 
 // ErrRejoinFailed is returned when a rejoin attempt fails (stale token)
-var ErrRejoinFailed = errors.New("rejoin_failed")
+var ErrRejoinFailed = game.NewError("rejoin_failed", "could not rejoin: the session has expired")
 
 func (gs *GameServer) handleRejoin(client *Client, msg ClientMessage) error {
-	// Find player by token
-	for i, p := range gs.State.Players {
-		if p != nil && p.SessionToken == msg.Token {
-			// Rejoin successful
-			gs.Hub.SeatClient(client, i)
-			client.Token = msg.Token
-			p.Connected = true
-			log.Printf("Player %s rejoined seat %d", p.Name, i)
-			return nil
+	seatIndex := -1
+
+	// The signed token names its seat; it must still be that seat's current token
+	if msg.Token != "" {
+		st, err := gs.Tokens.Validate(msg.Token)
+		if err != nil {
+			gs.logger(client).Info("Rejoin token rejected", "err", err)
+		} else if st.TableID == gs.TableID && st.Seat >= 0 && st.Seat < 4 {
+			if p := gs.State.Players[st.Seat]; p != nil && p.SessionToken == msg.Token {
+				seatIndex = st.Seat
+			}
 		}
 	}
 
-	return ErrRejoinFailed
+	// Registered players can rejoin their seat from another device
+	if seatIndex < 0 && client.Account != "" {
+		for i, p := range gs.State.Players {
+			if p != nil && p.Account == client.Account {
+				seatIndex = i
+				break
+			}
+		}
+	}
+
+	if seatIndex < 0 {
+		return ErrRejoinFailed
+	}
+
+	// Rejoin successful - rotate the token so the old one can't be replayed
+	p := gs.State.Players[seatIndex]
+	if previous := gs.Hub.GetClientBySeat(seatIndex); previous != nil && previous != client {
+		gs.unseatReplaced(previous)
+	}
+	gs.Hub.SeatClient(client, seatIndex)
+	// Take over the seat's dedupe log so actions retried after reconnecting aren't applied twice
+	if requests := gs.seatRequests[seatIndex]; requests != nil {
+		client.requests = requests
+	}
+	p.Connected = true
+	gs.issueSeatToken(client, seatIndex)
+	gs.sendChatHistory(client)
+	gs.logger(client).Info("Player rejoined")
+	return nil
+}
+
+// unseatReplaced turns a connection whose seat was taken over by a rejoin
+// into a spectator, so it can no longer act for the seat
+func (gs *GameServer) unseatReplaced(client *Client) {
+	gs.logger(client).Info("Seat taken over by another connection")
+	gs.Hub.UnseatClient(client)
+	client.Token = ""
+	client.requests = nil
+	gs.Hub.SendToClient(client, NewNoticeMessage("Your seat was taken over by another connection. You are now watching."))
+}
+
+// issueSeatToken gives a seated client a fresh signed token, revoking the seat's old one
+func (gs *GameServer) issueSeatToken(client *Client, seatIndex int) {
+	player := gs.State.Players[seatIndex]
+	if player.SessionToken != "" {
+		gs.Tokens.Revoke(player.SessionToken)
+	}
+	player.SessionToken = gs.Tokens.Issue(gs.TableID, seatIndex)
+	client.Token = player.SessionToken
+	gs.Hub.SendToClient(client, NewSessionMessage(seatIndex, player.SessionToken))
+}
+
+// revokeClientToken invalidates a client's token after it gives up its seat
+func (gs *GameServer) revokeClientToken(client *Client) {
+	if client.Token != "" {
+		gs.Tokens.Revoke(client.Token)
+		client.Token = ""
+	}
 }
 
 // broadcastState sends personalized state updates to each player
 func (gs *GameServer) broadcastState() {
+	gs.stateSeq++
+
 	// Send to seated players with their hand
 	for i := 0; i < 4; i++ {
 		if client := gs.Hub.GetClientBySeat(i); client != nil {
-			msg := NewStateUpdateMessage(gs.State, i)
-			gs.Hub.SendToClient(client, msg)
+			gs.sendState(client, i, false)
 		}
 	}
 
 	// Send to spectators (no hand info)
-	for client := range gs.Hub.Clients {
+	for _, client := range gs.Hub.ClientList() {
 		if client.SeatIndex < 0 {
-			msg := NewStateUpdateMessage(gs.State, -1)
-			gs.Hub.SendToClient(client, msg)
+			gs.sendState(client, -1, false)
 		}
 	}
 }
 
 // HandleDisconnect handles a client disconnecting
 func (gs *GameServer) HandleDisconnect(client *Client) {
-	gs.mu.Lock()
-	defer gs.mu.Unlock()
-
-	if client.SeatIndex >= 0 && client.SeatIndex < 4 {
+	// Skip if the player has already rejoined on another connection
+	if client.SeatIndex >= 0 && client.SeatIndex < 4 && gs.Hub.GetClientBySeat(client.SeatIndex) == nil {
 		if p := gs.State.Players[client.SeatIndex]; p != nil {
 			p.Connected = false
-			log.Printf("Player %s disconnected from seat %d", p.Name, client.SeatIndex)
+			gs.logger(client).Info("Player disconnected")
 		}
 	}
+	delete(gs.mutes.clients, client.ID)
 
 	gs.broadcastState()
 }
@@ -430,7 +650,6 @@ func (gs *GameServer) handleChangeName(client *Client, msg ClientMessage) error
 		return err
 	}
 
-	log.Printf("Player in seat %d changed name to %s", client.SeatIndex, msg.PlayerName)
 	return nil
 }
 
@@ -449,7 +668,8 @@ func (gs *GameServer) handleResetGame(client *Client) error {
 		return err
 	}
 
-	log.Printf("Game reset by Player 1 (seat 0)")
+	gs.history.reset()
+	gs.logger(client).Info("Game reset")
 	return nil
 }
 
@@ -464,6 +684,12 @@ func (gs *GameServer) handleKickPlayer(client *Client, msg ClientMessage) error
 
 	targetSeat := *msg.SeatIndex
 
+	// Kicking invalidates the player's session, so revoke their token
+	var kickedToken string
+	if targetSeat >= 0 && targetSeat < 4 && gs.State.Players[targetSeat] != nil {
+		kickedToken = gs.State.Players[targetSeat].SessionToken
+	}
+
 	action := game.Action{
 		Type:        game.ActionKickPlayer,
 		PlayerIndex: client.SeatIndex,
@@ -475,12 +701,15 @@ func (gs *GameServer) handleKickPlayer(client *Client, msg ClientMessage) error
 		return err
 	}
 
+	gs.Tokens.Revoke(kickedToken)
+
 	// Disconnect the kicked player's client if they're still connected
 	if kickedClient := gs.Hub.GetClientBySeat(targetSeat); kickedClient != nil {
 		gs.Hub.UnseatClient(kickedClient)
+		kickedClient.Token = ""
 	}
 
-	log.Printf("Player in seat %d was kicked by house", targetSeat)
+	gs.logger(client).Info("Player kicked by house", "targetSeat", targetSeat)
 	return nil
 }
 
@@ -506,7 +735,7 @@ func (gs *GameServer) handleTransferHouse(client *Client, msg ClientMessage) err
 		return err
 	}
 
-	log.Printf("House transferred from seat %d to seat %d", client.SeatIndex, targetSeat)
+	gs.logger(client).Info("House transferred", "targetSeat", targetSeat)
 	gs.broadcastState()
 	return nil
 }
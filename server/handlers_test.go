@@ -0,0 +1,47 @@
+package server
+
+import (
+	"testing"
+
+	"setback/game"
+)
+
+func TestDroppedPendingDiscardReported(t *testing.T) {
+	gs, clients := startedGame(t)
+	pass := 0
+	for gs.State.Phase == game.PhaseBidding {
+		gs.HandleMessage(clients[gs.State.CurrentPlayer], ClientMessage{Type: MsgPlaceBid, Amount: &pass})
+	}
+	winner := gs.State.BidWinner
+	all := func(game.Card) bool { return true }
+	hand := func(seat int) []string { return cardIDs(gs.State.Players[seat].Hand, all) }
+	gs.HandleMessage(clients[winner], ClientMessage{Type: MsgSelectTrump, TrumpSuit: "spades"})
+	gs.HandleMessage(clients[winner], ClientMessage{Type: MsgTakeKitty, CardIDs: cardIDs(gs.State.Kitty, all)})
+	gs.HandleMessage(clients[winner], ClientMessage{Type: MsgDiscard, CardIDs: hand(winner)})
+
+	// The last to draw throws their whole hand ahead of their turn; by then
+	// the deck has only 4 cards left
+	last := (winner + 3) % 4
+	gs.HandleMessage(clients[last], ClientMessage{Type: MsgDiscardDraw, CardIDs: hand(last), RequestID: "early"})
+	if ack := lastOfType(drain(clients[last]), MsgAck); ack == nil || ack.RequestID != "early" {
+		t.Fatalf("Discarding ahead of the turn should be accepted for later, got %+v", ack)
+	}
+	for i := 1; i <= 2; i++ {
+		seat := (winner + i) % 4
+		gs.HandleMessage(clients[seat], ClientMessage{Type: MsgDiscardDraw, CardIDs: hand(seat)})
+	}
+
+	reply := lastOfType(drain(clients[last]), MsgError)
+	if reply == nil || reply.Error.Code != string(game.CodeNotEnoughCards) || reply.Error.Params["remaining"] != float64(4) {
+		t.Fatalf("The last player should be told their discard was dropped, got %+v", reply)
+	}
+	gs.Hub.Metrics.mu.Lock()
+	counted := gs.Hub.Metrics.errors[string(game.CodeNotEnoughCards)]
+	gs.Hub.Metrics.mu.Unlock()
+	if counted != 1 {
+		t.Errorf("The drop should be counted once, got %d", counted)
+	}
+	if gs.State.CurrentPlayer != last || gs.State.Phase != game.PhaseDiscard {
+		t.Errorf("The last player should discard again, phase %s, turn %d", gs.State.Phase, gs.State.CurrentPlayer)
+	}
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+)
+
+// Handshake error codes
+const (
+	ErrCodeHelloRequired        = "hello_required"
+	ErrCodeIncompatibleProtocol = "incompatible_protocol"
+)
+
+// handleHello negotiates the protocol version and features with a client.
+// Returns an error message for the client if it is incompatible.
+func (gs *GameServer) handleHello(client *Client, msg ClientMessage) *ServerMessage {
+	if msg.ProtocolVersion < MinProtocolVersion || msg.ProtocolVersion > ProtocolVersion {
+		client.logger().Info("Rejected client: incompatible protocol", "protocolVersion", msg.ProtocolVersion)
+		errMsg := NewErrorMessage(ErrCodeIncompatibleProtocol, fmt.Sprintf(
+			"Client protocol v%d is not supported (server supports v%d-v%d). Please reload the page.",
+			msg.ProtocolVersion, MinProtocolVersion, ProtocolVersion))
+		return &errMsg
+	}
+
+	// Only features both sides know about are turned on
+	client.ProtocolVersion = msg.ProtocolVersion
+	client.Features = make(map[string]bool)
+	common := make([]string, 0, len(ServerFeatures))
+	for _, want := range msg.Features {
+		for _, have := range ServerFeatures {
+			if want == have && !client.Features[want] {
+				client.Features[want] = true
+				common = append(common, want)
+			}
+		}
+	}
+
+	return &ServerMessage{
+		Type: MsgWelcome,
+		Welcome: &WelcomePayload{
+			ProtocolVersion:    ProtocolVersion,
+			MinProtocolVersion: MinProtocolVersion,
+			Features:           common,
+			RuleVariants:       RuleVariants,
+		},
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHelloNegotiatesFeatures(t *testing.T) {
+	gs, _ := chatTable(t, 0)
+	client := newTestClient(gs.Hub, newFakeConn())
+	register(gs.Hub, client)
+	gs.Hub.Do(func() {
+		gs.HandleMessage(client, ClientMessage{Type: MsgJoinTable, SeatIndex: new(int)})
+		if reply := lastOfType(drain(client), MsgError); reply == nil || reply.Error.Code != ErrCodeHelloRequired {
+			t.Errorf("Expected hello_required before hello, got %+v", reply)
+		}
+		if gs.State.Players[0] != nil {
+			t.Error("Nothing should be applied before hello")
+		}
+
+		gs.HandleMessage(client, ClientMessage{
+			Type:            MsgHello,
+			ProtocolVersion: ProtocolVersion,
+			Features:        []string{"chat", "teleport", FeatureDelta, "chat"},
+		})
+		welcome := lastOfType(drain(client), MsgWelcome)
+		if welcome == nil {
+			t.Fatal("Expected a welcome")
+		}
+		if want := []string{"chat", FeatureDelta}; !reflect.DeepEqual(welcome.Welcome.Features, want) {
+			t.Errorf("Expected features %v, got %v", want, welcome.Welcome.Features)
+		}
+		if welcome.Welcome.ProtocolVersion != ProtocolVersion || welcome.Welcome.MinProtocolVersion != MinProtocolVersion {
+			t.Errorf("Welcome should carry the supported versions, got %+v", welcome.Welcome)
+		}
+		if !client.Features["chat"] || client.Features["teleport"] || client.Features["emotes"] {
+			t.Errorf("Only common features should be on, got %v", client.Features)
+		}
+	})
+}
+
+func TestHelloRejectsUnsupportedVersions(t *testing.T) {
+	gs, _ := chatTable(t, 0)
+	for _, version := range []int{0, MinProtocolVersion - 1, ProtocolVersion + 1} {
+		client := newTestClient(gs.Hub, newFakeConn())
+		gs.Hub.Do(func() {
+			gs.HandleMessage(client, ClientMessage{Type: MsgHello, ProtocolVersion: version})
+			if reply := lastOfType(drain(client), MsgError); reply == nil || reply.Error.Code != ErrCodeIncompatibleProtocol {
+				t.Errorf("v%d: expected incompatible_protocol, got %+v", version, reply)
+			}
+			if client.ProtocolVersion != 0 {
+				t.Errorf("v%d: client should stay un-negotiated", version)
+			}
+		})
+	}
+}
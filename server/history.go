@@ -0,0 +1,82 @@
+package server
+
+import (
+	"setback/game"
+	"time"
+)
+
+// HandRecord is a completed hand. It holds only what everyone at the
+// table saw: no dealt hands, kitty or discards.
+type HandRecord struct {
+	Number      int                   `json:"number"` // 1-based within the match
+	Dealer      int                   `json:"dealer"`
+	Bids        []game.Bid            `json:"bids"`
+	BidWinner   int                   `json:"bidWinner"`
+	WinningBid  int                   `json:"winningBid"`
+	Trump       string                `json:"trump"`
+	Tricks      []game.CompletedTrick `json:"tricks"`
+	Result      game.ScoreResult      `json:"result"`
+	Scores      [2]int                `json:"scores"` // Team scores after this hand
+	CompletedAt time.Time             `json:"completedAt"`
+}
+
+// ScorePoint is the match score after one hand
+type ScorePoint struct {
+	Hand    int    `json:"hand"`
+	Scores  [2]int `json:"scores"`
+	Changes [2]int `json:"changes"`
+}
+
+// matchHistory is the record of the match in progress (or just finished).
+// Must be used on the hub goroutine.
+type matchHistory struct {
+	StartedAt time.Time    `json:"startedAt"`
+	Hands     []HandRecord `json:"hands"`
+}
+
+// record adds a scored hand to the history
+func (h *matchHistory) record(state *game.GameState, result game.ScoreResult) {
+	trump := ""
+	if state.Trump != nil {
+		trump = state.Trump.String()
+	}
+	tricks := make([]game.CompletedTrick, len(state.CompletedTricks))
+	for i, t := range state.CompletedTricks {
+		tricks[i] = game.CompletedTrick{
+			Cards:  append([]game.TrickCard(nil), t.Cards...),
+			Winner: t.Winner,
+		}
+	}
+
+	h.Hands = append(h.Hands, HandRecord{
+		Number:      len(h.Hands) + 1,
+		Dealer:      state.Dealer,
+		Bids:        append([]game.Bid(nil), state.Bids...),
+		BidWinner:   state.BidWinner,
+		WinningBid:  state.WinningBid,
+		Trump:       trump,
+		Tricks:      tricks,
+		Result:      result,
+		Scores:      [2]int{state.Teams[0].Score, state.Teams[1].Score},
+		CompletedAt: time.Now(),
+	})
+}
+
+// scores returns the score after each hand
+func (h *matchHistory) scores() []ScorePoint {
+	points := make([]ScorePoint, 0, len(h.Hands))
+	for _, hand := range h.Hands {
+		points = append(points, ScorePoint{
+			Hand:    hand.Number,
+			Scores:  hand.Scores,
+			Changes: [2]int{hand.Result.Team0Change, hand.Result.Team1Change},
+		})
+	}
+	return points
+}
+
+// reset starts a new match
+func (h *matchHistory) reset() {
+	h.StartedAt = time.Now()
+	h.Hands = nil
+}
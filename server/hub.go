@@ -1,31 +1,139 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// Client represents a connected WebSocket client
+// Conn is the part of *websocket.Conn used by the pumps (fakeable in tests)
+type Conn interface {
+	ReadMessage() (messageType int, p []byte, err error)
+	WriteMessage(messageType int, data []byte) error
+	SetReadLimit(limit int64)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	SetPongHandler(h func(appData string) error)
+	Close() error
+}
+
+// ConnConfig controls WebSocket keepalive, deadlines and size limits
+type ConnConfig struct {
+	WriteWait      time.Duration // Time allowed to write a message
+	PongWait       time.Duration // Time allowed between pongs before the client is considered dead
+	PingPeriod     time.Duration // How often to ping; must be less than PongWait
+	MaxMessageSize int64         // Largest message accepted from a client, in bytes
+	MaxLag         time.Duration // How long a client may lag behind before it's cut off (0 = forever)
+}
+
+// DefaultConnConfig returns the standard connection settings
+func DefaultConnConfig() ConnConfig {
+	return ConnConfig{
+		WriteWait:      10 * time.Second,
+		PongWait:       60 * time.Second,
+		PingPeriod:     54 * time.Second,
+		MaxMessageSize: 4096,
+		MaxLag:         15 * time.Second,
+	}
+}
+
+// Client represents a connected WebSocket client. Apart from the
+// connection settings set before it is registered, its fields belong to
+// the hub goroutine.
 type Client struct {
+	ID        uint64 // Stable for the connection; assigned by NewClient or on register
 	Hub       *Hub
-	Conn      *websocket.Conn
+	Conn      Conn
 	Send      chan []byte
 	SeatIndex int // -1 if not seated
 	Token     string
+	Account   string // Registered username, "" for guests
+	IP        string // Remote IP, for per-IP limits and logging
+	// ConnectedAt is when the hub registered the client
+	ConnectedAt time.Time
+	// Set by the hello handshake; ProtocolVersion is 0 until then
+	ProtocolVersion int
+	Features        map[string]bool
+	// Last state sent, for building deltas
+	lastView  stateView
+	lastSeq   uint64
+	lastEmote time.Time // For emote throttling
+	// Replies to recent requestIds, for deduping retries
+	requests *requestLog
+	// closed is set when the hub closes Send or cuts the client off;
+	// nothing may be sent after that
+	closed bool
+	// A client whose Send buffer filled is lagging until WritePump catches up
+	lagging  bool
+	lagSince time.Time
+	// pending is the latest stateUpdate for a lagging client, written by
+	// WritePump once Send is empty. The hub replaces it rather than queue
+	// another. Shared with WritePump.
+	pending atomic.Pointer[[]byte]
+	wake    chan struct{} // Tells WritePump a state is pending
+}
+
+// SendBufferSize is how many messages may queue for a client's WritePump
+const SendBufferSize = 256
+
+// nextClientID numbers connections across all hubs
+var nextClientID atomic.Uint64
+
+// NewClient creates a client for a new connection. Start its pumps after
+// sending it to Register.
+func NewClient(hub *Hub, conn Conn, ip, account string) *Client {
+	return &Client{
+		ID:        nextClientID.Add(1),
+		Hub:       hub,
+		Conn:      conn,
+		Send:      make(chan []byte, SendBufferSize),
+		SeatIndex: -1,
+		Account:   account,
+		IP:        ip,
+		wake:      make(chan struct{}, 1),
+	}
 }
 
-// Hub manages all WebSocket connections and game state
+// Hub owns a table's connections. A single goroutine, Run, owns every
+// client, the seats, and the game server's state: it registers and
+// unregisters clients, hands each incoming message to OnMessage, and runs
+// the functions passed to Do, one at a time. Nothing is shared, so nothing
+// is locked.
+//
+// Hub methods must be called on that goroutine (from OnMessage,
+// OnDisconnect or a function passed to Do) unless they say otherwise.
 type Hub struct {
-	Clients    map[*Client]bool
-	Seats      [4]*Client // Clients by seat index
-	Broadcast  chan []byte
 	Register   chan *Client
 	Unregister chan *Client
 	Incoming   chan *ClientMessageWithSender
-	mu         sync.RWMutex
+	ConnConfig ConnConfig
+	RateLimits RateLimitConfig
+	// MaxConnsPerIP caps simultaneous connections from one IP (0 = no cap)
+	MaxConnsPerIP int
+	// Metrics counts dropped sends here and game events from the game server
+	Metrics *Metrics
+	// OnMessage handles each incoming message on the hub goroutine.
+	// Without it, Run leaves Incoming alone.
+	OnMessage func(*Client, ClientMessage)
+	// OnDisconnect is called on the hub goroutine after a client is unregistered
+	OnDisconnect func(*Client)
+
+	calls   chan func()
+	started atomic.Bool
+	early   sync.Mutex // Serializes Do calls made before Run starts
+	pumps   pumps      // Connections still being read or written, for shutdown
+
+	// Owned by the hub goroutine
+	clients map[*Client]bool
+	seats   [4]*Client      // Clients by seat index
+	ipConns map[string]int  // Connections per IP
+	banned  map[string]bool // IPs refused by the admin
 }
 
 // ClientMessageWithSender pairs a message with its sender
@@ -37,71 +145,295 @@ type ClientMessageWithSender struct {
 // NewHub creates a new Hub
 func NewHub() *Hub {
 	return &Hub{
-		Clients:    make(map[*Client]bool),
-		Seats:      [4]*Client{},
-		Broadcast:  make(chan []byte),
-		Register:   make(chan *Client),
-		Unregister: make(chan *Client),
-		Incoming:   make(chan *ClientMessageWithSender, 256),
+		Register:      make(chan *Client),
+		Unregister:    make(chan *Client),
+		Incoming:      make(chan *ClientMessageWithSender, 256),
+		ConnConfig:    DefaultConnConfig(),
+		RateLimits:    DefaultRateLimitConfig(),
+		MaxConnsPerIP: 8,
+		Metrics:       NewMetrics(),
+		calls:         make(chan func()),
+		pumps:         pumps{running: make(map[*Client]int), stopped: make(chan struct{})},
+		clients:       make(map[*Client]bool),
+		ipConns:       make(map[string]int),
+		banned:        make(map[string]bool),
 	}
 }
 
-// Run starts the hub's main loop
+// Run is the hub goroutine. It never returns.
 func (h *Hub) Run() {
+	h.early.Lock()
+	h.started.Store(true)
+	h.early.Unlock()
+	incoming := h.Incoming
+	if h.OnMessage == nil {
+		incoming = nil
+	}
 	for {
 		select {
 		case client := <-h.Register:
-			h.mu.Lock()
-			h.Clients[client] = true
-			h.mu.Unlock()
+			h.register(client)
 
 		case client := <-h.Unregister:
-			h.mu.Lock()
-			if _, ok := h.Clients[client]; ok {
-				delete(h.Clients, client)
-				close(client.Send)
-				if client.SeatIndex >= 0 && client.SeatIndex < 4 {
-					h.Seats[client.SeatIndex] = nil
-				}
-			}
-			h.mu.Unlock()
-
-		case message := <-h.Broadcast:
-			h.mu.RLock()
-			for client := range h.Clients {
-				select {
-				case client.Send <- message:
-				default:
-					close(client.Send)
-					delete(h.Clients, client)
-				}
+			h.Disconnect(client)
+
+		case msg := <-incoming:
+			// Messages read before the client went away are dropped with it
+			if !msg.Client.closed {
+				h.OnMessage(msg.Client, msg.Message)
 			}
-			h.mu.RUnlock()
+
+		case fn := <-h.calls:
+			fn()
+		}
+	}
+}
+
+// Do runs fn on the hub goroutine and waits for it to finish. It may be
+// called from any goroutine but the hub's own. Until Run has started
+// there's no hub goroutine to run fn, so it runs directly, one call at a time.
+func (h *Hub) Do(fn func()) {
+	h.DoContext(context.Background(), fn)
+}
+
+// DoContext is Do, giving up if ctx is done before fn has run
+func (h *Hub) DoContext(ctx context.Context, fn func()) error {
+	if !h.started.Load() {
+		h.early.Lock()
+		if !h.started.Load() {
+			defer h.early.Unlock()
+			fn()
+			return nil
+		}
+		h.early.Unlock()
+	}
+	done := make(chan struct{})
+	call := func() {
+		defer close(done)
+		fn()
+	}
+	select {
+	case h.calls <- call:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// register adds a client
+func (h *Hub) register(client *Client) {
+	if client.ID == 0 {
+		client.ID = nextClientID.Add(1)
+	}
+	client.ConnectedAt = time.Now()
+	h.clients[client] = true
+}
+
+// Disconnect unregisters a client and closes its send channel. Messages
+// already queued for it are still written before the connection is closed.
+func (h *Hub) Disconnect(client *Client) {
+	if !h.clients[client] {
+		return
+	}
+	delete(h.clients, client)
+	close(client.Send)
+	client.closed = true
+	h.releaseIP(client.IP)
+	// Only clear the seat if another client hasn't rejoined it
+	if client.SeatIndex >= 0 && client.SeatIndex < 4 && h.seats[client.SeatIndex] == client {
+		h.seats[client.SeatIndex] = nil
+	}
+	if h.OnDisconnect != nil {
+		h.OnDisconnect(client)
+	}
+}
+
+// AcquireIP reserves a connection slot for an IP, or reports false
+// if the IP already has MaxConnsPerIP connections. The slot is released
+// when the client is unregistered (or by ReleaseIP if it never registers).
+// Safe to call from any goroutine but the hub's.
+func (h *Hub) AcquireIP(ip string) bool {
+	ok := false
+	h.Do(func() {
+		if h.MaxConnsPerIP > 0 && h.ipConns[ip] >= h.MaxConnsPerIP {
+			return
+		}
+		h.ipConns[ip]++
+		ok = true
+	})
+	return ok
+}
+
+// ReleaseIP frees a slot taken by AcquireIP.
+// Safe to call from any goroutine but the hub's.
+func (h *Hub) ReleaseIP(ip string) {
+	h.Do(func() { h.releaseIP(ip) })
+}
+
+// releaseIP frees a connection slot
+func (h *Hub) releaseIP(ip string) {
+	if h.ipConns[ip] <= 1 {
+		delete(h.ipConns, ip)
+	} else {
+		h.ipConns[ip]--
+	}
+}
+
+// IsBanned reports whether an IP has been banned by the admin.
+// Safe to call from any goroutine but the hub's.
+func (h *Hub) IsBanned(ip string) bool {
+	banned := false
+	h.Do(func() { banned = h.banned[ip] })
+	return banned
+}
+
+// Ban refuses new connections from an IP and returns its current clients,
+// which the caller should disconnect
+func (h *Hub) Ban(ip string) []*Client {
+	h.banned[ip] = true
+	var clients []*Client
+	for client := range h.clients {
+		if client.IP == ip {
+			clients = append(clients, client)
 		}
 	}
+	return clients
+}
+
+// Unban lifts a ban, reporting false if the IP wasn't banned.
+// Safe to call from any goroutine but the hub's.
+func (h *Hub) Unban(ip string) bool {
+	found := false
+	h.Do(func() {
+		found = h.banned[ip]
+		delete(h.banned, ip)
+	})
+	return found
+}
+
+// Bans returns the banned IPs, sorted.
+// Safe to call from any goroutine but the hub's.
+func (h *Hub) Bans() []string {
+	var ips []string
+	h.Do(func() {
+		ips = make([]string, 0, len(h.banned))
+		for ip := range h.banned {
+			ips = append(ips, ip)
+		}
+	})
+	sort.Strings(ips)
+	return ips
 }
 
 // SendToClient sends a message to a specific client
 func (h *Hub) SendToClient(client *Client, msg ServerMessage) {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
+		client.logger().Error("Marshaling message failed", "type", msg.Type, "err", err)
+		return
+	}
+	h.SendRaw(client, data)
+}
+
+// SendRaw sends an already-encoded message to a specific client.
+// Clients the hub has let go are skipped. Nothing but state may be dropped,
+// so a client that is lagging (see queueState), or whose buffer is full,
+// is cut off instead: it reconnects and is sent everything afresh.
+func (h *Hub) SendRaw(client *Client, data []byte) {
+	if !h.keepingUp(client) {
+		return
+	}
+	if client.lagging {
+		// Queued now, this would be written before the pending state it follows
+		h.Metrics.countDrop()
+		h.cutOff(client, "Disconnecting lagging client: a message can't wait for it")
 		return
 	}
 	select {
 	case client.Send <- data:
 	default:
-		log.Printf("Client send buffer full")
+		h.Metrics.countDrop()
+		h.cutOff(client, "Disconnecting client: send buffer full")
 	}
 }
 
+// queueState sends a client its state as msg, a stateDelta or the full
+// stateUpdate. A client that can't keep up gets the full stateUpdate
+// instead, coalesced: it replaces any earlier one WritePump hasn't written
+// yet, so the client skips straight to the latest state when it catches up.
+func (h *Hub) queueState(client *Client, msg, full []byte) {
+	if !h.keepingUp(client) {
+		return
+	}
+	if !client.lagging {
+		select {
+		case client.Send <- msg:
+			return
+		default:
+			h.startLagging(client)
+		}
+	}
+	if client.pending.Swap(&full) != nil {
+		h.Metrics.countCoalesced()
+	}
+	select {
+	case client.wake <- struct{}{}:
+	default: // Already woken
+	}
+}
+
+// startLagging marks a client whose Send buffer is full
+func (h *Hub) startLagging(client *Client) {
+	if client.lagging {
+		return
+	}
+	client.lagging = true
+	client.lagSince = time.Now()
+	client.logger().Warn("Client send buffer full; coalescing state updates")
+}
+
+// keepingUp checks on a lagging client before anything is sent to it. It
+// stops lagging once WritePump has written its pending state and most of
+// its backlog. If it lags for longer than ConnConfig.MaxLag its connection
+// is closed, so it can reconnect and start afresh, and keepingUp reports false.
+func (h *Hub) keepingUp(client *Client) bool {
+	if client.closed {
+		return false
+	}
+	if !client.lagging {
+		return true
+	}
+	lagged := time.Since(client.lagSince)
+	if client.pending.Load() == nil && len(client.Send) <= cap(client.Send)/4 {
+		client.lagging = false
+		client.logger().Info("Client caught up", "lagged", lagged)
+		return true
+	}
+	if max := h.ConnConfig.MaxLag; max > 0 && lagged > max {
+		h.Metrics.countSlowDisconnect()
+		h.cutOff(client, "Disconnecting slow client", "lagged", lagged)
+		return false
+	}
+	return true
+}
+
+// cutOff closes a client's connection so it can reconnect and start afresh.
+// ReadPump unregisters it once the connection is closed. Disconnecting
+// here could broadcast to everyone in the middle of another broadcast.
+func (h *Hub) cutOff(client *Client, reason string, attrs ...any) {
+	client.logger().Warn(reason, append(attrs, "queued", len(client.Send))...)
+	client.closed = true
+	client.Conn.Close()
+}
+
 // SendToSeat sends a message to the client at a specific seat
 func (h *Hub) SendToSeat(seatIndex int, msg ServerMessage) {
-	h.mu.RLock()
-	client := h.Seats[seatIndex]
-	h.mu.RUnlock()
-
-	if client != nil {
+	if client := h.GetClientBySeat(seatIndex); client != nil {
 		h.SendToClient(client, msg)
 	}
 }
@@ -110,54 +442,86 @@ func (h *Hub) SendToSeat(seatIndex int, msg ServerMessage) {
 func (h *Hub) BroadcastMessage(msg ServerMessage) {
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling broadcast: %v", err)
+		slog.Error("Marshaling broadcast failed", "type", msg.Type, "err", err)
 		return
 	}
-	h.Broadcast <- data
+	for client := range h.clients {
+		h.SendRaw(client, data)
+	}
+}
+
+// BroadcastTo sends a message to every client matched by the filter
+func (h *Hub) BroadcastTo(msg ServerMessage, filter func(*Client) bool) {
+	for client := range h.clients {
+		if filter(client) {
+			h.SendToClient(client, msg)
+		}
+	}
+}
+
+// AllowEmote reports whether a client may send an emote now,
+// and starts its cooldown if so
+func (h *Hub) AllowEmote(client *Client) bool {
+	now := time.Now()
+	if now.Sub(client.lastEmote) < EmoteCooldown {
+		return false
+	}
+	client.lastEmote = now
+	return true
 }
 
 // SeatClient assigns a client to a seat
 func (h *Hub) SeatClient(client *Client, seatIndex int) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
 	// Remove from old seat if any
-	if client.SeatIndex >= 0 && client.SeatIndex < 4 {
-		h.Seats[client.SeatIndex] = nil
+	if client.SeatIndex >= 0 && client.SeatIndex < 4 && h.seats[client.SeatIndex] == client {
+		h.seats[client.SeatIndex] = nil
 	}
 
 	client.SeatIndex = seatIndex
 	if seatIndex >= 0 && seatIndex < 4 {
-		h.Seats[seatIndex] = client
+		h.seats[seatIndex] = client
 	}
 }
 
 // UnseatClient removes a client from their seat
 func (h *Hub) UnseatClient(client *Client) {
-	h.mu.Lock()
-	defer h.mu.Unlock()
-
-	if client.SeatIndex >= 0 && client.SeatIndex < 4 {
-		h.Seats[client.SeatIndex] = nil
+	if client.SeatIndex >= 0 && client.SeatIndex < 4 && h.seats[client.SeatIndex] == client {
+		h.seats[client.SeatIndex] = nil
 	}
 	client.SeatIndex = -1
 }
 
+// ClientList returns all connected clients, oldest first
+func (h *Hub) ClientList() []*Client {
+	clients := make([]*Client, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].ID < clients[j].ID })
+	return clients
+}
+
+// GetClientByID finds a connected client by its ID
+func (h *Hub) GetClientByID(id uint64) *Client {
+	for client := range h.clients {
+		if client.ID == id {
+			return client
+		}
+	}
+	return nil
+}
+
 // GetClientBySeat returns the client at a seat
 func (h *Hub) GetClientBySeat(seatIndex int) *Client {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
 	if seatIndex >= 0 && seatIndex < 4 {
-		return h.Seats[seatIndex]
+		return h.seats[seatIndex]
 	}
 	return nil
 }
 
 // GetClientByToken finds a client by session token
 func (h *Hub) GetClientByToken(token string) *Client {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
-	for client := range h.Clients {
+	for client := range h.clients {
 		if client.Token == token {
 			return client
 		}
@@ -165,25 +529,55 @@ func (h *Hub) GetClientByToken(token string) *Client {
 	return nil
 }
 
-// ReadPump pumps messages from the websocket connection to the hub
+// ReadPump pumps messages from the websocket connection to the hub.
+// A client that sends nothing (not even a pong) within PongWait is dropped.
 func (c *Client) ReadPump() {
 	defer func() {
 		c.Hub.Unregister <- c
 		c.Conn.Close()
 	}()
 
+	cfg := c.Hub.ConnConfig
+	limiter := newRateLimiter(c.Hub.RateLimits)
+	c.Conn.SetReadLimit(cfg.MaxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(cfg.PongWait))
+		return nil
+	})
+
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				c.logger().Info("WebSocket closed unexpectedly", "err", err)
 			}
 			break
 		}
+		now := time.Now()
+		c.Conn.SetReadDeadline(now.Add(cfg.PongWait))
+
+		if !limiter.allowAny(now) {
+			if c.rejectMessage(limiter, "", now) {
+				break
+			}
+			continue
+		}
 
 		var clientMsg ClientMessage
 		if err := json.Unmarshal(message, &clientMsg); err != nil {
-			log.Printf("Error parsing message: %v", err)
+			c.logger().Info("Malformed message", "err", err)
+			if limiter.strike(now) {
+				c.logger().Warn("Disconnecting client: too many bad messages")
+				break
+			}
+			continue
+		}
+
+		if !limiter.allowType(clientMsg.Type, now) {
+			if c.rejectMessage(limiter, clientMsg.Type, now) {
+				break
+			}
 			continue
 		}
 
@@ -194,21 +588,129 @@ func (c *Client) ReadPump() {
 	}
 }
 
+// rejectMessage tells the client it is sending too fast and records a strike.
+// Returns true if the client has used up its strikes and should be disconnected.
+func (c *Client) rejectMessage(limiter *rateLimiter, msgType MessageType, now time.Time) bool {
+	c.logger().Info("Rate limited", "action", msgType)
+	if limiter.strike(now) {
+		c.logger().Warn("Disconnecting client: rate limit exceeded repeatedly")
+		return true
+	}
+	c.Hub.Do(func() {
+		c.Hub.SendToClient(c, NewErrorMessage(ErrCodeRateLimited, "Too many messages - slow down"))
+	})
+	return false
+}
+
+// Start runs the client's ReadPump and WritePump, each on its own
+// goroutine. They are tracked from the start, so a shutdown that begins
+// straight after still waits for them.
+func (c *Client) Start() {
+	c.Hub.pumps.start(c, 2)
+	go func() {
+		defer c.Hub.pumps.stop(c)
+		c.WritePump()
+	}()
+	go func() {
+		defer c.Hub.pumps.stop(c)
+		c.ReadPump()
+	}()
+}
+
 // WritePump pumps messages from the hub to the websocket connection
+// and pings the client every PingPeriod to keep the connection alive
 func (c *Client) WritePump() {
+	cfg := c.Hub.ConnConfig
+	ticker := time.NewTicker(cfg.PingPeriod)
 	defer func() {
+		ticker.Stop()
 		c.Conn.Close()
 	}()
 
 	for {
-		message, ok := <-c.Send
-		if !ok {
-			c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-			return
+		select {
+		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if !ok {
+				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
+
+		case <-c.wake:
+
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
 
-		if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
-			return
+		// A coalesced state goes out after everything queued before it
+		if len(c.Send) == 0 {
+			if state := c.pending.Swap(nil); state != nil {
+				c.Conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait))
+				if err := c.Conn.WriteMessage(websocket.TextMessage, *state); err != nil {
+					return
+				}
+			}
 		}
 	}
 }
+
+// pumps tracks the clients whose pumps, run by Start, are still running,
+// so a shutdown can wait for their queued messages to be written
+type pumps struct {
+	mu      sync.Mutex
+	running map[*Client]int // Running pumps per client
+	stopped chan struct{}   // Closed and replaced whenever a pump stops
+}
+
+// start records n pumps about to start for a client
+func (p *pumps) start(c *Client, n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running[c] += n
+}
+
+// stop records a pump for a client returning
+func (p *pumps) stop(c *Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.running[c]--; p.running[c] <= 0 {
+		delete(p.running, c)
+	}
+	close(p.stopped)
+	p.stopped = make(chan struct{})
+}
+
+// wait waits until no pump is running, or until ctx is done
+func (p *pumps) wait(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		n, stopped := len(p.running), p.stopped
+		p.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// closeAll closes the connection of every client with a pump still
+// running, which makes its pumps return. It reports how many there were.
+func (p *pumps) closeAll() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for c := range p.running {
+		c.Conn.Close()
+	}
+	return len(p.running)
+}
@@ -0,0 +1,481 @@
+package server
+
+import (
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// errTimeout mimics the net.Error a real connection returns past its read deadline
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "i/o timeout" }
+func (errTimeout) Timeout() bool   { return true }
+func (errTimeout) Temporary() bool { return true }
+
+var _ net.Error = errTimeout{}
+
+// fakeConn is an in-memory Conn that honors read deadlines and read limits
+type fakeConn struct {
+	incoming chan []byte
+	done     chan struct{} // Closed by Close, waking a blocked read
+
+	mu           sync.Mutex
+	readLimit    int64
+	readDeadline time.Time
+	pongHandler  func(string) error
+	written      []int    // Message types written
+	texts        [][]byte // Text messages written
+	closed       bool
+}
+
+func newFakeConn() *fakeConn {
+	return &fakeConn{incoming: make(chan []byte, 16), done: make(chan struct{})}
+}
+
+func (f *fakeConn) ReadMessage() (int, []byte, error) {
+	for {
+		f.mu.Lock()
+		deadline := f.readDeadline
+		closed := f.closed
+		f.mu.Unlock()
+
+		if closed {
+			return 0, nil, net.ErrClosed
+		}
+
+		wait := time.Until(deadline)
+		if deadline.IsZero() {
+			wait = time.Hour
+		}
+		if wait <= 0 {
+			return 0, nil, errTimeout{}
+		}
+
+		select {
+		case msg := <-f.incoming:
+			f.mu.Lock()
+			limit := f.readLimit
+			f.mu.Unlock()
+			if limit > 0 && int64(len(msg)) > limit {
+				return 0, nil, websocket.ErrReadLimit
+			}
+			return websocket.TextMessage, msg, nil
+		case <-f.done:
+		case <-time.After(wait):
+			// Loop to re-check: a pong may have pushed the deadline out
+		}
+	}
+}
+
+func (f *fakeConn) WriteMessage(messageType int, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return net.ErrClosed
+	}
+	f.written = append(f.written, messageType)
+	if messageType == websocket.TextMessage {
+		f.texts = append(f.texts, data)
+	}
+	return nil
+}
+
+func (f *fakeConn) SetReadLimit(limit int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readLimit = limit
+}
+
+func (f *fakeConn) SetReadDeadline(t time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.readDeadline = t
+	return nil
+}
+
+func (f *fakeConn) SetWriteDeadline(t time.Time) error { return nil }
+
+func (f *fakeConn) SetPongHandler(h func(string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.pongHandler = h
+}
+
+func (f *fakeConn) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.closed {
+		f.closed = true
+		close(f.done)
+	}
+	return nil
+}
+
+// pong simulates the client answering a ping
+func (f *fakeConn) pong() {
+	f.mu.Lock()
+	h := f.pongHandler
+	f.mu.Unlock()
+	if h != nil {
+		h("")
+	}
+}
+
+func (f *fakeConn) countWritten(messageType int) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, t := range f.written {
+		if t == messageType {
+			n++
+		}
+	}
+	return n
+}
+
+// sent decodes the text messages written so far
+func (f *fakeConn) sent() []ServerMessage {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	msgs := make([]ServerMessage, len(f.texts))
+	for i, data := range f.texts {
+		json.Unmarshal(data, &msgs[i])
+	}
+	return msgs
+}
+
+func testConnConfig() ConnConfig {
+	return ConnConfig{
+		WriteWait:      50 * time.Millisecond,
+		PongWait:       60 * time.Millisecond,
+		PingPeriod:     20 * time.Millisecond,
+		MaxMessageSize: 64,
+	}
+}
+
+func newTestClient(hub *Hub, conn Conn) *Client {
+	return &Client{
+		Hub:       hub,
+		Conn:      conn,
+		Send:      make(chan []byte, 256),
+		SeatIndex: -1,
+		wake:      make(chan struct{}, 1),
+	}
+}
+
+// register adds clients to a running hub and waits until it has them
+func register(hub *Hub, clients ...*Client) {
+	for _, c := range clients {
+		hub.Register <- c
+	}
+	hub.Do(func() {})
+}
+
+// waitUnregister waits for ReadPump to hand the client back to the hub
+func waitUnregister(t *testing.T, hub *Hub, within time.Duration) *Client {
+	t.Helper()
+	select {
+	case c := <-hub.Unregister:
+		return c
+	case <-time.After(within):
+		t.Fatalf("client was not unregistered within %v", within)
+		return nil
+	}
+}
+
+func TestReadPumpDropsSilentClient(t *testing.T) {
+	hub := NewHub()
+	hub.ConnConfig = testConnConfig()
+	conn := newFakeConn()
+	client := newTestClient(hub, conn)
+
+	start := time.Now()
+	go client.ReadPump()
+
+	if c := waitUnregister(t, hub, time.Second); c != client {
+		t.Fatalf("Expected the silent client to be unregistered")
+	}
+	if elapsed := time.Since(start); elapsed < hub.ConnConfig.PongWait {
+		t.Errorf("Client dropped after %v, before PongWait %v", elapsed, hub.ConnConfig.PongWait)
+	}
+}
+
+func TestPongKeepsClientAlive(t *testing.T) {
+	hub := NewHub()
+	hub.ConnConfig = testConnConfig()
+	conn := newFakeConn()
+	client := newTestClient(hub, conn)
+
+	go client.ReadPump()
+
+	// Answer pings for well over PongWait
+	stop := time.After(4 * hub.ConnConfig.PongWait)
+	ticker := time.NewTicker(hub.ConnConfig.PingPeriod)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-hub.Unregister:
+			t.Fatal("Client answering pongs was dropped")
+		case <-ticker.C:
+			conn.pong()
+		case <-stop:
+			break loop
+		}
+	}
+
+	// Once pongs stop, the client is dropped
+	waitUnregister(t, hub, time.Second)
+}
+
+func TestReadPumpEnforcesReadLimit(t *testing.T) {
+	hub := NewHub()
+	hub.ConnConfig = testConnConfig()
+	conn := newFakeConn()
+	client := newTestClient(hub, conn)
+
+	go client.ReadPump()
+
+	// A message within the limit is delivered
+	conn.incoming <- []byte(`{"type":"startGame"}`)
+	select {
+	case msg := <-hub.Incoming:
+		if msg.Message.Type != MsgStartGame {
+			t.Errorf("Expected startGame, got %s", msg.Message.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Message within limit was not delivered")
+	}
+
+	// An oversized message disconnects the client
+	big := make([]byte, hub.ConnConfig.MaxMessageSize+1)
+	conn.incoming <- big
+	waitUnregister(t, hub, time.Second)
+
+	conn.mu.Lock()
+	limit := conn.readLimit
+	conn.mu.Unlock()
+	if limit != hub.ConnConfig.MaxMessageSize {
+		t.Errorf("Expected read limit %d, got %d", hub.ConnConfig.MaxMessageSize, limit)
+	}
+}
+
+func TestWritePumpSendsPings(t *testing.T) {
+	hub := NewHub()
+	hub.ConnConfig = testConnConfig()
+	conn := newFakeConn()
+	client := newTestClient(hub, conn)
+
+	done := make(chan struct{})
+	go func() {
+		client.WritePump()
+		close(done)
+	}()
+
+	client.Send <- []byte(`{"type":"stateUpdate"}`)
+	time.Sleep(5 * hub.ConnConfig.PingPeriod)
+
+	if n := conn.countWritten(websocket.PingMessage); n < 2 {
+		t.Errorf("Expected at least 2 pings, got %d", n)
+	}
+	if n := conn.countWritten(websocket.TextMessage); n != 1 {
+		t.Errorf("Expected 1 text message, got %d", n)
+	}
+
+	// Closing Send sends a close frame and stops the pump
+	close(client.Send)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WritePump did not stop after Send was closed")
+	}
+	if n := conn.countWritten(websocket.CloseMessage); n != 1 {
+		t.Errorf("Expected a close message, got %d", n)
+	}
+}
+
+func TestWritePumpStopsOnWriteError(t *testing.T) {
+	hub := NewHub()
+	hub.ConnConfig = testConnConfig()
+	conn := newFakeConn()
+	client := newTestClient(hub, conn)
+
+	done := make(chan struct{})
+	go func() {
+		client.WritePump()
+		close(done)
+	}()
+
+	// A dead connection fails the next ping write
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("WritePump kept running on a dead connection")
+	}
+}
+
+func TestUnregisterNotifiesDisconnect(t *testing.T) {
+	hub := NewHub()
+	disconnected := make(chan *Client, 1)
+	hub.OnDisconnect = func(c *Client) { disconnected <- c }
+	go hub.Run()
+
+	client := newTestClient(hub, newFakeConn())
+	hub.Register <- client
+	hub.SeatClient(client, 2)
+	hub.Unregister <- client
+
+	select {
+	case c := <-disconnected:
+		if c != client {
+			t.Error("OnDisconnect called with the wrong client")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect was not called")
+	}
+	if hub.GetClientBySeat(2) != nil {
+		t.Error("Seat should be cleared after disconnect")
+	}
+	if _, ok := <-client.Send; ok {
+		t.Error("Send channel should be closed")
+	}
+}
+
+func TestLaggingClientGetsLatestState(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	go gs.Run()
+	conn := newFakeConn()
+	client := newTestClient(gs.Hub, conn)
+	client.Send = make(chan []byte, 2)
+	register(gs.Hub, client)
+	gs.Hub.Do(func() {
+		gs.HandleMessage(client, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion, Features: []string{FeatureDelta}})
+		drain(client)
+	})
+
+	// Nothing reads the client: two updates fill its buffer, and the rest
+	// collapse into the latest one
+	for score := 22; score <= 26; score++ {
+		gs.SetTargetScore(score)
+	}
+	var seq uint64
+	gs.Hub.Do(func() {
+		seq = gs.stateSeq
+		if !client.lagging || client.pending.Load() == nil || len(client.Send) != 2 {
+			t.Errorf("Lagging %v, pending %v, %d queued", client.lagging, client.pending.Load() != nil, len(client.Send))
+		}
+	})
+	if n := gs.Hub.Metrics.coalesced; n != 2 {
+		t.Errorf("Expected 2 coalesced updates, got %d", n)
+	}
+
+	// Once it reads again it gets the backlog, then the latest full state
+	go client.WritePump()
+	deadline := time.Now().Add(time.Second)
+	for len(conn.sent()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	msgs := conn.sent()
+	if len(msgs) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(msgs))
+	}
+	last := msgs[2]
+	if last.Type != MsgStateUpdate || last.Seq != seq || last.State.TargetScore != 26 {
+		t.Fatalf("Expected the latest full state, got %s seq %d", last.Type, last.Seq)
+	}
+
+	// Having caught up, it's back on deltas against that state
+	gs.SetTargetScore(27)
+	for len(conn.sent()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	msgs = conn.sent()
+	if len(msgs) != 4 || msgs[3].Type != MsgStateDelta || msgs[3].BaseSeq != seq {
+		t.Fatalf("Expected a delta on seq %d after catching up, got %+v", seq, msgs[len(msgs)-1])
+	}
+	gs.Hub.Do(func() {
+		if client.lagging {
+			t.Error("Client should no longer be lagging")
+		}
+	})
+}
+
+func TestSlowClientDisconnected(t *testing.T) {
+	hub := NewHub()
+	hub.ConnConfig = testConnConfig()
+	hub.ConnConfig.MaxLag = 20 * time.Millisecond
+	go hub.Run()
+	conn := newFakeConn()
+	client := newTestClient(hub, conn)
+	client.Send = make(chan []byte, 1)
+	register(hub, client)
+	go client.ReadPump()
+
+	state := []byte(`{"type":"stateUpdate"}`)
+	hub.Do(func() {
+		hub.queueState(client, state, state)
+		hub.queueState(client, state, state) // Doesn't fit; the client starts lagging
+	})
+	time.Sleep(2 * hub.ConnConfig.MaxLag)
+	hub.Do(func() { hub.queueState(client, state, state) })
+
+	conn.mu.Lock()
+	closed := conn.closed
+	conn.mu.Unlock()
+	if !closed {
+		t.Fatal("A client lagging past MaxLag should have its connection closed")
+	}
+	waitClosed(t, client)
+	hub.Do(func() {
+		if n := len(hub.ClientList()); n != 0 {
+			t.Errorf("%d clients left after the slow client was cut off", n)
+		}
+		if hub.Metrics.sendDrops != 0 || hub.Metrics.slowCuts != 1 {
+			t.Errorf("Expected no drops and 1 slow disconnect, got %d and %d", hub.Metrics.sendDrops, hub.Metrics.slowCuts)
+		}
+	})
+}
+
+func TestMessagesAreNeverDropped(t *testing.T) {
+	state := []byte(`{"type":"stateUpdate"}`)
+	notice := NewNoticeMessage("hi")
+	for name, fill := range map[string]func(*Hub, *Client){
+		// A message that doesn't fit
+		"full buffer": func(hub *Hub, client *Client) {
+			hub.SendToClient(client, notice)
+		},
+		// Anything but state while the client lags: it would be written
+		// ahead of the pending state
+		"lagging": func(hub *Hub, client *Client) {
+			hub.queueState(client, state, state)
+			if !client.lagging {
+				t.Fatal("Client should be lagging")
+			}
+		},
+	} {
+		hub := NewHub()
+		go hub.Run()
+		conn := newFakeConn()
+		client := newTestClient(hub, conn)
+		client.Send = make(chan []byte, 1)
+		register(hub, client)
+
+		hub.Do(func() {
+			hub.SendToClient(client, notice)
+			fill(hub, client)
+			hub.SendToClient(client, notice)
+			if !client.closed || !conn.closed {
+				t.Errorf("%s: the client should be cut off rather than miss a message", name)
+			}
+			if hub.Metrics.sendDrops != 1 {
+				t.Errorf("%s: expected 1 drop, got %d", name, hub.Metrics.sendDrops)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"setback/game"
+	"strings"
+)
+
+// Log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// NewLogHandler creates a slog handler writing in the given format
+// ("text" or "json") at the given level ("debug", "info", "warn" or "error")
+func NewLogHandler(w io.Writer, format, level string) (slog.Handler, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q (want debug, info, warn or error)", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	switch strings.ToLower(format) {
+	case LogFormatText:
+		return slog.NewTextHandler(w, opts), nil
+	case LogFormatJSON:
+		return slog.NewJSONHandler(w, opts), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (want text or json)", format)
+	}
+}
+
+// logger returns the table's logger, with the hand number once play has
+// started and the client's seat and player name if it has a seat.
+// Must be called on the hub goroutine.
+func (gs *GameServer) logger(client *Client) *slog.Logger {
+	attrs := []any{"table", gs.TableID}
+	if gs.State.Phase != game.PhaseLobby {
+		// Hands are numbered within the match; a scored hand keeps its number until the next deal
+		hand := len(gs.history.Hands)
+		if gs.State.Phase != game.PhaseScoring && gs.State.Phase != game.PhaseFinished {
+			hand++
+		}
+		attrs = append(attrs, "hand", hand)
+	}
+	if client != nil {
+		attrs = append(attrs, "client", client.ID)
+		if seat := client.SeatIndex; seat >= 0 && seat < 4 {
+			attrs = append(attrs, "seat", seat)
+			if p := gs.State.Players[seat]; p != nil {
+				attrs = append(attrs, "name", p.Name)
+			}
+		}
+	}
+	return slog.With(attrs...)
+}
+
+// actionAttrs are the details of a client message worth logging.
+// Chat text and tokens are left out, and cards are only counted (see cardAttrs).
+func actionAttrs(msg ClientMessage) []any {
+	attrs := []any{"action", msg.Type}
+	if msg.SeatIndex != nil {
+		attrs = append(attrs, "targetSeat", *msg.SeatIndex)
+	}
+	if msg.Amount != nil {
+		attrs = append(attrs, "amount", *msg.Amount)
+	}
+	if n := len(msg.CardIDs); msg.CardID != "" || n > 0 {
+		if msg.CardID != "" {
+			n++
+		}
+		attrs = append(attrs, "cardCount", n)
+	}
+	if msg.TrumpSuit != "" {
+		attrs = append(attrs, "trump", msg.TrumpSuit)
+	}
+	if msg.Muted != nil {
+		attrs = append(attrs, "muted", *msg.Muted)
+	}
+	if msg.Enabled != nil {
+		attrs = append(attrs, "enabled", *msg.Enabled)
+	}
+	if msg.RequestID != "" {
+		attrs = append(attrs, "requestId", msg.RequestID)
+	}
+	return attrs
+}
+
+// cardAttrs are the cards a client message names. They give away what is
+// in a player's hand, so they are only logged at debug level.
+func cardAttrs(msg ClientMessage) []any {
+	var attrs []any
+	if msg.CardID != "" {
+		attrs = append(attrs, "card", msg.CardID)
+	}
+	if len(msg.CardIDs) > 0 {
+		attrs = append(attrs, "cards", msg.CardIDs)
+	}
+	return attrs
+}
+
+// logger returns a logger for the connection, for events outside any table.
+// It only uses fields fixed at connect time, so the pumps may use it too.
+func (c *Client) logger() *slog.Logger {
+	return slog.With("client", c.ID, "ip", c.IP)
+}
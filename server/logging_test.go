@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// captureLogs sends the default logger's JSON output to a buffer for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	handler, err := NewLogHandler(&buf, LogFormatJSON, "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := slog.Default()
+	slog.SetDefault(slog.New(handler))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+	return &buf
+}
+
+// logEntries parses JSON log lines, keeping those with the given message
+func logEntries(t *testing.T, buf *bytes.Buffer, msg string) []map[string]any {
+	t.Helper()
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Bad log line %q: %v", line, err)
+		}
+		if entry["msg"] == msg {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func TestResetLogsWhoReset(t *testing.T) {
+	gs, clients := startedGame(t)
+	seat := 2
+	gs.HandleMessage(clients[gs.State.House], ClientMessage{Type: MsgTransferHouse, SeatIndex: &seat})
+	gs.HandleMessage(clients[2], ClientMessage{Type: MsgChangeName, PlayerName: "Carol"})
+
+	logs := captureLogs(t)
+	gs.HandleMessage(clients[2], ClientMessage{Type: MsgResetGame})
+
+	entries := logEntries(t, logs, "Game reset")
+	if len(entries) != 1 {
+		t.Fatalf("Expected one reset entry, got %s", logs)
+	}
+	entry := entries[0]
+	if entry["seat"] != float64(2) || entry["name"] != "Carol" || entry["table"] != DefaultTableID {
+		t.Errorf("Reset logged with the wrong context: %v", entry)
+	}
+}
+
+func TestActionOutcomeLogged(t *testing.T) {
+	gs, clients := startedGame(t)
+	logs := captureLogs(t)
+
+	two := 2
+	waiting := (gs.State.CurrentPlayer + 1) % 4
+	gs.HandleMessage(clients[waiting], ClientMessage{Type: MsgPlaceBid, Amount: &two})
+	gs.HandleMessage(clients[gs.State.CurrentPlayer], ClientMessage{Type: MsgPlaceBid, Amount: &two})
+
+	rejected := logEntries(t, logs, "Action rejected")
+	if len(rejected) != 1 {
+		t.Fatalf("Expected one rejected action, got %s", logs)
+	}
+	want := map[string]any{"action": "placeBid", "outcome": "not_your_turn", "seat": float64(waiting), "hand": float64(1), "amount": float64(2)}
+	for k, v := range want {
+		if rejected[0][k] != v {
+			t.Errorf("Rejected entry %s = %v, want %v", k, rejected[0][k], v)
+		}
+	}
+
+	applied := logEntries(t, logs, "Action applied")
+	if len(applied) != 1 || applied[0]["outcome"] != "ok" || applied[0]["action"] != "placeBid" {
+		t.Errorf("Expected the applied bid to be logged, got %v", applied)
+	}
+}
+
+func TestCardsOnlyLoggedAtDebug(t *testing.T) {
+	gs, clients := startedGame(t)
+	startPlaying(t, gs, clients)
+	seat := gs.State.CurrentPlayer
+	card := gs.State.Players[seat].Hand[0].ID
+
+	logs := captureLogs(t)
+	gs.HandleMessage(clients[seat], ClientMessage{Type: MsgPlayCard, CardID: card})
+
+	applied := logEntries(t, logs, "Action applied")
+	if len(applied) != 1 || applied[0]["level"] != "INFO" || applied[0]["cardCount"] != float64(1) {
+		t.Fatalf("Expected the play logged at info with a card count, got %v", applied)
+	}
+	if applied[0]["card"] != nil || applied[0]["cards"] != nil {
+		t.Errorf("The card should not be logged at info: %v", applied[0])
+	}
+	cards := logEntries(t, logs, "Action cards")
+	if len(cards) != 1 || cards[0]["level"] != "DEBUG" || cards[0]["card"] != card {
+		t.Errorf("Expected the card at debug level, got %v", cards)
+	}
+}
+
+func TestNewLogHandlerValidates(t *testing.T) {
+	if _, err := NewLogHandler(&bytes.Buffer{}, "xml", "info"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+	if _, err := NewLogHandler(&bytes.Buffer{}, LogFormatText, "loud"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+	if _, err := NewLogHandler(&bytes.Buffer{}, "JSON", "WARN"); err != nil {
+		t.Errorf("Format and level should be case-insensitive: %v", err)
+	}
+}
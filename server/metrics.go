@@ -0,0 +1,249 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HandDurationBuckets are the upper bounds, in seconds, of the hand duration histogram
+var HandDurationBuckets = []float64{30, 60, 120, 180, 300, 450, 600, 900, 1800}
+
+// Metrics counts server events for the /metrics endpoint. The hub owns it;
+// the game server records into its hub's Metrics.
+type Metrics struct {
+	mu        sync.Mutex
+	received  map[MessageType]uint64 // Client messages handled, by type
+	errors    map[string]uint64      // Error replies, by code
+	sendDrops uint64                 // Messages a client couldn't take; it was disconnected
+	coalesced uint64                 // State updates replaced before a lagging client got them
+	slowCuts  uint64                 // Clients disconnected for lagging too long
+	panics    uint64                 // Messages whose handler panicked
+	hands     uint64                 // Hands scored
+	sets      uint64                 // Hands where the bidding team was set
+	// Hand duration histogram: counts per HandDurationBuckets bound (not cumulative)
+	handBuckets []uint64
+	handSum     float64
+	handCount   uint64
+}
+
+// NewMetrics creates an empty set of metrics
+func NewMetrics() *Metrics {
+	return &Metrics{
+		received:    make(map[MessageType]uint64),
+		errors:      make(map[string]uint64),
+		handBuckets: make([]uint64, len(HandDurationBuckets)),
+	}
+}
+
+// knownClientMessages bounds the message type label; anything else counts as "unknown"
+var knownClientMessages = func() map[MessageType]bool {
+	known := make(map[MessageType]bool, len(ClientMessageTypes))
+	for _, t := range ClientMessageTypes {
+		known[t] = true
+	}
+	return known
+}()
+
+// countMessage records a message received from a client
+func (m *Metrics) countMessage(t MessageType) {
+	if !knownClientMessages[t] {
+		t = "unknown"
+	}
+	m.mu.Lock()
+	m.received[t]++
+	m.mu.Unlock()
+}
+
+// countError records an error reply by its code
+func (m *Metrics) countError(code string) {
+	m.mu.Lock()
+	m.errors[code]++
+	m.mu.Unlock()
+}
+
+// countDrop records a message a lagging client, or one with a full send
+// buffer, couldn't take. The client is disconnected rather than miss it.
+func (m *Metrics) countDrop() {
+	m.mu.Lock()
+	m.sendDrops++
+	m.mu.Unlock()
+}
+
+// countCoalesced records a pending state update replaced by a newer one
+func (m *Metrics) countCoalesced() {
+	m.mu.Lock()
+	m.coalesced++
+	m.mu.Unlock()
+}
+
+// countSlowDisconnect records a client cut off for lagging too long
+func (m *Metrics) countSlowDisconnect() {
+	m.mu.Lock()
+	m.slowCuts++
+	m.mu.Unlock()
+}
+
+// countPanic records a message whose handler panicked
+func (m *Metrics) countPanic() {
+	m.mu.Lock()
+	m.panics++
+	m.mu.Unlock()
+}
+
+// observeHand records a scored hand: how long it took and whether the bidder was set
+func (m *Metrics) observeHand(duration time.Duration, set bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hands++
+	if set {
+		m.sets++
+	}
+	if duration <= 0 {
+		return // Start time unknown
+	}
+	seconds := duration.Seconds()
+	for i, bound := range HandDurationBuckets {
+		if seconds <= bound {
+			m.handBuckets[i]++
+			break
+		}
+	}
+	m.handSum += seconds
+	m.handCount++
+}
+
+// tableGauges are the point-in-time values read from the tables at scrape time
+type tableGauges struct {
+	tables, clients, seated, spectators int
+	lagging                             int
+}
+
+// gauges counts connections and players across the tables
+func gauges(tables []*GameServer) tableGauges {
+	g := tableGauges{tables: len(tables)}
+	for _, gs := range tables {
+		gs.Hub.Do(func() {
+			for _, p := range gs.State.Players {
+				if p != nil && p.Connected {
+					g.seated++
+				}
+			}
+			for _, client := range gs.Hub.ClientList() {
+				g.clients++
+				if client.SeatIndex < 0 {
+					g.spectators++
+				}
+				if client.lagging {
+					g.lagging++
+				}
+			}
+		})
+	}
+	return g
+}
+
+// MetricsHandler serves the tables' metrics in the Prometheus text format
+func MetricsHandler(tables ...*GameServer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+
+		g := gauges(tables)
+		writeMetric(w, "setback_tables", "gauge", "Active tables", g.tables)
+		writeMetric(w, "setback_clients", "gauge", "Connected WebSocket clients", g.clients)
+		writeMetric(w, "setback_seated_players", "gauge", "Connected players in a seat", g.seated)
+		writeMetric(w, "setback_spectators", "gauge", "Connected clients without a seat", g.spectators)
+		writeMetric(w, "setback_lagging_clients", "gauge", "Clients whose send buffer is full", g.lagging)
+
+		// Tables may share a hub; count each hub's metrics once
+		seen := make(map[*Metrics]bool)
+		var all []*Metrics
+		for _, gs := range tables {
+			if m := gs.Hub.Metrics; m != nil && !seen[m] {
+				seen[m] = true
+				all = append(all, m)
+			}
+		}
+		writeCounters(w, all)
+	}
+}
+
+// writeCounters writes the summed counters and histogram of the given metrics
+func writeCounters(w io.Writer, all []*Metrics) {
+	received := make(map[string]uint64)
+	codes := make(map[string]uint64)
+	var drops, coalesced, slowCuts, panics, hands, sets, handCount uint64
+	var handSum float64
+	handBuckets := make([]uint64, len(HandDurationBuckets))
+
+	for _, m := range all {
+		m.mu.Lock()
+		for t, n := range m.received {
+			received[string(t)] += n
+		}
+		for code, n := range m.errors {
+			codes[code] += n
+		}
+		drops += m.sendDrops
+		coalesced += m.coalesced
+		slowCuts += m.slowCuts
+		panics += m.panics
+		hands += m.hands
+		sets += m.sets
+		for i, n := range m.handBuckets {
+			handBuckets[i] += n
+		}
+		handSum += m.handSum
+		handCount += m.handCount
+		m.mu.Unlock()
+	}
+
+	writeLabeled(w, "setback_messages_received_total", "Client messages handled, by type", "type", received)
+	writeLabeled(w, "setback_errors_total", "Error replies sent to clients, by error code", "code", codes)
+	writeMetric(w, "setback_send_dropped_total", "counter", "Messages a client was disconnected for, instead of being sent them while lagging or with a full buffer", drops)
+	writeMetric(w, "setback_state_coalesced_total", "counter", "State updates replaced by a newer one before a lagging client received them", coalesced)
+	writeMetric(w, "setback_slow_disconnects_total", "counter", "Clients disconnected for lagging longer than the max lag", slowCuts)
+	writeMetric(w, "setback_panics_total", "counter", "Client messages whose handling panicked and was rolled back", panics)
+	writeMetric(w, "setback_hands_total", "counter", "Hands scored", hands)
+	writeMetric(w, "setback_hands_set_total", "counter", "Hands where the bidding team was set", sets)
+
+	fmt.Fprintf(w, "# HELP setback_hand_duration_seconds Time from the deal to scoring\n")
+	fmt.Fprintf(w, "# TYPE setback_hand_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, bound := range HandDurationBuckets {
+		cumulative += handBuckets[i]
+		fmt.Fprintf(w, "setback_hand_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(w, "setback_hand_duration_seconds_bucket{le=\"+Inf\"} %d\n", handCount)
+	fmt.Fprintf(w, "setback_hand_duration_seconds_sum %g\n", handSum)
+	fmt.Fprintf(w, "setback_hand_duration_seconds_count %d\n", handCount)
+}
+
+// writeMetric writes a single unlabeled sample with its HELP and TYPE lines
+func writeMetric[T int | uint64](w io.Writer, name, kind, help string, value T) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+// writeLabeled writes a counter with one sample per label value, sorted
+func writeLabeled(w io.Writer, name, help, label string, values map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", name, label, escapeLabel(k), values[k])
+	}
+}
+
+// escapeLabel escapes a label value for the text format
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sampleLine matches a sample in the Prometheus text format
+var sampleLine = regexp.MustCompile(`^[a-z_]+(\{[a-z_]+="[^"]*"\})? [0-9.e+-]+$`)
+
+// scrape fetches /metrics over HTTP and returns the samples by name (with labels)
+func scrape(t *testing.T, url string) map[string]float64 {
+	t.Helper()
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Unexpected content type %q", ct)
+	}
+
+	samples := make(map[string]float64)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "# HELP ") || strings.HasPrefix(line, "# TYPE ") {
+			continue
+		}
+		if !sampleLine.MatchString(line) {
+			t.Fatalf("Malformed line %q", line)
+		}
+		i := strings.LastIndexByte(line, ' ')
+		value, _ := strconv.ParseFloat(line[i+1:], 64)
+		samples[line[:i]] = value
+	}
+	return samples
+}
+
+func TestMetricsScrape(t *testing.T) {
+	gs, clients := startedGame(t)
+	spectator := helloClient(gs)
+	register(gs.Hub, append(clients[:], spectator)...)
+
+	// An engine error, a full hand, and a send to a full buffer
+	two := 2
+	gs.HandleMessage(clients[(gs.State.CurrentPlayer+1)%4], ClientMessage{Type: MsgPlaceBid, Amount: &two})
+	playHand(t, gs, clients)
+	full := newTestClient(gs.Hub, newFakeConn())
+	full.Send = make(chan []byte)
+	gs.Hub.SendToClient(full, NewNoticeMessage("dropped"))
+
+	srv := httptest.NewServer(MetricsHandler(gs))
+	defer srv.Close()
+	samples := scrape(t, srv.URL)
+
+	want := map[string]float64{
+		"setback_tables":                                  1,
+		"setback_clients":                                 5,
+		"setback_seated_players":                          4,
+		"setback_spectators":                              1,
+		`setback_errors_total{code="not_your_turn"}`:      1,
+		"setback_lagging_clients":                         0,
+		"setback_send_dropped_total":                      1,
+		"setback_state_coalesced_total":                   0,
+		"setback_slow_disconnects_total":                  0,
+		"setback_hands_total":                             1,
+		`setback_hand_duration_seconds_bucket{le="+Inf"}`: 1,
+		"setback_hand_duration_seconds_count":             1,
+	}
+	for name, value := range want {
+		if got, ok := samples[name]; !ok || got != value {
+			t.Errorf("%s = %v (present %v), want %v", name, got, ok, value)
+		}
+	}
+	// Six tricks of four cards, plus any illegal cards playHand tried first
+	if samples[`setback_messages_received_total{type="playCard"}`] < 24 {
+		t.Error("Card plays should be counted by type")
+	}
+	if set := samples["setback_hands_set_total"]; set != 0 && set != 1 {
+		t.Errorf("Unexpected set count %v", set)
+	}
+}
+
+func TestMetricsUnknownMessageType(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	gs.HandleMessage(helloClient(gs), ClientMessage{Type: "made-up"})
+
+	srv := httptest.NewServer(MetricsHandler(gs))
+	defer srv.Close()
+	samples := scrape(t, srv.URL)
+	if samples[`setback_messages_received_total{type="unknown"}`] != 1 {
+		t.Error("Unrecognized types should be counted as unknown")
+	}
+	if _, ok := samples[`setback_messages_received_total{type="made-up"}`]; ok {
+		t.Error("Client-chosen types must not become labels")
+	}
+}
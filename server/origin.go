@@ -0,0 +1,64 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may open a WebSocket.
+// Checking the origin stops other sites from using a visitor's
+// browser (and cookies) to connect - cross-site WebSocket hijacking.
+type OriginPolicy struct {
+	AllowAll bool            // Dev mode: accept any origin
+	Allowed  map[string]bool // Extra origins, as lower-case "scheme://host[:port]"
+}
+
+// NewOriginPolicy creates a policy that accepts same-origin requests
+// plus the listed origins, or everything if allowAll is set
+func NewOriginPolicy(origins []string, allowAll bool) *OriginPolicy {
+	p := &OriginPolicy{
+		AllowAll: allowAll,
+		Allowed:  make(map[string]bool),
+	}
+	for _, o := range origins {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		p.Allowed[strings.ToLower(strings.TrimSuffix(o, "/"))] = true
+	}
+	return p
+}
+
+// Check reports whether the request's origin is allowed.
+// It matches websocket.Upgrader's CheckOrigin signature.
+func (p *OriginPolicy) Check(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		// Not from a browser; nothing for a browser to hijack
+		return true
+	}
+	if p.AllowAll {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		slog.Info("Rejected WebSocket: malformed origin", "remote", r.RemoteAddr, "origin", origin)
+		return false
+	}
+
+	// Same origin: the page was served by this server
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	if p.Allowed[strings.ToLower(u.Scheme+"://"+u.Host)] {
+		return true
+	}
+
+	slog.Info("Rejected WebSocket: origin not allowed", "remote", r.RemoteAddr, "origin", origin)
+	return false
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	policy := NewOriginPolicy([]string{"https://cards.example.com", " http://localhost:3000/ "}, false)
+
+	tests := []struct {
+		name   string
+		host   string
+		origin string
+		want   bool
+	}{
+		{"no origin header", "setback.example.com", "", true},
+		{"same origin", "setback.example.com", "https://setback.example.com", true},
+		{"same origin different case", "Setback.example.com", "https://setback.EXAMPLE.com", true},
+		{"allow-listed", "setback.example.com", "https://cards.example.com", true},
+		{"allow-listed with port", "setback.example.com", "http://localhost:3000", true},
+		{"wrong scheme", "setback.example.com", "http://cards.example.com", false},
+		{"other site", "setback.example.com", "https://evil.example.net", false},
+		{"malformed", "setback.example.com", "://bad", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/ws", nil)
+			r.Host = tt.host
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if got := policy.Check(r); got != tt.want {
+				t.Errorf("Check(%q) = %v, want %v", tt.origin, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOriginPolicyDevMode(t *testing.T) {
+	policy := NewOriginPolicy(nil, true)
+	r := httptest.NewRequest("GET", "/ws", nil)
+	r.Header.Set("Origin", "https://anywhere.example.org")
+	if !policy.Check(r) {
+		t.Error("Dev mode should allow any origin")
+	}
+}
@@ -1,38 +1,91 @@
 package server
 
-import "setback/game"
+import (
+	"errors"
+	"setback/game"
+)
+
+// The protocol schema, TypeScript types and reference doc are generated from this file
+//go:generate go run ../cmd/protocolgen -root ..
+
+// ProtocolVersion is the protocol version this server speaks.
+// Bump it when a message changes shape in a way old clients can't handle.
+const ProtocolVersion = 1
+
+// MinProtocolVersion is the oldest client protocol version still accepted
+const MinProtocolVersion = 1
+
+// ServerFeatures are the optional protocol features this server supports
+var ServerFeatures = []string{"chat", "emotes", "accounts", FeatureDelta, FeatureAcks}
+
+// RuleVariants are the house rules this server plays by
+var RuleVariants = []string{
+	"kitty",       // 6-card kitty for the bid winner
+	"discardDraw", // Everyone may discard and draw after the kitty
+	"offJack",     // Jack of the same color is trump and scores a point
+	"bidSix",      // Bids run 2-6 ("shoot the moon")
+}
 
 // MessageType identifies the type of WebSocket message
 type MessageType string
 
 const (
 	// Client -> Server messages
-	MsgJoinTable    MessageType = "joinTable"
-	MsgLeaveSeat    MessageType = "leaveSeat"
+	MsgHello        MessageType = "hello"        // Must be sent first: protocol version and features
+	MsgJoinTable    MessageType = "joinTable"    // Take a seat (or switch seats)
+	MsgLeaveSeat    MessageType = "leaveSeat"    // Give up your seat
 	MsgChangeName   MessageType = "changeName"   // Change player name
 	MsgKickPlayer     MessageType = "kickPlayer"     // House only: kick a player
 	MsgTransferHouse  MessageType = "transferHouse"  // House only: transfer house to another player
-	MsgStartGame      MessageType = "startGame"
-	MsgPlaceBid     MessageType = "placeBid"
+	MsgMutePlayer     MessageType = "mutePlayer"     // House only: mute or unmute a player's chat
+	MsgStartGame      MessageType = "startGame"      // House only: deal the first hand
+	MsgPlaceBid     MessageType = "placeBid"     // Bid 2-6, or 0 to pass
 	MsgSelectTrump  MessageType = "selectTrump"  // Kitty phase: select trump suit
 	MsgTakeKitty    MessageType = "takeKitty"    // Kitty phase: take cards from kitty
 	MsgDiscard      MessageType = "discard"      // Kitty phase: bid winner discards to 6
 	MsgDiscardDraw  MessageType = "discardDraw"  // Discard phase: discard and draw replacements
-	MsgPlayCard     MessageType = "playCard"
-	MsgRejoin       MessageType = "rejoin"
-	MsgNewHand      MessageType = "newHand"
+	MsgPlayCard     MessageType = "playCard"     // Play a card to the current trick
+	MsgRejoin       MessageType = "rejoin"       // Reclaim a seat with a session token (or account)
+	MsgNewHand      MessageType = "newHand"      // Deal the next hand after scoring
 	MsgResetGame    MessageType = "resetGame"    // Admin only: reset game to lobby
+	MsgChat         MessageType = "chat"         // Send a chat message to the table or team
+	MsgResync       MessageType = "resync"       // Client missed a delta: send the full state
+	MsgSendEmote    MessageType = "sendEmote"    // Send a predefined emote
+	MsgSetEmotes    MessageType = "setEmotes"    // House only: turn emotes on or off
 
 	// Server -> Client messages
-	MsgStateUpdate  MessageType = "stateUpdate"
-	MsgError        MessageType = "error"
-	MsgScoreUpdate  MessageType = "scoreUpdate"
-	MsgGameOver     MessageType = "gameOver"
+	MsgWelcome      MessageType = "welcome"      // Reply to hello: server version, features and rules
+	MsgStateUpdate  MessageType = "stateUpdate"  // Full state, personalized for the recipient's seat
+	MsgStateDelta   MessageType = "stateDelta"   // Patch against the client's last state (seq baseSeq)
+	MsgError        MessageType = "error"        // A message failed (coded; echoes requestId)
+	MsgScoreUpdate  MessageType = "scoreUpdate"  // Scoring breakdown for the hand just played
+	MsgGameOver     MessageType = "gameOver"     // A team reached the target score
+	MsgChatMessage  MessageType = "chatMessage"  // A single chat message
+	MsgChatHistory  MessageType = "chatHistory"  // Recent chat, sent on join and rejoin
+	MsgEmote        MessageType = "emote"        // A player sent an emote
+	MsgSession      MessageType = "session"      // New session token, sent when issued or rotated
+	MsgAck          MessageType = "ack"          // Request succeeded (echoes requestId)
+	MsgNotice       MessageType = "notice"       // Announcement from the server operator
 )
 
+// ClientMessageTypes lists the messages a client may send
+var ClientMessageTypes = []MessageType{
+	MsgHello, MsgJoinTable, MsgLeaveSeat, MsgChangeName, MsgKickPlayer,
+	MsgTransferHouse, MsgMutePlayer, MsgStartGame, MsgPlaceBid, MsgSelectTrump,
+	MsgTakeKitty, MsgDiscard, MsgDiscardDraw, MsgPlayCard, MsgRejoin, MsgNewHand,
+	MsgResetGame, MsgChat, MsgResync, MsgSendEmote, MsgSetEmotes,
+}
+
+// ServerMessageTypes lists the messages the server may send
+var ServerMessageTypes = []MessageType{
+	MsgWelcome, MsgStateUpdate, MsgStateDelta, MsgError, MsgScoreUpdate, MsgGameOver,
+	MsgChatMessage, MsgChatHistory, MsgEmote, MsgSession, MsgAck, MsgNotice,
+}
+
 // ClientMessage represents a message from client to server
 type ClientMessage struct {
 	Type       MessageType `json:"type"`
+	RequestID  string      `json:"requestId,omitempty"` // Optional; echoed in the ack or error, retries are deduped
 	SeatIndex  *int        `json:"seatIndex,omitempty"`
 	PlayerName string      `json:"playerName,omitempty"`
 	Amount     *int        `json:"amount,omitempty"` // Bid amount (0 = pass)
@@ -40,11 +93,21 @@ type ClientMessage struct {
 	CardIDs    []string    `json:"cardIds,omitempty"`  // For taking/discarding multiple cards
 	TrumpSuit  string      `json:"trumpSuit,omitempty"` // For selecting trump
 	Token      string      `json:"token,omitempty"`     // Session token for rejoin
+	Text       string      `json:"text,omitempty"`      // Chat text
+	Channel    ChatChannel `json:"channel,omitempty"`   // Chat channel (table or team)
+	Muted      *bool       `json:"muted,omitempty"`     // For muting a player
+	ClientID   *uint64     `json:"clientId,omitempty"`  // For muting a spectator, from their chat messages
+	Emote      string      `json:"emote,omitempty"`     // Emote name from the catalog
+	Enabled    *bool       `json:"enabled,omitempty"`   // For turning emotes on or off
+
+	ProtocolVersion int      `json:"protocolVersion,omitempty"` // Hello: protocol version the client speaks
+	Features        []string `json:"features,omitempty"`        // Hello: optional features the client supports
 }
 
 // ServerMessage represents a message from server to client
 type ServerMessage struct {
 	Type         MessageType       `json:"type"`
+	RequestID    string            `json:"requestId,omitempty"` // Echoed from the request this answers
 	State        *PublicState      `json:"state,omitempty"`
 	YourHand     []game.Card       `json:"yourHand,omitempty"`
 	Kitty        []game.Card       `json:"kitty,omitempty"` // Shown to bid winner during kitty phase
@@ -53,14 +116,37 @@ type ServerMessage struct {
 	Error        *ErrorPayload     `json:"error,omitempty"`
 	ScoreResult  *game.ScoreResult `json:"scoreResult,omitempty"`
 	WinningTeam  *int              `json:"winningTeam,omitempty"`
+	Chat         *ChatMessage      `json:"chat,omitempty"`
+	ChatHistory  []ChatMessage     `json:"chatHistory,omitempty"`
+	Emote        *EmotePayload     `json:"emote,omitempty"`
+	Welcome      *WelcomePayload   `json:"welcome,omitempty"`
+	Notice       string            `json:"notice,omitempty"` // Operator announcement text
+
+	// State versioning: every state broadcast gets the next Seq.
+	// A stateDelta applies only on top of the update numbered BaseSeq.
+	Seq     uint64    `json:"seq,omitempty"`
+	BaseSeq uint64    `json:"baseSeq,omitempty"`
+	Patch   []PatchOp `json:"patch,omitempty"`
+}
+
+// WelcomePayload describes the server to a client after hello
+type WelcomePayload struct {
+	ProtocolVersion    int      `json:"protocolVersion"`
+	MinProtocolVersion int      `json:"minProtocolVersion"`
+	Features           []string `json:"features"`     // Features both sides support
+	RuleVariants       []string `json:"ruleVariants"`
 }
 
 // ErrorPayload contains error information
 type ErrorPayload struct {
-	Code    string `json:"code"`
-	Message string `json:"message"`
+	Code    string         `json:"code"`
+	Message string         `json:"message"`
+	Params  map[string]any `json:"params,omitempty"` // Details for building a localized message, e.g. minBid
 }
 
+// ErrCodeActionFailed is the code for handler errors without one of their own
+const ErrCodeActionFailed = "action_failed"
+
 // PublicState is the game state visible to all players
 type PublicState struct {
 	Phase         game.Phase     `json:"phase"`
@@ -79,6 +165,7 @@ type PublicState struct {
 	KittyCount    int            `json:"kittyCount"` // Number of cards in kitty
 	House         int            `json:"house"`      // Seat index of the house (game owner)
 	TrumpBroken   bool           `json:"trumpBroken"` // Whether trump has been played this hand
+	EmotesEnabled bool           `json:"emotesEnabled"` // Whether the house allows emotes
 }
 
 // PublicPlayer is player info visible to all
@@ -90,6 +177,8 @@ type PublicPlayer struct {
 	HasBid          bool   `json:"hasBid"`
 	DiscardReady    bool   `json:"discardReady"`    // Has submitted discard selection (waiting for turn)
 	DiscardComplete bool   `json:"discardComplete"` // Has completed discard and draw
+	Muted           bool   `json:"muted"`           // Muted by the house
+	Registered      bool   `json:"registered"`      // Seat belongs to a registered account
 }
 
 // TeamState is team info visible to all
@@ -128,6 +217,7 @@ func BuildPublicState(gs *game.GameState) *PublicState {
 		KittyCount:    len(gs.Kitty),
 		House:         gs.House,
 		TrumpBroken:   gs.TrumpBroken,
+		EmotesEnabled: !gs.EmotesDisabled,
 	}
 
 	// Players
@@ -153,6 +243,8 @@ func BuildPublicState(gs *game.GameState) *PublicState {
 				HasBid:          hasBid,
 				DiscardReady:    gs.PendingDiscards[i] != nil,
 				DiscardComplete: gs.DiscardComplete[i],
+				Muted:           p.Muted,
+				Registered:      p.Account != "",
 			})
 		}
 	}
@@ -218,6 +310,18 @@ func NewErrorMessage(code, message string) ServerMessage {
 	}
 }
 
+// NewActionErrorMessage creates an error message for a failed action,
+// carrying the error's code and parameters when it has them
+func NewActionErrorMessage(err error) ServerMessage {
+	var gameErr *game.Error
+	if errors.As(err, &gameErr) {
+		msg := NewErrorMessage(string(gameErr.Code), gameErr.Message)
+		msg.Error.Params = gameErr.Params
+		return msg
+	}
+	return NewErrorMessage(ErrCodeActionFailed, err.Error())
+}
+
 // NewStateUpdateMessage creates a state update message for a specific player
 func NewStateUpdateMessage(gs *game.GameState, seatIndex int) ServerMessage {
 	msg := ServerMessage{
@@ -228,7 +332,6 @@ func NewStateUpdateMessage(gs *game.GameState, seatIndex int) ServerMessage {
 	if seatIndex >= 0 && seatIndex < 4 && gs.Players[seatIndex] != nil {
 		msg.YourHand = gs.Players[seatIndex].Hand
 		msg.YourSeat = &seatIndex
-		msg.YourToken = gs.Players[seatIndex].SessionToken
 
 		// During kitty phase, show kitty to bid winner
 		if gs.Phase == game.PhaseKitty && seatIndex == gs.BidWinner {
@@ -238,3 +341,21 @@ func NewStateUpdateMessage(gs *game.GameState, seatIndex int) ServerMessage {
 
 	return msg
 }
+
+// NewSessionMessage tells a client its seat and session token.
+// Tokens are only sent when issued or rotated, not with every state update.
+func NewSessionMessage(seatIndex int, token string) ServerMessage {
+	return ServerMessage{
+		Type:      MsgSession,
+		YourSeat:  &seatIndex,
+		YourToken: token,
+	}
+}
+
+// NewNoticeMessage creates an operator announcement
+func NewNoticeMessage(text string) ServerMessage {
+	return ServerMessage{
+		Type:   MsgNotice,
+		Notice: text,
+	}
+}
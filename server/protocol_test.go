@@ -0,0 +1,35 @@
+package server
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestActionErrorsCarryCodeAndParams(t *testing.T) {
+	gs, clients := startedGame(t)
+	three := 3
+	gs.HandleMessage(clients[gs.State.CurrentPlayer], ClientMessage{Type: MsgPlaceBid, Amount: &three})
+
+	bidder := clients[gs.State.CurrentPlayer]
+	gs.HandleMessage(bidder, ClientMessage{Type: MsgPlaceBid, Amount: &three})
+	reply := lastOfType(drain(bidder), MsgError)
+	if reply == nil {
+		t.Fatal("Expected an error")
+	}
+	if reply.Error.Code != "bid_too_low" || reply.Error.Params["minBid"] != 4.0 {
+		t.Errorf("Expected bid_too_low with minBid 4, got %+v", reply.Error)
+	}
+
+	waiting := clients[(gs.State.CurrentPlayer+1)%4]
+	gs.HandleMessage(waiting, ClientMessage{Type: MsgPlaceBid, Amount: &three})
+	if reply := lastOfType(drain(waiting), MsgError); reply == nil || reply.Error.Code != "not_your_turn" {
+		t.Errorf("Expected not_your_turn, got %+v", reply)
+	}
+}
+
+func TestUncodedErrorsFallBack(t *testing.T) {
+	msg := NewActionErrorMessage(errors.New("boom"))
+	if msg.Error.Code != ErrCodeActionFailed || msg.Error.Message != "boom" {
+		t.Errorf("Unexpected fallback %+v", msg.Error)
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"time"
+)
+
+// ErrCodeRateLimited is sent for each message dropped for exceeding a limit
+const ErrCodeRateLimited = "rate_limited"
+
+// RateLimit is a token bucket: Burst messages at once, refilled at Rate per second
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// RateLimitConfig controls per-connection message limits
+type RateLimitConfig struct {
+	Overall RateLimit                 // All messages from one connection, including unparseable ones
+	PerType map[MessageType]RateLimit // Tighter limits for specific message types
+	Default RateLimit                 // Types not listed in PerType
+	Strikes RateLimit                 // Rejections tolerated before the client is disconnected
+}
+
+// DefaultRateLimitConfig returns limits generous enough for normal play
+// (including quick clicking) but that stop a flood
+func DefaultRateLimitConfig() RateLimitConfig {
+	return RateLimitConfig{
+		Overall: RateLimit{Rate: 20, Burst: 40},
+		PerType: map[MessageType]RateLimit{
+			MsgChat:       {Rate: 1, Burst: 5},
+			MsgSendEmote:  {Rate: 1, Burst: 3},
+			MsgChangeName: {Rate: 0.5, Burst: 3},
+			MsgRejoin:     {Rate: 0.5, Burst: 3},
+			MsgJoinTable:  {Rate: 1, Burst: 5},
+		},
+		Default: RateLimit{Rate: 5, Burst: 10},
+		Strikes: RateLimit{Rate: 0.2, Burst: 10},
+	}
+}
+
+// tokenBucket is a simple token bucket rate limiter
+type tokenBucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+// allow takes a token if one is available
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.Rate
+	if b.tokens > float64(b.limit.Burst) {
+		b.tokens = float64(b.limit.Burst)
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateLimiter tracks one connection's message budget.
+// Only the client's ReadPump uses it, so it needs no locking.
+type rateLimiter struct {
+	config  RateLimitConfig
+	overall *tokenBucket
+	perType map[MessageType]*tokenBucket
+	strikes *tokenBucket
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	now := time.Now()
+	return &rateLimiter{
+		config:  config,
+		overall: newTokenBucket(config.Overall, now),
+		perType: make(map[MessageType]*tokenBucket),
+		strikes: newTokenBucket(config.Strikes, now),
+	}
+}
+
+// allowAny checks the overall budget for a raw incoming message
+func (rl *rateLimiter) allowAny(now time.Time) bool {
+	return rl.overall.allow(now)
+}
+
+// allowType checks the budget for a specific message type.
+// Types without their own limit share one default bucket, so a client
+// can't grow the map by inventing message types.
+func (rl *rateLimiter) allowType(msgType MessageType, now time.Time) bool {
+	limit, ok := rl.config.PerType[msgType]
+	if !ok {
+		msgType = ""
+		limit = rl.config.Default
+	}
+
+	bucket, ok := rl.perType[msgType]
+	if !ok {
+		bucket = newTokenBucket(limit, now)
+		rl.perType[msgType] = bucket
+	}
+	return bucket.allow(now)
+}
+
+// strike records a rejected message and reports whether the client
+// has run out of strikes and should be disconnected
+func (rl *rateLimiter) strike(now time.Time) bool {
+	return !rl.strikes.allow(now)
+}
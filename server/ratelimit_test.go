@@ -0,0 +1,114 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefills(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(RateLimit{Rate: 2, Burst: 3}, now)
+
+	for i := 0; i < 3; i++ {
+		if !b.allow(now) {
+			t.Fatalf("Message %d within burst was rejected", i+1)
+		}
+	}
+	if b.allow(now) {
+		t.Fatal("Message beyond burst was allowed")
+	}
+
+	// Two tokens per second: one is back after half a second
+	now = now.Add(500 * time.Millisecond)
+	if !b.allow(now) {
+		t.Error("Token should have refilled")
+	}
+	if b.allow(now) {
+		t.Error("Only one token should have refilled")
+	}
+
+	// Refill never exceeds the burst
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		b.allow(now)
+	}
+	if b.allow(now) {
+		t.Error("Bucket refilled past its burst")
+	}
+}
+
+func TestUnknownTypesShareDefaultBucket(t *testing.T) {
+	config := DefaultRateLimitConfig()
+	config.Default = RateLimit{Rate: 0, Burst: 2}
+	rl := newRateLimiter(config)
+	now := time.Now()
+
+	rl.allowType("made-up-1", now)
+	rl.allowType("made-up-2", now)
+	if rl.allowType("made-up-3", now) {
+		t.Error("Invented message types should share the default bucket")
+	}
+	if len(rl.perType) != 1 {
+		t.Errorf("Expected 1 bucket, got %d", len(rl.perType))
+	}
+}
+
+func TestReadPumpDisconnectsFlooder(t *testing.T) {
+	hub := NewHub()
+	hub.ConnConfig = testConnConfig()
+	hub.ConnConfig.PongWait = time.Minute
+	hub.RateLimits.Overall = RateLimit{Rate: 0, Burst: 5}
+	hub.RateLimits.Strikes = RateLimit{Rate: 0, Burst: 3}
+	conn := newFakeConn()
+	client := newTestClient(hub, conn)
+
+	go client.ReadPump()
+
+	// Drain delivered messages so ReadPump never blocks on Incoming
+	go func() {
+		for range hub.Incoming {
+		}
+	}()
+
+	go func() {
+		for i := 0; i < 20; i++ {
+			select {
+			case conn.incoming <- []byte(`{"type":"placeBid"}`):
+			case <-time.After(time.Second):
+				return
+			}
+		}
+	}()
+
+	waitUnregister(t, hub, 2*time.Second)
+
+	// Rejections were reported before the disconnect
+	rejected := 0
+	for len(client.Send) > 0 {
+		<-client.Send
+		rejected++
+	}
+	if rejected != 3 {
+		t.Errorf("Expected 3 rate_limited errors before disconnect, got %d", rejected)
+	}
+}
+
+func TestAcquireIPEnforcesCap(t *testing.T) {
+	hub := NewHub()
+	hub.MaxConnsPerIP = 2
+
+	if !hub.AcquireIP("10.0.0.1") || !hub.AcquireIP("10.0.0.1") {
+		t.Fatal("Connections within the cap were rejected")
+	}
+	if hub.AcquireIP("10.0.0.1") {
+		t.Error("Third connection from the same IP was allowed")
+	}
+	if !hub.AcquireIP("10.0.0.2") {
+		t.Error("Another IP should not be affected")
+	}
+
+	hub.ReleaseIP("10.0.0.1")
+	if !hub.AcquireIP("10.0.0.1") {
+		t.Error("Released slot should be available again")
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"log/slog"
+	"runtime/debug"
+	"setback/game"
+)
+
+// ErrCodeInternal is sent when handling a message panicked. The table is
+// left as it was before the message.
+const ErrCodeInternal = "internal_error"
+
+// readOnly lists the messages that never change the game, the seats or
+// the seat tokens, so they are handled without an undo point
+var readOnly = map[MessageType]bool{
+	MsgHello:     true,
+	MsgResync:    true,
+	MsgChat:      true,
+	MsgSendEmote: true,
+}
+
+// undoPoint is what handling a message may change, saved beforehand
+// so a panic can be undone
+type undoPoint struct {
+	state   *game.GameState
+	seats   [4]*Client
+	clients map[*Client]clientSeat
+	revoked map[string]int64
+	seq     uint64
+}
+
+// clientSeat is a client's seat and seat token
+type clientSeat struct {
+	seat  int
+	token string
+}
+
+// saveUndo saves the table before handling a message
+func (gs *GameServer) saveUndo() *undoPoint {
+	u := &undoPoint{
+		state:   gs.State.Clone(),
+		seats:   gs.Hub.seats,
+		clients: make(map[*Client]clientSeat, len(gs.Hub.clients)),
+		seq:     gs.stateSeq,
+	}
+	for c := range gs.Hub.clients {
+		u.clients[c] = clientSeat{seat: c.SeatIndex, token: c.Token}
+	}
+	if gs.Tokens != nil {
+		u.revoked = gs.Tokens.Revocations()
+	}
+	return u
+}
+
+// undo puts the table back as it was at u. Clients that disconnected
+// while the message was handled can't be put back, so their seats are
+// left empty.
+func (gs *GameServer) undo(u *undoPoint) {
+	gs.State = u.state
+	for c, cs := range u.clients {
+		c.SeatIndex, c.Token = cs.seat, cs.token
+	}
+	gs.Hub.seats = u.seats
+	for i, c := range u.seats {
+		if c != nil && c.closed {
+			gs.Hub.seats[i] = nil
+			if p := gs.State.Players[i]; p != nil {
+				p.Connected = false
+			}
+		}
+	}
+	if gs.Tokens != nil && u.revoked != nil {
+		gs.Tokens.setRevocations(u.revoked)
+	}
+}
+
+// recoverMessage is deferred around each message. If handling it panicked,
+// the table is rolled back and the sender gets an error; the hub goroutine
+// carries on with the next message. Clients are only resent the state if
+// some was sent before the panic.
+func (gs *GameServer) recoverMessage(client *Client, msg ClientMessage, before *undoPoint) {
+	p := recover()
+	if p == nil {
+		return
+	}
+	gs.Hub.Metrics.countPanic()
+	log := gs.logger(client)
+	log.Error("Handling message panicked; table rolled back",
+		append(actionAttrs(msg), "panic", p, "stack", string(debug.Stack()))...)
+
+	if before != nil {
+		gs.undo(before)
+	}
+	reply := NewErrorMessage(ErrCodeInternal, "Something went wrong on the server. Your action was not applied.")
+	gs.Hub.Metrics.countError(ErrCodeInternal)
+	ok := safely(log, func() {
+		if before != nil && gs.stateSeq != before.seq {
+			gs.broadcastState()
+		}
+		gs.respond(client, msg, &reply)
+	})
+	if !ok {
+		// The sender can't be told; dropping it makes it reconnect and resync
+		safely(log, func() { gs.Hub.Disconnect(client) })
+	}
+}
+
+// safely runs fn, reporting false if it panicked
+func safely(log *slog.Logger, fn func()) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Error("Recovering from a panic panicked", "panic", p, "stack", string(debug.Stack()))
+		}
+	}()
+	fn()
+	return true
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"setback/game"
+)
+
+func TestPanicRollsBackTable(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	go gs.Run()
+	house, late := newTestClient(gs.Hub, newFakeConn()), newTestClient(gs.Hub, newFakeConn())
+	register(gs.Hub, house, late)
+
+	zero, one := 0, 1
+	gs.Hub.Do(func() {
+		for _, c := range []*Client{house, late} {
+			gs.HandleMessage(c, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion})
+		}
+		gs.HandleMessage(house, ClientMessage{Type: MsgJoinTable, SeatIndex: &zero, PlayerName: "House"})
+		drain(house)
+		drain(late)
+
+		before, _ := game.MarshalSnapshot(gs.State)
+
+		// Issuing the seat token panics after the seat has been taken
+		tokens := gs.Tokens
+		gs.Tokens = nil
+		gs.HandleMessage(late, ClientMessage{Type: MsgJoinTable, SeatIndex: &one, PlayerName: "Late", RequestID: "r1"})
+		gs.Tokens = tokens
+
+		if after, _ := game.MarshalSnapshot(gs.State); string(after) != string(before) {
+			t.Errorf("The game should be rolled back:\n got %s\nwant %s", after, before)
+		}
+		if gs.Hub.seats[1] != nil || late.SeatIndex != -1 || late.Token != "" {
+			t.Errorf("Seat 1 should be rolled back: client %v, seat %d", gs.Hub.seats[1], late.SeatIndex)
+		}
+		if gs.Hub.seats[0] != house || house.SeatIndex != 0 {
+			t.Error("The house's seat should be untouched")
+		}
+		reply := lastOfType(drain(late), MsgError)
+		if reply == nil || reply.Error.Code != ErrCodeInternal || reply.RequestID != "r1" {
+			t.Errorf("Expected an internal_error reply to r1, got %+v", reply)
+		}
+		if msgs := drain(house); len(msgs) != 0 {
+			t.Errorf("Nothing changed, so the house should hear nothing, got %+v", msgs)
+		}
+		if n := gs.Hub.Metrics.panics; n != 1 {
+			t.Errorf("Expected 1 panic counted, got %d", n)
+		}
+
+		// The table carries on
+		gs.HandleMessage(late, ClientMessage{Type: MsgJoinTable, SeatIndex: &one, PlayerName: "Late"})
+		if late.SeatIndex != 1 || gs.State.Players[1] == nil {
+			t.Error("Joining should work once the fault is gone")
+		}
+	})
+}
+
+func TestPanicMidGameLeavesTableUntouched(t *testing.T) {
+	gs, clients := startedGame(t)
+	register(gs.Hub, clients[:]...)
+	gs.Hub.Do(func() {
+		// A player drops, and a spectator takes over the seat
+		seat := (gs.State.CurrentPlayer + 1) % 4
+		gs.State.Players[seat].Connected = false
+		gs.Hub.UnseatClient(clients[seat])
+		spectator := helloClient(gs)
+		gs.Hub.register(spectator)
+		for _, c := range clients {
+			drain(c)
+		}
+
+		before, _ := game.MarshalSnapshot(gs.State)
+		seats := gs.Hub.seats
+		var tokens [4]string
+		for i, c := range clients {
+			tokens[i] = c.Token
+		}
+
+		// Revoking the dropped player's token panics once the seat is taken
+		issuer := gs.Tokens
+		gs.Tokens = nil
+		gs.HandleMessage(spectator, ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: "Taker"})
+		gs.Tokens = issuer
+
+		// Players, hands, turn and deck alike
+		if after, _ := game.MarshalSnapshot(gs.State); string(after) != string(before) {
+			t.Errorf("The game should be rolled back:\n got %s\nwant %s", after, before)
+		}
+		if gs.State.Players[seat].Connected || gs.State.Players[seat].Name != "P" || len(gs.State.Deck.Cards) == 0 {
+			t.Errorf("Seat %d should still be the dropped player's", seat)
+		}
+		if gs.Hub.seats != seats || spectator.SeatIndex != -1 || spectator.Token != "" {
+			t.Errorf("Seats should be rolled back, spectator at seat %d", spectator.SeatIndex)
+		}
+		for i, c := range clients {
+			if c.Token != tokens[i] {
+				t.Errorf("Seat %d's token changed", i)
+			}
+			if msgs := drain(c); len(msgs) != 0 {
+				t.Errorf("Seat %d should hear nothing about the failed takeover, got %+v", i, msgs)
+			}
+		}
+		if reply := lastOfType(drain(spectator), MsgError); reply == nil || reply.Error.Code != ErrCodeInternal {
+			t.Errorf("Expected an internal_error reply, got %+v", reply)
+		}
+	})
+}
+
+func TestUndoRestoresRevokedTokens(t *testing.T) {
+	gs, clients := startedGame(t)
+	register(gs.Hub, clients[:]...)
+	gs.Hub.Do(func() {
+		client := clients[gs.State.CurrentPlayer]
+		token := client.Token
+
+		u := gs.saveUndo()
+		gs.revokeClientToken(client)
+		gs.undo(u)
+		if client.Token != token {
+			t.Error("The client's token should be put back")
+		}
+		if _, err := gs.Tokens.Validate(token); err != nil {
+			t.Errorf("The revocation should be undone, got %v", err)
+		}
+	})
+}
+
+func TestJoinOutOfRangeSeatMidGame(t *testing.T) {
+	gs, _ := startedGame(t)
+	spectator := helloClient(gs)
+	for _, seat := range []int{9, -2} {
+		gs.HandleMessage(spectator, ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: "Nine"})
+		reply := lastOfType(drain(spectator), MsgError)
+		if reply == nil || reply.Error.Code != string(game.ErrInvalidSeat.Code) {
+			t.Errorf("Seat %d: expected %s, got %+v", seat, game.ErrInvalidSeat.Code, reply)
+		}
+	}
+	if gs.Hub.Metrics.panics != 0 {
+		t.Error("Out-of-range seats should be rejected, not recovered from")
+	}
+}
+
+// fuzzScript encodes messages for FuzzHandleMessage: one per line, the
+// first byte choosing the sender (seats 0-3, then a spectator)
+func fuzzScript(lines ...string) []byte {
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func FuzzHandleMessage(f *testing.F) {
+	f.Add(fuzzScript(
+		"\x04"+`{"type":"joinTable","seatIndex":9,"playerName":"x"}`,
+		"\x01"+`{"type":"placeBid","amount":2}`,
+		"\x02"+`{"type":"placeBid","amount":0}`,
+		"\x03"+`{"type":"placeBid","amount":0}`,
+		"\x00"+`{"type":"placeBid","amount":0}`,
+		"\x01"+`{"type":"selectTrump","trumpSuit":"hearts"}`,
+		"\x01"+`{"type":"takeKitty","cardIds":[]}`,
+		"\x01"+`{"type":"discard","cardIds":[]}`,
+	))
+	f.Add(fuzzScript(
+		"\x00"+`{"type":"kickPlayer","seatIndex":2}`,
+		"\x04"+`{"type":"joinTable","seatIndex":2,"playerName":"Spec"}`,
+		"\x02"+`{"type":"rejoin","token":"x"}`,
+		"\x00"+`{"type":"transferHouse","seatIndex":3}`,
+		"\x03"+`{"type":"resetGame"}`,
+		"\x01"+`{"type":"leaveSeat"}`,
+		"\x04"+`{"type":"joinTable","seatIndex":1}`,
+		"\x03"+`{"type":"startGame"}`,
+	))
+	f.Add(fuzzScript(
+		"\x00"+`{"type":"chat","text":"hi","channel":"team"}`,
+		"\x04"+`{"type":"sendEmote","emote":"👍"}`,
+		"\x00"+`{"type":"mutePlayer","seatIndex":7,"muted":true}`,
+		"\x00"+`{"type":"setEmotes","enabled":false}`,
+		"\x01"+`{"type":"changeName","playerName":""}`,
+		"\x02"+`{"type":"playCard","cardId":"AS"}`,
+		"\x03"+`{"type":"discardDraw","cardIds":["2H","2H","2H","2H","2H","2H","2H"]}`,
+		"\x04"+`{"type":"resync","requestId":"r"}`,
+		"\x00"+`{"type":"newHand"}`,
+	))
+
+	f.Fuzz(func(t *testing.T, script []byte) {
+		captureLogs(t) // Rejected actions log every message; keep them out of the fuzzer's output
+		// A table in the middle of bidding, run on this goroutine: with no
+		// hub goroutine, the hub calls HandleMessage would make run inline
+		gs := NewGameServer(NewHub(), 11)
+		var clients [5]*Client
+		for i := range clients {
+			clients[i] = helloClient(gs)
+			gs.Hub.register(clients[i])
+			if i < 4 {
+				seat := i
+				gs.HandleMessage(clients[i], ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: "P"})
+			}
+		}
+		gs.HandleMessage(clients[gs.State.House], ClientMessage{Type: MsgStartGame})
+
+		for _, line := range bytes.Split(script, []byte("\n")) {
+			if len(line) == 0 {
+				continue
+			}
+			var msg ClientMessage
+			if json.Unmarshal(line[1:], &msg) != nil {
+				continue // ReadPump rejects these before the table sees them
+			}
+			gs.HandleMessage(clients[int(line[0])%len(clients)], msg)
+			if gs.Hub.Metrics.panics > 0 {
+				t.Fatalf("Handling %s panicked", line[1:])
+			}
+			for i, c := range gs.Hub.seats {
+				if c != nil && (c.SeatIndex != i || gs.State.Players[i] == nil) {
+					t.Fatalf("After %s: seat %d holds a client at seat %d (player %v)", line[1:], i, c.SeatIndex, gs.State.Players[i])
+				}
+			}
+			for _, c := range clients {
+				drain(c)
+			}
+		}
+	})
+}
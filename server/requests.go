@@ -0,0 +1,93 @@
+package server
+
+import (
+	"time"
+)
+
+// FeatureAcks is the hello feature advertising requestId acknowledgements
+const FeatureAcks = "acks"
+
+// Request dedupe limits. A client that retries an action with the same
+// requestId within the window gets the original reply instead of the
+// action being applied twice.
+const (
+	RequestWindow      = 2 * time.Minute
+	MaxTrackedRequests = 32
+	MaxRequestIDLength = 64
+)
+
+// ErrCodeInvalidRequestID is sent for a requestId over MaxRequestIDLength
+const ErrCodeInvalidRequestID = "invalid_request_id"
+
+type requestEntry struct {
+	id    string
+	reply ServerMessage
+	at    time.Time
+}
+
+// requestLog remembers the replies to a client's recent requests, oldest first.
+// Must be used on the hub goroutine.
+type requestLog struct {
+	entries []requestEntry
+}
+
+// prune drops entries older than RequestWindow
+func (l *requestLog) prune(now time.Time) {
+	i := 0
+	for i < len(l.entries) && now.Sub(l.entries[i].at) > RequestWindow {
+		i++
+	}
+	l.entries = l.entries[i:]
+}
+
+// lookup returns the reply already sent for a request ID
+func (l *requestLog) lookup(id string, now time.Time) (ServerMessage, bool) {
+	l.prune(now)
+	for _, e := range l.entries {
+		if e.id == id {
+			return e.reply, true
+		}
+	}
+	return ServerMessage{}, false
+}
+
+// record remembers the reply to a request, forgetting the oldest past MaxTrackedRequests
+func (l *requestLog) record(id string, reply ServerMessage, now time.Time) {
+	l.prune(now)
+	if len(l.entries) >= MaxTrackedRequests {
+		l.entries = l.entries[len(l.entries)-MaxTrackedRequests+1:]
+	}
+	l.entries = append(l.entries, requestEntry{id: id, reply: reply, at: now})
+}
+
+// requestLogFor returns a client's dedupe log, creating it on first use
+func (gs *GameServer) requestLogFor(client *Client) *requestLog {
+	if client.requests == nil {
+		client.requests = &requestLog{}
+	}
+	return client.requests
+}
+
+// respond sends the outcome of a client message: reply if there is one
+// (an error), otherwise an ack when the client asked for one with a requestId.
+// Replies to requests are remembered so a retry gets the same answer.
+func (gs *GameServer) respond(client *Client, msg ClientMessage, reply *ServerMessage) {
+	if msg.RequestID == "" {
+		if reply != nil {
+			gs.Hub.SendToClient(client, *reply)
+		}
+		return
+	}
+
+	if reply == nil {
+		reply = &ServerMessage{Type: MsgAck}
+	}
+	reply.RequestID = msg.RequestID
+
+	requests := gs.requestLogFor(client)
+	requests.record(msg.RequestID, *reply, time.Now())
+	if client.SeatIndex >= 0 {
+		gs.seatRequests[client.SeatIndex] = requests
+	}
+	gs.Hub.SendToClient(client, *reply)
+}
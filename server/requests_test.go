@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"setback/game"
+)
+
+// helloClient creates a client that has completed the handshake
+func helloClient(gs *GameServer) *Client {
+	client := newTestClient(gs.Hub, newFakeConn())
+	gs.HandleMessage(client, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion})
+	drain(client)
+	return client
+}
+
+// drain returns the messages waiting in a client's send buffer
+func drain(client *Client) []ServerMessage {
+	var msgs []ServerMessage
+	for {
+		select {
+		case data := <-client.Send:
+			var msg ServerMessage
+			json.Unmarshal(data, &msg)
+			msgs = append(msgs, msg)
+		default:
+			return msgs
+		}
+	}
+}
+
+// startedGame seats four clients and deals the first hand
+func startedGame(t *testing.T) (*GameServer, [4]*Client) {
+	t.Helper()
+	gs := NewGameServer(NewHub(), 21)
+	go gs.Hub.Run() // Scoring broadcasts through the hub
+	var clients [4]*Client
+	for i := range clients {
+		clients[i] = helloClient(gs)
+		seat := i
+		gs.HandleMessage(clients[i], ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: "P"})
+	}
+	gs.HandleMessage(clients[gs.State.House], ClientMessage{Type: MsgStartGame})
+	for _, c := range clients {
+		drain(c)
+	}
+	return gs, clients
+}
+
+func TestOnlyHouseStartsGame(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	var clients [4]*Client
+	for i := range clients {
+		clients[i] = helloClient(gs)
+		seat := 3 - i // The house sits in seat 3
+		gs.HandleMessage(clients[i], ClientMessage{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: "P"})
+	}
+	gs.HandleMessage(clients[3], ClientMessage{Type: MsgStartGame})
+	if gs.State.Phase != game.PhaseLobby {
+		t.Fatal("Seat 0 started a game it doesn't own")
+	}
+	gs.HandleMessage(clients[0], ClientMessage{Type: MsgStartGame})
+	if gs.State.Phase != game.PhaseBidding {
+		t.Fatalf("House in seat 3 couldn't start the game, phase %s", gs.State.Phase)
+	}
+}
+
+func lastOfType(msgs []ServerMessage, msgType MessageType) *ServerMessage {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Type == msgType {
+			return &msgs[i]
+		}
+	}
+	return nil
+}
+
+func TestRetriedRequestIsAppliedOnce(t *testing.T) {
+	gs, clients := startedGame(t)
+	bidder := clients[gs.State.CurrentPlayer]
+	two := 2
+	bid := ClientMessage{Type: MsgPlaceBid, Amount: &two, RequestID: "bid-1"}
+
+	gs.HandleMessage(bidder, bid)
+	msgs := drain(bidder)
+	if ack := lastOfType(msgs, MsgAck); ack == nil || ack.RequestID != "bid-1" {
+		t.Fatalf("Expected ack for bid-1, got %+v", msgs)
+	}
+	if msgs[len(msgs)-1].Type != MsgAck {
+		t.Error("Ack should follow the state update it produced")
+	}
+
+	gs.HandleMessage(bidder, bid)
+	msgs = drain(bidder)
+	if len(msgs) != 1 || msgs[0].Type != MsgAck || msgs[0].RequestID != "bid-1" {
+		t.Errorf("Retry should only repeat the ack, got %+v", msgs)
+	}
+	if len(gs.State.Bids) != 1 {
+		t.Errorf("Retried bid was applied again: %d bids", len(gs.State.Bids))
+	}
+}
+
+func TestErrorEchoesRequestID(t *testing.T) {
+	gs, clients := startedGame(t)
+	waiting := clients[(gs.State.CurrentPlayer+1)%4]
+	two := 2
+
+	gs.HandleMessage(waiting, ClientMessage{Type: MsgPlaceBid, Amount: &two, RequestID: "early"})
+	msgs := drain(waiting)
+	if len(msgs) != 1 || msgs[0].Type != MsgError || msgs[0].RequestID != "early" {
+		t.Errorf("Expected one error echoing the request ID, got %+v", msgs)
+	}
+
+	// Without a requestId there is no ack
+	gs.HandleMessage(waiting, ClientMessage{Type: MsgResync})
+	if ack := lastOfType(drain(waiting), MsgAck); ack != nil {
+		t.Error("Ack sent for a message without a requestId")
+	}
+}
+
+func TestRejoinKeepsDedupeWindow(t *testing.T) {
+	gs, clients := startedGame(t)
+	seat := gs.State.CurrentPlayer
+	two := 2
+	bid := ClientMessage{Type: MsgPlaceBid, Amount: &two, RequestID: "bid-1"}
+	gs.HandleMessage(clients[seat], bid)
+
+	// Same player on a new connection retries the bid they never saw acked
+	reconnected := helloClient(gs)
+	gs.HandleMessage(reconnected, ClientMessage{Type: MsgRejoin, Token: clients[seat].Token})
+	drain(reconnected)
+	gs.HandleMessage(reconnected, bid)
+
+	msgs := drain(reconnected)
+	if len(msgs) != 1 || msgs[0].Type != MsgAck {
+		t.Errorf("Expected the original ack, got %+v", msgs)
+	}
+	if len(gs.State.Bids) != 1 {
+		t.Errorf("Retried bid was applied again: %d bids", len(gs.State.Bids))
+	}
+}
+
+func TestRequestLogWindow(t *testing.T) {
+	var l requestLog
+	now := time.Now()
+	for i := 0; i < MaxTrackedRequests+5; i++ {
+		l.record(string(rune('a'+i)), ServerMessage{Type: MsgAck}, now)
+	}
+	if len(l.entries) != MaxTrackedRequests {
+		t.Errorf("Expected %d entries, got %d", MaxTrackedRequests, len(l.entries))
+	}
+	if _, ok := l.lookup("a", now); ok {
+		t.Error("Oldest request should have been forgotten")
+	}
+	if _, ok := l.lookup(string(rune('a'+MaxTrackedRequests+4)), now.Add(RequestWindow+time.Second)); ok {
+		t.Error("Request outside the window should have expired")
+	}
+}
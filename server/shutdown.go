@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"setback/game"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown is returned for actions that would start a game while the server drains
+var ErrShuttingDown = game.NewError("shutting_down", "the server is restarting; try again shortly")
+
+// Health serves the liveness and readiness probes. The server is live as
+// long as it answers; it is ready once it accepts connections and until it
+// starts shutting down.
+type Health struct {
+	ready atomic.Bool
+}
+
+// SetReady marks the server as accepting (or no longer accepting) connections
+func (h *Health) SetReady(ready bool) {
+	h.ready.Store(ready)
+}
+
+// Ready reports whether the server accepts new connections
+func (h *Health) Ready() bool {
+	return h.ready.Load()
+}
+
+// RegisterRoutes adds /healthz and /readyz to mux
+func (h *Health) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("ok\n"))
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		if !h.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ready\n"))
+	})
+}
+
+// tableSnapshot is a table as saved on shutdown
+type tableSnapshot struct {
+	TableID string          `json:"tableId"`
+	SavedAt time.Time       `json:"savedAt"`
+	State   json.RawMessage `json:"state"` // game.MarshalSnapshot
+	History matchHistory    `json:"history"`
+	// Revoked holds the nonces of revoked session tokens; the signing key
+	// outlives the process, so without them revoked tokens would work again
+	Revoked map[string]int64 `json:"revoked,omitempty"`
+}
+
+// snapshotPath is where the table is saved in dir
+func (gs *GameServer) snapshotPath(dir string) string {
+	return filepath.Join(dir, gs.TableID+".json")
+}
+
+// SaveSnapshot writes the table (hands, session tokens and revocations
+// included) to dir, so a restarted server can pick the game up where it left off.
+// An empty table isn't saved: there's nothing to resume.
+func (gs *GameServer) SaveSnapshot(dir string) error {
+	var data []byte
+	var err error
+	gs.Hub.Do(func() {
+		if gs.State.House < 0 {
+			return
+		}
+		var state json.RawMessage
+		if state, err = game.MarshalSnapshot(gs.State); err != nil {
+			return
+		}
+		data, err = json.Marshal(tableSnapshot{
+			TableID: gs.TableID,
+			SavedAt: time.Now(),
+			State:   state,
+			History: gs.history,
+			Revoked: gs.Tokens.Revocations(),
+		})
+	})
+	if err != nil || data == nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	// Write to a temp file and rename so a crash can't leave a partial file
+	path := gs.snapshotPath(dir)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	slog.Info("Table saved", "table", gs.TableID, "path", path)
+	return nil
+}
+
+// LoadSnapshot restores the table saved in dir, if there is one, and
+// removes the file so a stale game isn't restored after a later crash.
+// Players start out disconnected and rejoin with their session tokens.
+func (gs *GameServer) LoadSnapshot(dir string) (bool, error) {
+	path := gs.snapshotPath(dir)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var snap tableSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return false, err
+	}
+	state, err := game.UnmarshalSnapshot(snap.State)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range state.Players {
+		if p != nil {
+			p.Connected = false
+		}
+	}
+
+	gs.Hub.Do(func() {
+		gs.State = state
+		gs.history = snap.History
+		gs.Tokens.RestoreRevocations(snap.Revoked)
+		gs.logger(nil).Info("Table restored", "path", path, "savedAt", snap.SavedAt)
+	})
+	return true, os.Remove(path)
+}
+
+// Drain stops new games, tells every client why, and disconnects them,
+// waiting for each connection to write what it has queued and close.
+// Connections still open when ctx is done are closed there and then,
+// and Drain returns ctx's error.
+func (gs *GameServer) Drain(ctx context.Context, reason string) error {
+	err := gs.Hub.DoContext(ctx, func() {
+		gs.draining = true
+		clients := gs.Hub.ClientList()
+		gs.logger(nil).Info("Draining table", "clients", len(clients))
+		for _, c := range clients {
+			gs.Hub.SendToClient(c, NewNoticeMessage(reason))
+		}
+		// Queued messages (the notice included) are written before each connection closes
+		for _, c := range clients {
+			gs.Hub.Disconnect(c)
+		}
+	})
+	if err == nil {
+		err = gs.Hub.pumps.wait(ctx)
+	}
+	if err != nil {
+		if n := gs.Hub.pumps.closeAll(); n > 0 {
+			slog.Warn("Closed connections that did not drain in time", "table", gs.TableID, "clients", n)
+		}
+	}
+	return err
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	health := &Health{}
+	mux := http.NewServeMux()
+	health.RegisterRoutes(mux)
+
+	status := func(path string) int {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec.Code
+	}
+
+	if status("/healthz") != http.StatusOK {
+		t.Error("Server should always be live")
+	}
+	if status("/readyz") != http.StatusServiceUnavailable {
+		t.Error("Server should not be ready before it accepts connections")
+	}
+	health.SetReady(true)
+	if status("/readyz") != http.StatusOK {
+		t.Error("Server should be ready")
+	}
+	health.SetReady(false)
+	if status("/readyz") != http.StatusServiceUnavailable {
+		t.Error("Server should not be ready while shutting down")
+	}
+}
+
+func TestDrainNotifiesAndDisconnects(t *testing.T) {
+	gs, clients, _ := adminGame(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := gs.Drain(ctx, "Restarting"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if notice := lastOfType(waitClosed(t, c), MsgNotice); notice == nil || notice.Notice != "Restarting" {
+			t.Error("Each client should be told the server is restarting")
+		}
+	}
+
+	// No new games while draining
+	late := helloClient(gs)
+	gs.HandleMessage(late, ClientMessage{Type: MsgStartGame})
+	if errMsg := lastOfType(drain(late), MsgError); errMsg == nil || errMsg.Error.Code != string(ErrShuttingDown.Code) {
+		t.Error("Starting a game while draining should fail")
+	}
+}
+
+// stuckConn is a connection whose writes hang until it is closed
+type stuckConn struct {
+	*fakeConn
+}
+
+func (s stuckConn) WriteMessage(int, []byte) error {
+	<-s.done
+	return net.ErrClosed
+}
+
+// pumpedClient registers a client on conn with its pumps running
+func pumpedClient(gs *GameServer, conn Conn) *Client {
+	client := newTestClient(gs.Hub, conn)
+	register(gs.Hub, client)
+	client.Start()
+	return client
+}
+
+func TestDrainWaitsForConnectionsToClose(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	go gs.Run()
+	conns := []*fakeConn{newFakeConn(), newFakeConn()}
+	for _, conn := range conns {
+		pumpedClient(gs, conn)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := gs.Drain(ctx, "Restarting"); err != nil {
+		t.Fatal(err)
+	}
+	// Drain returned, so everything queued has been written
+	for i, conn := range conns {
+		if notice := lastOfType(conn.sent(), MsgNotice); notice == nil || conn.countWritten(websocket.CloseMessage) != 1 {
+			t.Errorf("Connection %d should be sent the notice and closed", i)
+		}
+	}
+}
+
+func TestDrainClosesStragglers(t *testing.T) {
+	gs := NewGameServer(NewHub(), 21)
+	go gs.Run()
+	quick, stuck := newFakeConn(), stuckConn{newFakeConn()}
+	pumpedClient(gs, quick)
+	pumpedClient(gs, stuck)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := gs.Drain(ctx, "Restarting"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the drain to time out, got %v", err)
+	}
+	if lastOfType(quick.sent(), MsgNotice) == nil {
+		t.Error("The quick connection should get the notice")
+	}
+	stuck.mu.Lock()
+	closed := stuck.closed
+	stuck.mu.Unlock()
+	if !closed {
+		t.Error("The stuck connection should be closed when the drain times out")
+	}
+
+	wait, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := gs.Hub.pumps.wait(wait); err != nil {
+		t.Error("Closing the stuck connection should stop its pumps")
+	}
+}
+
+func TestSnapshotRestoresGame(t *testing.T) {
+	gs, clients := startedGame(t)
+	two := 2
+	bidder := gs.State.CurrentPlayer
+	gs.HandleMessage(clients[bidder], ClientMessage{Type: MsgPlaceBid, Amount: &two})
+	token := clients[bidder].Token
+	hand := gs.State.Players[bidder].Hand
+
+	dir := t.TempDir()
+	if err := gs.SaveSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new server process: same token key, empty table
+	restarted := NewGameServer(NewHub(), 21)
+	restarted.Tokens = gs.Tokens
+	if ok, err := restarted.LoadSnapshot(dir); err != nil || !ok {
+		t.Fatalf("LoadSnapshot = %v, %v", ok, err)
+	}
+	if restarted.State.Phase != gs.State.Phase || len(restarted.State.Bids) != 1 {
+		t.Errorf("Restored phase %s with %d bids", restarted.State.Phase, len(restarted.State.Bids))
+	}
+	for _, p := range restarted.State.Players {
+		if p.Connected {
+			t.Error("Restored players should start out disconnected")
+		}
+	}
+	if _, err := os.Stat(restarted.snapshotPath(dir)); !os.IsNotExist(err) {
+		t.Error("Snapshot should be removed once restored")
+	}
+	if ok, _ := restarted.LoadSnapshot(dir); ok {
+		t.Error("Nothing should be restored twice")
+	}
+
+	// The player rejoins their seat with the token from before the restart
+	client := helloClient(restarted)
+	restarted.HandleMessage(client, ClientMessage{Type: MsgRejoin, Token: token})
+	if client.SeatIndex != bidder {
+		t.Fatalf("Rejoin after restart put the client in seat %d, want %d", client.SeatIndex, bidder)
+	}
+	if !reflect.DeepEqual(restarted.State.Players[bidder].Hand, hand) {
+		t.Error("Rejoined player should get their hand back")
+	}
+}
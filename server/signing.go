@@ -0,0 +1,82 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBadSignature is returned when a signed value has been tampered with
+var ErrBadSignature = errors.New("invalid signature")
+
+// Signer signs and verifies values with HMAC-SHA256
+type Signer struct {
+	key []byte
+}
+
+// NewSigner creates a signer with the given secret key
+func NewSigner(key []byte) *Signer {
+	return &Signer{key: key}
+}
+
+// Sign returns payload and its signature as "payload.signature" (base64url)
+func (s *Signer) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a value produced by Sign and returns its payload
+func (s *Signer) Verify(signed string) ([]byte, error) {
+	encPayload, encSig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return nil, ErrBadSignature
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encSig)
+	if err != nil {
+		return nil, ErrBadSignature
+	}
+
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrBadSignature
+	}
+	return payload, nil
+}
+
+// LoadOrCreateKey reads a hex-encoded secret key from path,
+// generating and saving a new one if the file doesn't exist.
+// Keeping the key on disk means signed sessions survive a restart.
+func LoadOrCreateKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return hex.DecodeString(strings.TrimSpace(string(data)))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)), 0o600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
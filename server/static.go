@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// Cache policies for the web client. Card images rarely change; everything
+// else keeps its URL across releases, so browsers revalidate it (cheaply,
+// with the ETag) before each use.
+const (
+	cacheRevalidate = "no-cache"
+	cacheCards      = "public, max-age=86400"
+	cacheNone       = "no-store"
+)
+
+// StaticHandler serves the web client with ETags and cache headers
+type StaticHandler struct {
+	files   http.Handler
+	etags   map[string]string // by path without the leading slash
+	noCache bool
+}
+
+// NewStaticHandler serves files, hashing each one once for its ETag.
+// Use it for the embedded client, which can't change while the server runs.
+func NewStaticHandler(files fs.FS) (*StaticHandler, error) {
+	h := &StaticHandler{
+		files: http.FileServerFS(files),
+		etags: make(map[string]string),
+	}
+	err := fs.WalkDir(files, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(data)
+		h.etags[name] = `"` + base64.RawURLEncoding.EncodeToString(sum[:12]) + `"`
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// NewDevStaticHandler serves the client from dir on disk, uncached, so
+// frontend edits show up on reload
+func NewDevStaticHandler(dir string) *StaticHandler {
+	return &StaticHandler{files: http.FileServer(http.Dir(dir)), noCache: true}
+}
+
+// ServeHTTP sets the cache headers and serves the file. The file server
+// answers If-None-Match with 304 Not Modified when the ETag matches.
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.noCache {
+		w.Header().Set("Cache-Control", cacheNone)
+		h.files.ServeHTTP(w, r)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" || strings.HasSuffix(r.URL.Path, "/") {
+		name = path.Join(name, "index.html")
+	}
+	if etag, ok := h.etags[name]; ok {
+		w.Header().Set("ETag", etag)
+		if strings.HasPrefix(name, "cards/") {
+			w.Header().Set("Cache-Control", cacheCards)
+		} else {
+			w.Header().Set("Cache-Control", cacheRevalidate)
+		}
+	}
+	h.files.ServeHTTP(w, r)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"setback/static"
+	"testing"
+	"testing/fstest"
+)
+
+func serveStatic(h http.Handler, path, etag string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestStaticHandlerETags(t *testing.T) {
+	files := fstest.MapFS{
+		"index.html":    {Data: []byte("<html>")},
+		"app.js":        {Data: []byte("let x")},
+		"cards/A_S.svg": {Data: []byte("<svg>")},
+		"cards/K_S.svg": {Data: []byte("<svg/>")},
+		"cards/Q_S.svg": {Data: []byte("<svg>")},
+		"protocol.d.ts": {Data: []byte("type X")},
+	}
+	h, err := NewStaticHandler(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec := serveStatic(h, "/app.js", "")
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" || rec.Body.String() != "let x" {
+		t.Fatalf("GET /app.js = %d, ETag %q, body %q", rec.Code, etag, rec.Body)
+	}
+	if cc := rec.Header().Get("Cache-Control"); cc != cacheRevalidate {
+		t.Errorf("app.js Cache-Control = %q, want %q", cc, cacheRevalidate)
+	}
+	if rec := serveStatic(h, "/app.js", etag); rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("Matching If-None-Match = %d, want 304 with no body", rec.Code)
+	}
+	if rec := serveStatic(h, "/app.js", `"stale"`); rec.Code != http.StatusOK {
+		t.Errorf("Stale If-None-Match = %d, want 200", rec.Code)
+	}
+
+	// The index is served for / with its own ETag
+	index := serveStatic(h, "/", "")
+	if index.Code != http.StatusOK || index.Header().Get("ETag") == "" || index.Header().Get("ETag") == etag {
+		t.Errorf("GET / = %d, ETag %q", index.Code, index.Header().Get("ETag"))
+	}
+
+	// ETags follow content: identical files share one, different files don't
+	ace, king, queen := serveStatic(h, "/cards/A_S.svg", ""), serveStatic(h, "/cards/K_S.svg", ""), serveStatic(h, "/cards/Q_S.svg", "")
+	if ace.Header().Get("ETag") != queen.Header().Get("ETag") || ace.Header().Get("ETag") == king.Header().Get("ETag") {
+		t.Error("ETags should be derived from file contents")
+	}
+	if cc := ace.Header().Get("Cache-Control"); cc != cacheCards {
+		t.Errorf("Card Cache-Control = %q, want %q", cc, cacheCards)
+	}
+
+	if rec := serveStatic(h, "/missing.js", ""); rec.Code != http.StatusNotFound || rec.Header().Get("ETag") != "" {
+		t.Errorf("GET /missing.js = %d, ETag %q", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestEmbeddedClient(t *testing.T) {
+	h, err := NewStaticHandler(static.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/", "/app.js", "/style.css", "/protocol.d.ts", "/cards/ace_spades.svg"} {
+		if rec := serveStatic(h, path, ""); rec.Code != http.StatusOK || rec.Body.Len() == 0 {
+			t.Errorf("Embedded %s = %d (%d bytes)", path, rec.Code, rec.Body.Len())
+		}
+	}
+	if rec := serveStatic(h, "/embed.go", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Go source should not be embedded, got %d", rec.Code)
+	}
+}
+
+func TestDevStaticHandler(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.js")
+	os.WriteFile(path, []byte("v1"), 0o600)
+	h := NewDevStaticHandler(dir)
+
+	rec := serveStatic(h, "/app.js", "")
+	if rec.Body.String() != "v1" || rec.Header().Get("Cache-Control") != cacheNone {
+		t.Errorf("GET /app.js = %q, Cache-Control %q", rec.Body, rec.Header().Get("Cache-Control"))
+	}
+	// Edits are served straight away
+	os.WriteFile(path, []byte("v2"), 0o600)
+	if rec := serveStatic(h, "/app.js", ""); rec.Body.String() != "v2" {
+		t.Errorf("Edited file served as %q", rec.Body)
+	}
+}
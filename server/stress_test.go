@@ -0,0 +1,345 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"setback/game"
+)
+
+// These tests drive a table from many goroutines at once. Run them with
+// -race: they pass without it, but what they look for are data races.
+
+// stressTable is a running table with limits loose enough for a flood
+func stressTable(t *testing.T) *GameServer {
+	t.Helper()
+	hub := NewHub()
+	unlimited := RateLimit{Rate: 1e6, Burst: 1e6}
+	hub.RateLimits = RateLimitConfig{Overall: unlimited, Default: unlimited, Strikes: unlimited}
+	hub.MaxConnsPerIP = 0
+	gs := NewGameServer(hub, 11)
+	go gs.Run()
+	return gs
+}
+
+// connect opens a client the way the /ws handler does, pumps included
+func connect(gs *GameServer, ip string) (*Client, *fakeConn) {
+	conn := newFakeConn()
+	gs.Hub.AcquireIP(ip)
+	client := NewClient(gs.Hub, conn, ip, "")
+	gs.Hub.Register <- client
+	client.Start()
+	gs.SendState(client)
+	return client, conn
+}
+
+// say sends a message over the fake connection, reporting false once the
+// connection is closed
+func say(conn *fakeConn, msg ClientMessage) bool {
+	data, _ := json.Marshal(msg)
+	select {
+	case conn.incoming <- data:
+		return true
+	case <-conn.done:
+		return false
+	}
+}
+
+// hint is what a player can see of the table, read on the hub goroutine
+type hint struct {
+	seat  int
+	phase game.Phase
+	turn  bool
+	hand  []string
+}
+
+func peek(gs *GameServer, client *Client) hint {
+	var h hint
+	gs.Hub.Do(func() {
+		h.seat = client.SeatIndex
+		h.phase = gs.State.Phase
+		h.turn = gs.State.CurrentPlayer == h.seat
+		if h.seat >= 0 && gs.State.Players[h.seat] != nil {
+			for _, c := range gs.State.Players[h.seat].Hand {
+				h.hand = append(h.hand, c.ID)
+			}
+		}
+	})
+	return h
+}
+
+// checkSeats verifies the hub's seats agree with its clients and the game
+func checkSeats(t *testing.T, gs *GameServer) {
+	t.Helper()
+	gs.Hub.Do(func() {
+		for i, c := range gs.Hub.seats {
+			if c == nil {
+				continue
+			}
+			if c.SeatIndex != i || !gs.Hub.clients[c] || c.closed {
+				t.Errorf("Seat %d holds client %d (seat %d, registered %v, closed %v)",
+					i, c.ID, c.SeatIndex, gs.Hub.clients[c], c.closed)
+			}
+			if gs.State.Players[i] == nil {
+				t.Errorf("Seat %d has a client but no player", i)
+			}
+		}
+	})
+}
+
+// playTurns plays whatever the hint suggests, legal or not, until stop closes
+func playTurns(gs *GameServer, client *Client, conn *fakeConn, seat int, rng *rand.Rand, stop <-chan struct{}) {
+	pass, two := 0, 2
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		h := peek(gs, client)
+		var msgs []ClientMessage
+		switch {
+		case h.seat < 0:
+			msgs = []ClientMessage{{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: fmt.Sprint("P", seat)}}
+		case h.phase == game.PhaseLobby:
+			msgs = []ClientMessage{{Type: MsgStartGame}}
+		case h.phase == game.PhaseBidding && h.turn:
+			msgs = []ClientMessage{{Type: MsgPlaceBid, Amount: []*int{&pass, &two}[rng.IntN(2)]}}
+		case h.phase == game.PhaseKitty:
+			msgs = []ClientMessage{
+				{Type: MsgSelectTrump, TrumpSuit: "spades"},
+				{Type: MsgTakeKitty, CardIDs: []string{}},
+				{Type: MsgDiscard, CardIDs: []string{}},
+			}
+		case h.phase == game.PhaseDiscard:
+			msgs = []ClientMessage{{Type: MsgDiscardDraw, CardIDs: []string{}}}
+		case h.phase == game.PhasePlaying && h.turn && len(h.hand) > 0:
+			msgs = []ClientMessage{{Type: MsgPlayCard, CardID: h.hand[rng.IntN(len(h.hand))]}}
+		case h.phase == game.PhaseScoring || h.phase == game.PhaseFinished:
+			msgs = []ClientMessage{{Type: MsgNewHand}}
+		}
+		if len(msgs) == 0 {
+			time.Sleep(time.Millisecond) // Not our turn
+		}
+		for _, msg := range msgs {
+			if !say(conn, msg) {
+				return
+			}
+		}
+	}
+}
+
+// heckle sends a random mix of table actions, then hangs up
+func heckle(conn *fakeConn, rng *rand.Rand, n int) {
+	muted, enabled := true, false
+	for i := 0; i < n; i++ {
+		seat := rng.IntN(4)
+		msgs := []ClientMessage{
+			{Type: MsgJoinTable, SeatIndex: &seat, PlayerName: "Heckler"},
+			{Type: MsgLeaveSeat},
+			{Type: MsgChangeName, PlayerName: "Renamed"},
+			{Type: MsgChat, Text: "hi", Channel: ChatTable},
+			{Type: MsgChat, Text: "psst", Channel: ChatTeam},
+			{Type: MsgSendEmote, Emote: Emotes[rng.IntN(len(Emotes))]},
+			{Type: MsgResync},
+			{Type: MsgKickPlayer, SeatIndex: &seat},
+			{Type: MsgTransferHouse, SeatIndex: &seat},
+			{Type: MsgMutePlayer, SeatIndex: &seat, Muted: &muted},
+			{Type: MsgSetEmotes, Enabled: &enabled},
+			{Type: MsgRejoin, Token: "stale"},
+			{Type: MsgStartGame},
+			{Type: "bogus"},
+		}
+		if !say(conn, msgs[rng.IntN(len(msgs))]) {
+			return
+		}
+		time.Sleep(time.Duration(rng.IntN(2000)) * time.Microsecond)
+	}
+	conn.Close()
+}
+
+func TestStressConcurrentClients(t *testing.T) {
+	gs := stressTable(t)
+	runFor := time.Second
+	if testing.Short() {
+		runFor = 200 * time.Millisecond
+	}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	var conns []*fakeConn
+	var connsMu sync.Mutex
+	track := func(conn *fakeConn) {
+		connsMu.Lock()
+		conns = append(conns, conn)
+		connsMu.Unlock()
+	}
+
+	// Four players who keep trying to play
+	for seat := 0; seat < 4; seat++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client, conn := connect(gs, fmt.Sprintf("10.0.0.%d", seat))
+			track(conn)
+			say(conn, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion, Features: []string{FeatureDelta}})
+			playTurns(gs, client, conn, seat, rand.New(rand.NewPCG(uint64(seat), 1)), stop)
+		}()
+	}
+
+	// Once the players are seated, spectators and hecklers come and go
+	for seated := 0; seated < 4; {
+		time.Sleep(time.Millisecond)
+		gs.Hub.Do(func() {
+			seated = 0
+			for _, c := range gs.Hub.seats {
+				if c != nil {
+					seated++
+				}
+			}
+		})
+	}
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(i), 2))
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				_, conn := connect(gs, fmt.Sprintf("10.1.0.%d", i%6))
+				track(conn)
+				if rng.IntN(4) > 0 {
+					say(conn, ClientMessage{Type: MsgHello, ProtocolVersion: ProtocolVersion})
+				}
+				heckle(conn, rng, 20+rng.IntN(60))
+			}
+		}()
+	}
+
+	// Operators, scrapers and the API reading the table meanwhile
+	admin := http.NewServeMux()
+	NewAdmin(testAdminToken, gs).RegisterRoutes(admin)
+	NewAPI(gs).RegisterRoutes(admin)
+	metrics := MetricsHandler(gs)
+	dir := t.TempDir()
+	readers := []func(){
+		func() { gs.Summary() },
+		func() { gs.clientInfo() },
+		func() {
+			metrics.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		},
+		func() { getJSON(t, admin, "/api/tables/main/state", nil) },
+		func() { getJSON(t, admin, "/api/tables/main/hands", nil) },
+		func() { adminRequest(t, admin, http.MethodGet, "/api/admin/clients", nil, nil) },
+		func() {
+			adminRequest(t, admin, http.MethodPost, "/api/admin/notice", noticeRequest{Text: "Hello"}, nil)
+		},
+		func() { gs.SetTargetScore(11) },
+		func() { gs.SaveSnapshot(dir) },
+		func() { gs.Hub.IsBanned("10.1.0.1") },
+		func() { checkSeats(t, gs) },
+	}
+	for i, read := range readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Duration(10+i) * time.Millisecond):
+					read()
+				}
+			}
+		}()
+	}
+
+	time.Sleep(runFor)
+	close(stop)
+	wg.Wait()
+
+	// Hang everyone up; the table should let go of all of them
+	connsMu.Lock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	connsMu.Unlock()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var clients, ips int
+		gs.Hub.Do(func() { clients, ips = len(gs.Hub.clients), len(gs.Hub.ipConns) })
+		if clients == 0 && ips == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients and %d IP slots left after everyone hung up", clients, ips)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	checkSeats(t, gs)
+	gs.Hub.Do(func() {
+		for i, p := range gs.State.Players {
+			if p != nil && p.Connected {
+				t.Errorf("Seat %d is still marked connected", i)
+			}
+		}
+		if gs.Hub.seats != [4]*Client{} {
+			t.Errorf("Seats still held: %v", gs.Hub.seats)
+		}
+	})
+	m := gs.Hub.Metrics
+	m.mu.Lock()
+	t.Logf("%d connections, %d hands scored", len(conns), m.hands)
+	m.mu.Unlock()
+}
+
+// Sends, broadcasts and disconnects racing on the same clients must never
+// send on a closed channel or touch a client the hub has let go
+func TestStressBroadcastWhileDisconnecting(t *testing.T) {
+	gs := stressTable(t)
+	var wg sync.WaitGroup
+	for round := 0; round < 20; round++ {
+		clients := make([]*Client, 10)
+		for i := range clients {
+			clients[i], _ = connect(gs, "10.2.0.1")
+		}
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			for _, c := range clients {
+				gs.Hub.Do(func() { gs.Hub.Disconnect(c) })
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range clients {
+				gs.Hub.Do(func() { gs.Hub.BroadcastMessage(NewNoticeMessage("round")) })
+				gs.AdminReset()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for _, c := range clients {
+				gs.SendState(c)
+				gs.Hub.Unregister <- c // As ReadPump does when its connection ends
+			}
+		}()
+		wg.Wait()
+		for _, c := range clients {
+			waitClosed(t, c)
+		}
+	}
+	if n := len(gs.clientInfo()); n != 0 {
+		t.Errorf("%d clients still registered", n)
+	}
+}
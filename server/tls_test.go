@@ -0,0 +1,175 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// writeCert writes a self-signed certificate for 127.0.0.1 with the given
+// serial number to certFile and keyFile
+func writeCert(t *testing.T, certFile, keyFile string, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: "setback test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestWebSocketOverTLSAndReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeCert(t, certFile, keyFile, 1)
+
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgrader := websocket.Upgrader{CheckOrigin: NewOriginPolicy(nil, false).Check}
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	})}
+	// Like ListenAndServeTLS("", "") in main: certificates come from TLSConfig only
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(tls.NewListener(ln, certs.TLSConfig()))
+	defer srv.Close()
+	origin := "https://" + ln.Addr().String()
+
+	// dial connects over wss:// trusting only cert, and returns the certificate the server presented
+	dial := func(cert *x509.Certificate) (*x509.Certificate, error) {
+		roots := x509.NewCertPool()
+		roots.AddCert(cert)
+		dialer := websocket.Dialer{TLSClientConfig: &tls.Config{RootCAs: roots}}
+		conn, _, err := dialer.Dial("wss://"+ln.Addr().String()+"/ws", http.Header{"Origin": {origin}})
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		if _, msg, err := conn.ReadMessage(); err != nil || string(msg) != "hello" {
+			t.Errorf("ReadMessage = %q, %v", msg, err)
+		}
+		return conn.UnderlyingConn().(*tls.Conn).ConnectionState().PeerCertificates[0], nil
+	}
+
+	if got, err := dial(first); err != nil || got.SerialNumber.Int64() != 1 {
+		t.Fatalf("wss:// with the first certificate: %v", err)
+	}
+
+	// A broken renewal keeps the working certificate
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	if err := certs.Reload(); err == nil {
+		t.Error("Reloading a bad key should fail")
+	}
+	if _, err := dial(first); err != nil {
+		t.Errorf("Failed reload should keep the old certificate: %v", err)
+	}
+
+	second := writeCert(t, certFile, keyFile, 2)
+	if err := certs.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := dial(second); err != nil || got.SerialNumber.Int64() != 2 {
+		t.Errorf("New connections should get the reloaded certificate: %v", err)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		httpsAddr string
+		host      string
+		path      string
+		want      string
+	}{
+		{":443", "cards.example.com", "/", "https://cards.example.com/"},
+		{":443", "cards.example.com:80", "/ws?x=1", "https://cards.example.com/ws?x=1"},
+		{":8443", "cards.example.com:8080", "/app.js", "https://cards.example.com:8443/app.js"},
+		{"0.0.0.0:8443", "127.0.0.1", "/", "https://127.0.0.1:8443/"},
+		{":443", "[::1]:80", "/", "https://[::1]/"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		RedirectHandler(tt.httpsAddr).ServeHTTP(rec, req)
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.want {
+			t.Errorf("%s%s via %s: %d %q, want %q", tt.host, tt.path, tt.httpsAddr, rec.Code, rec.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCert(t, certFile, keyFile, 1)
+
+	cfg := DefaultConfig()
+	cfg.TLS = TLSSettings{Cert: certFile, Key: keyFile, Redirect: ":80"}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Valid TLS config rejected: %v", err)
+	}
+
+	cfg.TLS = TLSSettings{Cert: certFile, Key: certFile, Redirect: ":8080"}
+	err := cfg.Validate()
+	for _, want := range []string{"tls: ", `tls.redirect: ":8080" is also the HTTPS listen address`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to mention %q", err, want)
+		}
+	}
+
+	cfg.TLS = TLSSettings{Redirect: ":80"}
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "tls.redirect: needs tls.cert and tls.key") {
+		t.Errorf("Redirect without TLS: %v", err)
+	}
+}
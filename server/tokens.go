@@ -0,0 +1,152 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+)
+
+// SeatTokenLifetime is how long a seat token stays valid without a rejoin
+const SeatTokenLifetime = 24 * time.Hour
+
+// Token errors
+var (
+	ErrTokenInvalid = errors.New("invalid session token")
+	ErrTokenExpired = errors.New("session token expired")
+	ErrTokenRevoked = errors.New("session token revoked")
+)
+
+// SeatToken is the signed content of a session token
+type SeatToken struct {
+	TableID string `json:"t"`
+	Seat    int    `json:"s"`
+	Expires int64  `json:"e"` // Unix seconds
+	Nonce   string `json:"n"` // Makes each token unique; used for revocation
+}
+
+// TokenIssuer issues and validates signed seat tokens
+type TokenIssuer struct {
+	signer   *Signer
+	lifetime time.Duration
+	revoked  map[string]int64 // Nonce -> expiry, kept until the token would expire anyway
+	mu       sync.Mutex
+}
+
+// NewTokenIssuer creates a token issuer with the given secret key
+func NewTokenIssuer(key []byte) *TokenIssuer {
+	return &TokenIssuer{
+		signer:   NewSigner(key),
+		lifetime: SeatTokenLifetime,
+		revoked:  make(map[string]int64),
+	}
+}
+
+// newRandomKey creates a random signing key
+func newRandomKey() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// Issue creates a signed token for a seat at a table
+func (ti *TokenIssuer) Issue(tableID string, seat int) string {
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+
+	payload, _ := json.Marshal(SeatToken{
+		TableID: tableID,
+		Seat:    seat,
+		Expires: time.Now().Add(ti.lifetime).Unix(),
+		Nonce:   hex.EncodeToString(nonce),
+	})
+	return ti.signer.Sign(payload)
+}
+
+// Validate checks a token's signature, expiry and revocation
+func (ti *TokenIssuer) Validate(token string) (SeatToken, error) {
+	var st SeatToken
+
+	payload, err := ti.signer.Verify(token)
+	if err != nil {
+		return st, ErrTokenInvalid
+	}
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return st, ErrTokenInvalid
+	}
+	if time.Now().Unix() > st.Expires {
+		return st, ErrTokenExpired
+	}
+
+	ti.mu.Lock()
+	_, revoked := ti.revoked[st.Nonce]
+	ti.mu.Unlock()
+	if revoked {
+		return st, ErrTokenRevoked
+	}
+	return st, nil
+}
+
+// Revoke invalidates a token before it expires
+func (ti *TokenIssuer) Revoke(token string) {
+	payload, err := ti.signer.Verify(token)
+	if err != nil {
+		return
+	}
+	var st SeatToken
+	if err := json.Unmarshal(payload, &st); err != nil {
+		return
+	}
+
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	// Forget revocations for tokens that have expired on their own
+	now := time.Now().Unix()
+	for nonce, expires := range ti.revoked {
+		if now > expires {
+			delete(ti.revoked, nonce)
+		}
+	}
+	ti.revoked[st.Nonce] = st.Expires
+}
+
+// Revocations returns the nonces of revoked tokens that haven't expired yet,
+// with their expiry, so they can be saved across a restart
+func (ti *TokenIssuer) Revocations() map[string]int64 {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	now := time.Now().Unix()
+	revoked := make(map[string]int64, len(ti.revoked))
+	for nonce, expires := range ti.revoked {
+		if now <= expires {
+			revoked[nonce] = expires
+		}
+	}
+	return revoked
+}
+
+// RestoreRevocations revokes the tokens saved by Revocations
+func (ti *TokenIssuer) RestoreRevocations(revoked map[string]int64) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	for nonce, expires := range revoked {
+		ti.revoked[nonce] = expires
+	}
+}
+
+// setRevocations replaces the revoked tokens with ones saved by Revocations,
+// undoing any revoked since
+func (ti *TokenIssuer) setRevocations(revoked map[string]int64) {
+	ti.mu.Lock()
+	defer ti.mu.Unlock()
+
+	ti.revoked = make(map[string]int64, len(revoked))
+	for nonce, expires := range revoked {
+		ti.revoked[nonce] = expires
+	}
+}
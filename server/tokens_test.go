@@ -0,0 +1,137 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenIssuer(t *testing.T) {
+	ti := NewTokenIssuer(newRandomKey())
+	token := ti.Issue("main", 2)
+
+	st, err := ti.Validate(token)
+	if err != nil || st.TableID != "main" || st.Seat != 2 {
+		t.Fatalf("Validate = %+v, %v", st, err)
+	}
+	if other := ti.Issue("main", 2); other == token {
+		t.Error("Each token should be unique")
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	forged := NewTokenIssuer(newRandomKey()).Issue("main", 2)
+	forgedPayload, _, _ := strings.Cut(forged, ".")
+	for name, bad := range map[string]string{
+		"empty":       "",
+		"unsigned":    payload,
+		"other key":   forged,
+		"swapped sig": forgedPayload + "." + sig,
+	} {
+		if _, err := ti.Validate(bad); !errors.Is(err, ErrTokenInvalid) {
+			t.Errorf("%s: expected invalid, got %v", name, err)
+		}
+	}
+
+	ti.Revoke(token)
+	if _, err := ti.Validate(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Expected revoked, got %v", err)
+	}
+
+	ti.lifetime = -time.Second
+	if _, err := ti.Validate(ti.Issue("main", 0)); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("Expected expired, got %v", err)
+	}
+}
+
+func TestRevocationsSurviveRestart(t *testing.T) {
+	key := newRandomKey()
+	ti := NewTokenIssuer(key)
+	revoked, kept := ti.Issue("main", 0), ti.Issue("main", 1)
+	ti.Revoke(revoked)
+
+	// A new process with the same key
+	restarted := NewTokenIssuer(key)
+	restarted.RestoreRevocations(ti.Revocations())
+	if _, err := restarted.Validate(revoked); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Revoked token should stay revoked after a restart, got %v", err)
+	}
+	if _, err := restarted.Validate(kept); err != nil {
+		t.Errorf("Other tokens should still work, got %v", err)
+	}
+}
+
+func TestSnapshotKeepsRevocations(t *testing.T) {
+	gs, clients := startedGame(t)
+	seat := gs.State.CurrentPlayer
+	stale := clients[seat].Token
+	gs.Tokens.Revoke(stale)
+
+	dir := t.TempDir()
+	if err := gs.SaveSnapshot(dir); err != nil {
+		t.Fatal(err)
+	}
+	restarted := NewGameServer(NewHub(), 21)
+	restarted.Tokens = NewTokenIssuer(gs.Tokens.signer.key)
+	if ok, err := restarted.LoadSnapshot(dir); err != nil || !ok {
+		t.Fatalf("LoadSnapshot = %v, %v", ok, err)
+	}
+	if _, err := restarted.Tokens.Validate(stale); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("Revocation should be restored with the table, got %v", err)
+	}
+}
+
+func TestRejoinRejectsBadTokens(t *testing.T) {
+	gs, clients := startedGame(t)
+	seat := gs.State.CurrentPlayer
+	token := clients[seat].Token
+
+	payload, _, _ := strings.Cut(token, ".")
+	expiring := NewTokenIssuer(gs.Tokens.signer.key)
+	expiring.lifetime = -time.Second
+	expired := expiring.Issue(gs.TableID, seat)
+	gs.State.Players[seat].SessionToken = expired
+
+	for name, bad := range map[string]string{
+		"tampered":     payload + ".AAAA",
+		"expired":      expired,
+		"other table":  gs.Tokens.Issue("other", seat),
+		"not the seat": gs.Tokens.Issue(gs.TableID, (seat+1)%4),
+	} {
+		client := helloClient(gs)
+		gs.HandleMessage(client, ClientMessage{Type: MsgRejoin, Token: bad})
+		reply := lastOfType(drain(client), MsgError)
+		if client.SeatIndex != -1 || reply == nil || reply.Error.Code != string(ErrRejoinFailed.Code) {
+			t.Errorf("%s: expected rejoin_failed, got seat %d, %+v", name, client.SeatIndex, reply)
+		}
+	}
+}
+
+func TestTokenRejoinTakesOverSeat(t *testing.T) {
+	gs, clients := startedGame(t)
+	startPlaying(t, gs, clients)
+	seat := gs.State.CurrentPlayer
+	old := clients[seat]
+	token := old.Token
+
+	device := helloClient(gs)
+	gs.HandleMessage(device, ClientMessage{Type: MsgRejoin, Token: token})
+	if device.SeatIndex != seat || old.SeatIndex != -1 {
+		t.Fatalf("Expected the rejoin to move seat %d to the new connection", seat)
+	}
+	if _, err := gs.Tokens.Validate(token); !errors.Is(err, ErrTokenRevoked) {
+		t.Errorf("The token used to rejoin should be revoked, got %v", err)
+	}
+
+	card := gs.State.Players[seat].Hand[0].ID
+	gs.HandleMessage(old, ClientMessage{Type: MsgPlayCard, CardID: card})
+	if len(gs.State.Players[seat].Hand) != 6 {
+		t.Fatal("The old connection played a card for the seat")
+	}
+
+	// Nor can it take the seat back with its old token
+	gs.HandleMessage(old, ClientMessage{Type: MsgRejoin, Token: token})
+	if old.SeatIndex != -1 || gs.Hub.GetClientBySeat(seat) != device {
+		t.Error("The replaced connection rejoined with a revoked token")
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocketHandler serves the /ws endpoint: it turns away connections while
+// the server isn't ready, from banned IPs or over the per-IP limit, then
+// registers the connection with the table and sends it the state.
+// accounts signs players in from their session cookie; nil allows guests only.
+func WebSocketHandler(gs *GameServer, upgrader websocket.Upgrader, health *Health, accounts *AccountStore) http.HandlerFunc {
+	hub := gs.Hub
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			ip = r.RemoteAddr
+		}
+		if !health.Ready() {
+			http.Error(w, "Server is restarting", http.StatusServiceUnavailable)
+			return
+		}
+		if hub.IsBanned(ip) {
+			slog.Info("Rejected connection: banned", "ip", ip)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if !hub.AcquireIP(ip) {
+			slog.Info("Rejected connection: too many connections", "ip", ip)
+			http.Error(w, "Too many connections", http.StatusTooManyRequests)
+			return
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			hub.ReleaseIP(ip)
+			slog.Info("WebSocket upgrade failed", "ip", ip, "err", err)
+			return
+		}
+
+		account := ""
+		if accounts != nil {
+			account = accounts.SessionAccount(r)
+		}
+		client := NewClient(hub, conn, ip, account)
+		hub.Register <- client
+
+		client.Start()
+
+		// Send initial state
+		gs.SendState(client)
+	}
+}
@@ -1,4 +1,9 @@
 // Setback Game Client
+// Message shapes are in protocol.d.ts, generated from server/protocol.go
+
+// Protocol version and optional features this client understands
+const PROTOCOL_VERSION = 1;
+const CLIENT_FEATURES = ['chat', 'emotes', 'accounts', 'delta', 'acks'];
 
 class SetbackGame {
     constructor() {
@@ -14,6 +19,14 @@ class SetbackGame {
         this.selectedDiscards = new Set();      // For kitty phase (bid winner)
         this.selectedDrawDiscards = new Set();  // For discard phase (all players)
         this.editingName = false;               // Whether name input is showing
+        this.account = null;                    // Registered username, null for guests
+        this.quietReconnect = false;            // Reconnecting on purpose (e.g. after login)
+        this.serverInfo = null;                 // Server version, features and rules from 'welcome'
+        this.lastStateMsg = null;               // Last full stateUpdate, base for applying deltas
+        this.seq = 0;                           // Sequence number of lastStateMsg
+        this.pendingActions = new Map();        // Unacknowledged game actions by requestId, re-sent after reconnecting
+        this.nextRequestId = 0;
+        this.requestPrefix = Math.random().toString(36).slice(2, 10);
 
         this.init();
     }
@@ -22,6 +35,7 @@ class SetbackGame {
         this.connectWebSocket();
         this.setupEventListeners();
         this.loadToken();
+        this.loadAccount();
     }
 
     // WebSocket connection
@@ -33,9 +47,14 @@ class SetbackGame {
 
         this.ws.onopen = () => {
             console.log('Connected to server');
-            if (this.yourToken) {
+            // Handshake must come first
+            this.send({ type: 'hello', protocolVersion: PROTOCOL_VERSION, features: CLIENT_FEATURES });
+            // Registered players can rejoin by account even without a saved token
+            if (this.yourToken || this.account) {
                 this.send({ type: 'rejoin', token: this.yourToken });
             }
+            // Retry actions that were never acknowledged; the server ignores ones it already applied
+            this.pendingActions.forEach(msg => this.send(msg));
         };
 
         this.ws.onmessage = (event) => {
@@ -45,6 +64,11 @@ class SetbackGame {
 
         this.ws.onclose = () => {
             console.log('Disconnected from server');
+            if (this.quietReconnect) {
+                this.quietReconnect = false;
+                this.connectWebSocket();
+                return;
+            }
             this.showMessage('Disconnected. Reconnecting...', 'error');
             setTimeout(() => this.connectWebSocket(), 2000);
         };
@@ -54,12 +78,24 @@ class SetbackGame {
         };
     }
 
+    /** @param {import('./protocol').ClientMessage} msg */
     send(msg) {
         if (this.ws && this.ws.readyState === WebSocket.OPEN) {
             this.ws.send(JSON.stringify(msg));
         }
     }
 
+    // Send a game action with a requestId so it can be safely retried
+    /** @param {import('./protocol').ClientMessage} msg */
+    sendAction(msg) {
+        const features = this.serverInfo ? this.serverInfo.features : [];
+        if (features.includes('acks')) {
+            msg.requestId = `${this.requestPrefix}-${++this.nextRequestId}`;
+            this.pendingActions.set(msg.requestId, msg);
+        }
+        this.send(msg);
+    }
+
     loadToken() {
         this.yourToken = localStorage.getItem('setback_token');
     }
@@ -69,23 +105,90 @@ class SetbackGame {
         localStorage.setItem('setback_token', token);
     }
 
+    // Accounts (optional - guests can play without one)
+    async loadAccount() {
+        try {
+            const resp = await fetch('/api/me');
+            const data = await resp.json();
+            this.setAccount(data.username || null);
+        } catch (e) {
+            this.setAccount(null);
+        }
+    }
+
+    setAccount(username) {
+        this.account = username;
+        document.getElementById('account-name').textContent = username ? `Logged in as ${username}` : '';
+        document.getElementById('account-logged-in').classList.toggle('hidden', !username);
+        document.getElementById('account-login').classList.toggle('hidden', !!username);
+    }
+
+    async submitAccount(path) {
+        const username = document.getElementById('account-username').value.trim();
+        const password = document.getElementById('account-password').value;
+        const resp = await fetch(path, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({ username, password })
+        });
+        const data = await resp.json();
+        if (!resp.ok) {
+            this.showMessage(data.error || 'Login failed', 'error');
+            return;
+        }
+        document.getElementById('account-password').value = '';
+        this.setAccount(data.username);
+        // Reconnect so the server sees the new session cookie
+        this.reconnect();
+    }
+
+    async logout() {
+        await fetch('/api/logout', { method: 'POST' });
+        this.setAccount(null);
+        this.reconnect();
+    }
+
+    reconnect() {
+        this.quietReconnect = true;
+        this.ws.close();
+    }
+
     // Message handling
+    /** @param {import('./protocol').ServerMessage} msg */
     handleMessage(msg) {
         console.log('Received:', msg);
 
         switch (msg.type) {
+            case 'welcome':
+                this.serverInfo = msg.welcome;
+                break;
             case 'stateUpdate':
+                this.lastStateMsg = msg;
+                this.seq = msg.seq || 0;
                 this.handleStateUpdate(msg);
                 break;
+            case 'stateDelta':
+                this.handleStateDelta(msg);
+                break;
+            case 'ack':
+                this.pendingActions.delete(msg.requestId);
+                break;
             case 'error':
+                if (msg.requestId) {
+                    this.pendingActions.delete(msg.requestId);
+                }
                 // Silently handle rejoin failures (stale token) - just clear the token
-                if (msg.error.message === 'rejoin_failed') {
+                if (msg.error.code === 'rejoin_failed') {
                     localStorage.removeItem('setback_token');
                     this.yourToken = null;
                 } else {
-                    this.showMessage(msg.error.message, 'error');
+                    this.showMessage(this.errorText(msg.error), 'error');
                 }
                 break;
+            case 'session':
+                // Token is issued on join and rotated on every rejoin
+                this.saveToken(msg.yourToken);
+                break;
             case 'scoreUpdate':
                 this.lastScoreResult = msg.scoreResult;
                 this.handleScoreUpdate(msg.scoreResult);
@@ -93,6 +196,73 @@ class SetbackGame {
             case 'gameOver':
                 this.handleGameOver(msg.winningTeam);
                 break;
+            case 'chatMessage':
+                this.appendChat(msg.chat);
+                break;
+            case 'emote':
+                this.handleEmote(msg.emote);
+                break;
+            case 'chatHistory':
+                document.getElementById('chat-messages').innerHTML = '';
+                msg.chatHistory.forEach(chat => this.appendChat(chat));
+                break;
+            case 'notice':
+                this.showMessage(`Server notice: ${msg.notice}`);
+                break;
+        }
+    }
+
+    // Friendlier text for errors the player can act on; falls back to the server's message
+    errorText(error) {
+        const p = error.params || {};
+        switch (error.code) {
+            case 'not_your_turn':
+                return "It's not your turn yet.";
+            case 'bid_too_low':
+                return p.minBid > 6 ? 'The bid is already at the maximum - you can only pass.' : `Bid at least ${p.minBid}, or pass.`;
+            case 'must_follow_suit':
+                return `You must follow suit (${p.suit}) if you can.`;
+            case 'must_discard_to_six':
+                return `Discard down to 6 cards (you have ${p.handSize}).`;
+            case 'not_enough_cards':
+                return `Only ${p.remaining} cards are left in the deck - discard fewer.`;
+            case 'chat_too_long':
+                return `Chat messages can be at most ${p.max} characters.`;
+            default:
+                return error.message;
+        }
+    }
+
+    // Apply a patch against the last state; if we missed one, ask for everything again
+    handleStateDelta(msg) {
+        if (!this.lastStateMsg || (msg.baseSeq || 0) !== this.seq) {
+            console.log(`Missed state update (have ${this.seq}, delta is based on ${msg.baseSeq}); resyncing`);
+            this.send({ type: 'resync' });
+            return;
+        }
+
+        const patched = structuredClone(this.lastStateMsg);
+        this.applyPatch(patched, msg.patch);
+        this.lastStateMsg = patched;
+        this.seq = msg.seq;
+        this.handleStateUpdate(structuredClone(patched));
+    }
+
+    // Minimal JSON-patch (RFC 6902) support: add, remove and replace
+    applyPatch(doc, ops) {
+        for (const op of ops) {
+            const keys = op.path.split('/').slice(1)
+                .map(k => k.replace(/~1/g, '/').replace(/~0/g, '~'));
+            const last = keys.pop();
+            let parent = doc;
+            for (const key of keys) {
+                parent = parent[key];
+            }
+            if (op.op === 'remove') {
+                delete parent[last];
+            } else {
+                parent[last] = op.value === undefined ? null : op.value;
+            }
         }
     }
 
@@ -136,10 +306,6 @@ class SetbackGame {
             this.showMessage(`${winnerName} (Team ${winnerTeam + 1}) wins the trick!`);
         }
 
-        if (msg.yourToken) {
-            this.saveToken(msg.yourToken);
-        }
-
         this.render();
     }
 
@@ -211,6 +377,59 @@ class SetbackGame {
         this.showMessage(`Game over! ${player1} & ${player2} (Team ${winningTeam + 1}) win!`);
     }
 
+    appendChat(chat) {
+        const list = document.getElementById('chat-messages');
+        const line = document.createElement('div');
+        line.className = `chat-line chat-${chat.channel}`;
+        if (chat.seatIndex >= 0) {
+            line.classList.add(this.getTeamForSeat(chat.seatIndex) === 0 ? 'team-0-text' : 'team-1-text');
+        }
+
+        const name = document.createElement('span');
+        name.className = 'chat-name';
+        const who = chat.name || (chat.seatIndex >= 0 ? `Player ${chat.seatIndex + 1}` : 'Spectator');
+        name.textContent = chat.channel === 'team' ? `${who} (team): ` : `${who}: `;
+
+        const text = document.createElement('span');
+        text.textContent = chat.text;
+
+        line.appendChild(name);
+        line.appendChild(text);
+
+        // The house can mute a spectator from their chat line
+        const isHouse = this.state && this.yourSeat === this.state.house;
+        if (isHouse && chat.seatIndex < 0 && chat.clientId) {
+            const muteBtn = document.createElement('button');
+            muteBtn.className = 'mute-btn';
+            muteBtn.title = 'Mute chat';
+            muteBtn.textContent = '🔇';
+            muteBtn.onclick = () => this.send({ type: 'mutePlayer', clientId: chat.clientId, muted: true });
+            line.appendChild(muteBtn);
+        }
+        list.appendChild(line);
+        list.scrollTop = list.scrollHeight;
+    }
+
+    handleEmote(emote) {
+        const icons = { nice: '👍', oops: '😬', thinking: '🤔', gg: '🤝' };
+        const name = this.getPlayerLabel(emote.seatIndex);
+        this.showMessage(`${name}: ${icons[emote.emote] || ''} ${emote.emote}`);
+    }
+
+    sendChat() {
+        const input = document.getElementById('chat-input');
+        const text = input.value.trim();
+        if (!text) return;
+
+        const msg = {
+            type: 'chat',
+            channel: document.getElementById('chat-channel').value,
+            text: text
+        };
+        this.send(msg);
+        input.value = '';
+    }
+
     // Rendering
     render() {
         if (!this.state) return;
@@ -339,6 +558,7 @@ class SetbackGame {
             const isThisPlayerHouse = idx === this.state.house;
             const canKick = isHouse && idx !== this.yourSeat && player.name; // House can kick other seated players
             const canTransfer = isHouse && idx !== this.yourSeat && player.name && player.connected; // House can transfer to other connected players
+            const canMute = isHouse && idx !== this.yourSeat && player.name; // House can mute other seated players
 
             if (player.name) {
                 let nameHtml = player.name;
@@ -351,6 +571,12 @@ class SetbackGame {
                 if (canTransfer) {
                     nameHtml += ` <button class="transfer-btn" data-seat="${idx}" title="Make House">🏠</button>`;
                 }
+                if (canMute) {
+                    const muteTitle = player.muted ? 'Unmute chat' : 'Mute chat';
+                    nameHtml += ` <button class="mute-btn" data-seat="${idx}" title="${muteTitle}">${player.muted ? '🔇' : '🔈'}</button>`;
+                } else if (player.muted) {
+                    nameHtml += ' <span class="muted-badge" title="Muted">🔇</span>';
+                }
                 nameEl.innerHTML = nameHtml;
             } else if (!player.connected && this.state.phase !== 'lobby') {
                 nameEl.innerHTML = '<em>(open seat)</em>';
@@ -384,6 +610,17 @@ class SetbackGame {
                 };
             }
 
+            // Add click handler for mute button
+            const muteBtn = nameEl.querySelector('.mute-btn');
+            if (muteBtn) {
+                muteBtn.onclick = (e) => {
+                    e.stopPropagation();
+                    const seatToMute = parseInt(muteBtn.dataset.seat);
+                    const muted = !this.state.players[seatToMute]?.muted;
+                    this.send({ type: 'mutePlayer', seatIndex: seatToMute, muted: muted });
+                };
+            }
+
             // Status text (no card counts shown to other players)
             let status = '';
             if (this.state.phase === 'bidding') {
@@ -870,6 +1107,21 @@ class SetbackGame {
             resetGameBtn.classList.add('hidden');
         }
 
+        // Emote toggle only visible to house; emote buttons only to seated players
+        const toggleEmotesBtn = document.getElementById('toggle-emotes-btn');
+        if (isHouse) {
+            toggleEmotesBtn.textContent = this.state.emotesEnabled ? 'Disable Emotes' : 'Enable Emotes';
+            toggleEmotesBtn.classList.remove('hidden');
+        } else {
+            toggleEmotesBtn.classList.add('hidden');
+        }
+        const emoteButtons = document.getElementById('emote-buttons');
+        if (isSeated && this.state.emotesEnabled) {
+            emoteButtons.classList.remove('hidden');
+        } else {
+            emoteButtons.classList.add('hidden');
+        }
+
         // Always show session controls
         sessionControls.classList.remove('hidden');
 
@@ -1101,6 +1353,39 @@ class SetbackGame {
             this.render();
         };
 
+        // Account controls
+        document.getElementById('login-btn').onclick = () => {
+            this.submitAccount('/api/login');
+        };
+        document.getElementById('register-btn').onclick = () => {
+            this.submitAccount('/api/register');
+        };
+        document.getElementById('logout-link').onclick = (e) => {
+            e.preventDefault();
+            this.logout();
+        };
+
+        // Emotes
+        document.querySelectorAll('.emote-btn').forEach(btn => {
+            btn.onclick = () => {
+                this.send({ type: 'sendEmote', emote: btn.dataset.emote });
+            };
+        });
+
+        document.getElementById('toggle-emotes-btn').onclick = () => {
+            this.send({ type: 'setEmotes', enabled: !this.state.emotesEnabled });
+        };
+
+        // Chat
+        document.getElementById('chat-send-btn').onclick = () => {
+            this.sendChat();
+        };
+        document.getElementById('chat-input').onkeydown = (e) => {
+            if (e.key === 'Enter') {
+                this.sendChat();
+            }
+        };
+
         // Leave seat button
         document.getElementById('leave-seat-btn').onclick = () => {
             this.leaveSeat();
@@ -1125,35 +1410,35 @@ class SetbackGame {
         });
 
         document.getElementById('start-game-btn').onclick = () => {
-            this.send({ type: 'startGame' });
+            this.sendAction({ type: 'startGame' });
         };
 
         document.querySelectorAll('.bid-btn').forEach(btn => {
             btn.onclick = () => {
                 const amount = parseInt(btn.dataset.bid);
-                this.send({ type: 'placeBid', amount: amount });
+                this.sendAction({ type: 'placeBid', amount: amount });
             };
         });
 
         document.getElementById('new-hand-btn').onclick = () => {
-            this.send({ type: 'newHand' });
+            this.sendAction({ type: 'newHand' });
         };
 
         document.getElementById('new-game-btn').onclick = () => {
-            this.send({ type: 'newHand' });
+            this.sendAction({ type: 'newHand' });
         };
 
         // Kitty controls
         document.querySelectorAll('.trump-btn').forEach(btn => {
             btn.onclick = () => {
                 this.selectedTrump = btn.dataset.suit;
-                this.send({ type: 'selectTrump', trumpSuit: this.selectedTrump });
+                this.sendAction({ type: 'selectTrump', trumpSuit: this.selectedTrump });
             };
         });
 
         document.getElementById('take-kitty-btn').onclick = () => {
             const cardIds = Array.from(this.selectedKittyCards);
-            this.send({ type: 'takeKitty', cardIds: cardIds });
+            this.sendAction({ type: 'takeKitty', cardIds: cardIds });
             // Cards will be moved to hand on next state update
             this.selectedKittyCards.clear();
         };
@@ -1176,21 +1461,21 @@ class SetbackGame {
 
         document.getElementById('finalize-kitty-btn').onclick = () => {
             const cardIds = Array.from(this.selectedDiscards);
-            this.send({ type: 'discard', cardIds: cardIds });
+            this.sendAction({ type: 'discard', cardIds: cardIds });
             this.selectedDiscards.clear();
         };
 
         // Discard phase controls
         document.getElementById('confirm-discard-btn').onclick = () => {
             const cardIds = Array.from(this.selectedDrawDiscards);
-            this.send({ type: 'discardDraw', cardIds: cardIds });
+            this.sendAction({ type: 'discardDraw', cardIds: cardIds });
             this.selectedDrawDiscards.clear();
         };
 
         // Discard All button - discards all 6 cards and draws 6 new
         document.getElementById('discard-all-btn').onclick = () => {
             const allCardIds = this.yourHand.map(card => card.id);
-            this.send({ type: 'discardDraw', cardIds: allCardIds });
+            this.sendAction({ type: 'discardDraw', cardIds: allCardIds });
             this.selectedDrawDiscards.clear();
         };
 
@@ -1238,7 +1523,7 @@ class SetbackGame {
     }
 
     playCard(cardId) {
-        this.send({ type: 'playCard', cardId: cardId });
+        this.sendAction({ type: 'playCard', cardId: cardId });
     }
 
     showMessage(text, type = 'info') {
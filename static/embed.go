@@ -0,0 +1,10 @@
+// Package static holds the web client, embedded so the server binary can
+// run from any directory
+package static
+
+import "embed"
+
+// Files is the web client: HTML, JavaScript, CSS and card SVGs
+//
+//go:embed *.html *.js *.css *.ts cards
+var Files embed.FS
@@ -0,0 +1,237 @@
+// Code generated by cmd/protocolgen from server/protocol.go. DO NOT EDIT.
+// Setback WebSocket protocol v1
+
+/** Messages a client may send */
+export type ClientMessageType = "hello" | "joinTable" | "leaveSeat" | "changeName" | "kickPlayer" | "transferHouse" | "mutePlayer" | "startGame" | "placeBid" | "selectTrump" | "takeKitty" | "discard" | "discardDraw" | "playCard" | "rejoin" | "newHand" | "resetGame" | "chat" | "resync" | "sendEmote" | "setEmotes";
+
+/** Messages the server may send */
+export type ServerMessageType = "welcome" | "stateUpdate" | "stateDelta" | "error" | "scoreUpdate" | "gameOver" | "chatMessage" | "chatHistory" | "emote" | "session" | "ack" | "notice";
+
+/** ClientMessage represents a message from client to server */
+export interface ClientMessage {
+    type: ClientMessageType;
+    /** Optional; echoed in the ack or error, retries are deduped */
+    requestId?: string;
+    seatIndex?: number;
+    playerName?: string;
+    /** Bid amount (0 = pass) */
+    amount?: number;
+    cardId?: string;
+    /** For taking/discarding multiple cards */
+    cardIds?: string[];
+    /** For selecting trump */
+    trumpSuit?: string;
+    /** Session token for rejoin */
+    token?: string;
+    /** Chat text */
+    text?: string;
+    /** Chat channel (table or team) */
+    channel?: ChatChannel;
+    /** For muting a player */
+    muted?: boolean;
+    /** For muting a spectator, from their chat messages */
+    clientId?: number;
+    /** Emote name from the catalog */
+    emote?: string;
+    /** For turning emotes on or off */
+    enabled?: boolean;
+    /** Hello: protocol version the client speaks */
+    protocolVersion?: number;
+    /** Hello: optional features the client supports */
+    features?: string[];
+}
+
+/** ChatChannel identifies who a chat message is addressed to */
+export type ChatChannel = "table" | "team";
+
+/** ServerMessage represents a message from server to client */
+export interface ServerMessage {
+    type: ServerMessageType;
+    /** Echoed from the request this answers */
+    requestId?: string;
+    state?: PublicState;
+    yourHand?: Card[];
+    /** Shown to bid winner during kitty phase */
+    kitty?: Card[];
+    yourSeat?: number;
+    yourToken?: string;
+    error?: ErrorPayload;
+    scoreResult?: ScoreResult;
+    winningTeam?: number;
+    chat?: ChatMessage;
+    chatHistory?: ChatMessage[];
+    emote?: EmotePayload;
+    welcome?: WelcomePayload;
+    /** Operator announcement text */
+    notice?: string;
+    /** State versioning: every state broadcast gets the next Seq. A stateDelta applies only on top of the update numbered BaseSeq. */
+    seq?: number;
+    baseSeq?: number;
+    patch?: PatchOp[];
+}
+
+/** PublicState is the game state visible to all players */
+export interface PublicState {
+    phase: Phase;
+    players: PublicPlayer[] | null;
+    teams: [TeamState, TeamState];
+    currentTrick: TrickState | null;
+    /** Previous trick for display */
+    lastTrick: TrickState | null;
+    trump: string | null;
+    bids: Bid[] | null;
+    dealer: number;
+    currentPlayer: number;
+    tricksPlayed: number;
+    bidWinner: number;
+    winningBid: number;
+    targetScore: number;
+    /** Number of cards in kitty */
+    kittyCount: number;
+    /** Seat index of the house (game owner) */
+    house: number;
+    /** Whether trump has been played this hand */
+    trumpBroken: boolean;
+    /** Whether the house allows emotes */
+    emotesEnabled: boolean;
+}
+
+/** Phase represents the current game phase */
+export type Phase = "lobby" | "bidding" | "kitty" | "discard" | "playing" | "scoring" | "finished";
+
+/** PublicPlayer is player info visible to all */
+export interface PublicPlayer {
+    name: string;
+    seatIndex: number;
+    connected: boolean;
+    /** Number of cards in hand */
+    cardCount: number;
+    hasBid: boolean;
+    /** Has submitted discard selection (waiting for turn) */
+    discardReady: boolean;
+    /** Has completed discard and draw */
+    discardComplete: boolean;
+    /** Muted by the house */
+    muted: boolean;
+    /** Seat belongs to a registered account */
+    registered: boolean;
+}
+
+/** TeamState is team info visible to all */
+export interface TeamState {
+    playerIndices: number[] | null;
+    score: number;
+    gamesWon: number;
+}
+
+/** TrickState is the current trick visible to all */
+export interface TrickState {
+    cards: TrickCardState[] | null;
+    leader: number;
+    leadSuit: string;
+    /** Set when trick is complete */
+    winner: number;
+}
+
+/** TrickCardState is a played card visible to all */
+export interface TrickCardState {
+    card: Card;
+    playerIndex: number;
+}
+
+/** Card represents a playing card */
+export interface Card {
+    id: string;
+    suit: Suit;
+    rank: Rank;
+}
+
+/** Suit represents a card suit */
+export type Suit = 0 | 1 | 2 | 3;
+
+/** Rank represents a card rank (2-14, where 11=J, 12=Q, 13=K, 14=A) */
+export type Rank = 2 | 3 | 4 | 5 | 6 | 7 | 8 | 9 | 10 | 11 | 12 | 13 | 14;
+
+/** Bid represents a bid made by a player */
+export interface Bid {
+    playerIndex: number;
+    /** 0 = pass, 2-6 = bid */
+    amount: number;
+}
+
+/** ErrorPayload contains error information */
+export interface ErrorPayload {
+    code: string;
+    message: string;
+    /** Details for building a localized message, e.g. minBid */
+    params?: Record<string, unknown>;
+}
+
+/** ScoreResult contains the scoring breakdown for a hand */
+export interface ScoreResult {
+    /** Team that gets High point (-1 if no trump played) */
+    highTeam: number;
+    /** The high trump card */
+    highCard: string;
+    /** Team that gets Low point (-1 if no trump played) */
+    lowTeam: number;
+    /** The low trump card */
+    lowCard: string;
+    /** Team that captured Jack of trump (-1 if not played) */
+    jackTeam: number;
+    /** Team that captured Off Jack (-1 if not played) */
+    offJackTeam: number;
+    /** Team with most game points (-1 if tie) */
+    gameTeam: number;
+    /** Total points for team 0 */
+    team0Points: number;
+    /** Total points for team 1 */
+    team1Points: number;
+    /** Which team bid */
+    bidderTeam: number;
+    /** The winning bid */
+    bidAmount: number;
+    /** Did bidding team make their bid? */
+    bidMade: boolean;
+    /** Score change for team 0 */
+    team0Change: number;
+    /** Score change for team 1 */
+    team1Change: number;
+    /** Game point totals per team */
+    gamePoints: [number, number];
+}
+
+/** ChatMessage is a chat line as delivered to clients */
+export interface ChatMessage {
+    /** -1 for spectators */
+    seatIndex: number;
+    /** Spectators only: lets the house mute them */
+    clientId?: number;
+    name: string;
+    channel: ChatChannel;
+    text: string;
+    sentAt: string;
+}
+
+/** EmotePayload is an emote as delivered to clients */
+export interface EmotePayload {
+    seatIndex: number;
+    emote: string;
+}
+
+/** WelcomePayload describes the server to a client after hello */
+export interface WelcomePayload {
+    protocolVersion: number;
+    minProtocolVersion: number;
+    /** Features both sides support */
+    features: string[] | null;
+    ruleVariants: string[] | null;
+}
+
+/** PatchOp is one JSON-patch-style (RFC 6902) operation. Paths are JSON pointers into the client's last stateUpdate message. */
+export interface PatchOp {
+    /** "add", "remove" or "replace" */
+    op: string;
+    path: string;
+    value?: unknown;
+}